@@ -2,6 +2,7 @@ package contrib
 
 import (
 	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/dictionary"
 	"github.com/huichen/mlf/util"
 	"io/ioutil"
 	"log"
@@ -71,6 +72,8 @@ func LoadLibSVMDataset(path string, usingSparseRepresentation bool) data.Dataset
 			Label:       labels[fields[0]],
 			LabelString: fields[0],
 		}
+		// 回归问题的目标值，标注不是数值时为0
+		instance.Output.Value, _ = strconv.ParseFloat(fields[0], 64)
 		if usingSparseRepresentation {
 			instance.NamedFeatures = make(map[string]float64)
 		} else {
@@ -104,3 +107,78 @@ func LoadLibSVMDataset(path string, usingSparseRepresentation bool) data.Dataset
 
 	return set
 }
+
+// 按给定的标注列表载入libsvm格式文件，特征使用文件中的整数ID
+//
+// 特征保存为稀疏向量，第k个特征的ID为k，第0个特征始终是1（和LoadLibSVMDataset的稠密
+// 表示相同）；标注的ID为它在labels中的位置，labels中没有的标注会导致退出。样本的ID
+// 只由文件内容和labels决定，不依赖于样本出现的顺序，因此分别载入的数据分片（比如
+// 分布式训练中各工作进程的分片）中相同的ID有相同的含义。
+func LoadLibSVMDatasetWithLabels(path string, labels []string) data.Dataset {
+	log.Print("载入libsvm格式文件", path)
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("无法打开文件\"%v\"，错误提示：%v\n", path, err)
+	}
+
+	labelIds := make(map[string]int)
+	for i, label := range labels {
+		labelIds[label] = i
+	}
+
+	set := data.NewInmemDataset()
+	for _, l := range strings.Split(string(content), "\n") {
+		if l == "" {
+			continue
+		}
+		fields := strings.Split(l, " ")
+
+		label, ok := labelIds[fields[0]]
+		if !ok {
+			log.Fatalf("文件\"%v\"中的标注%v不在标注列表中", path, fields[0])
+		}
+		instance := new(data.Instance)
+		instance.Output = &data.InstanceOutput{Label: label}
+		instance.Features = util.NewSparseVector()
+		instance.Features.Set(0, 1)
+
+		for i := 1; i < len(fields); i++ {
+			if fields[i] == "" {
+				continue
+			}
+			fs := strings.Split(fields[i], ":")
+			fid, _ := strconv.Atoi(fs[0])
+			if fid <= 0 || len(fs) != 2 {
+				log.Fatal("文件输入格式不合法")
+			}
+			value, _ := strconv.ParseFloat(fs[1], 64)
+			instance.Features.Set(fid, value)
+		}
+		set.AddInstance(instance)
+	}
+
+	set.Finalize()
+
+	log.Print("载入数据样本数目 ", set.NumInstances())
+
+	return set
+}
+
+// 和LoadLibSVMDatasetWithLabels的ID对应的特征词典和标注词典
+//
+// 特征词典中名称"k"（k从1到maxFeature）的ID为k，和LoadLibSVMDataset稀疏表示中的特征
+// 名称一致。用LoadLibSVMDatasetWithLabels载入的数据训练的模型加上这两个词典后，
+// 也可以对使用NamedFeatures的样本做预测。
+func NewLibSVMDictionaries(maxFeature int, labels []string) (
+	featureDictionary, labelDictionary *dictionary.Dictionary) {
+	featureDictionary = dictionary.NewDictionary(1)
+	for k := 1; k <= maxFeature; k++ {
+		featureDictionary.GetIdFromName(strconv.Itoa(k))
+	}
+	labelDictionary = dictionary.NewDictionary(0)
+	for _, label := range labels {
+		labelDictionary.GetIdFromName(label)
+	}
+	return
+}
@@ -18,3 +18,24 @@ func TestSparseLibsvmLoader(t *testing.T) {
 	util.Expect(t, "0", set.GetOptions().FeatureDimension)
 	util.Expect(t, "2", set.GetOptions().NumLabels)
 }
+
+func TestLibsvmLoaderWithLabels(t *testing.T) {
+	set := LoadLibSVMDatasetWithLabels("test.txt", []string{"+1", "-1"})
+	util.Expect(t, "10", set.NumInstances())
+	util.Expect(t, "true", set.GetOptions().FeatureIsSparse)
+	util.Expect(t, "2", set.GetOptions().NumLabels)
+
+	// 特征ID为文件中的整数ID，标注ID为在标注列表中的位置
+	iterator := set.CreateIterator()
+	iterator.Start()
+	instance := iterator.GetInstance()
+	util.Expect(t, "1", instance.Output.Label)
+	util.Expect(t, "1", instance.Features.Get(0))
+	util.Expect(t, "0.368684", instance.Features.Get(1))
+	util.Expect(t, "1", instance.Features.Get(43))
+
+	features, labels := NewLibSVMDictionaries(45, []string{"+1", "-1"})
+	util.Expect(t, "43", features.TranslateIdFromName("43"))
+	util.Expect(t, "-1", features.TranslateIdFromName("46"))
+	util.Expect(t, "-1", labels.GetNameFromId(1))
+}
@@ -0,0 +1,82 @@
+package data
+
+import (
+	"sort"
+)
+
+// 数据集的列视图：按特征组织的数据
+//
+// 普通的数据集按样本遍历，列视图可以直接得到某个特征在哪些样本中出现以及对应的特征值，
+// 用于需要逐个特征访问数据的算法，比如坐标下降法。
+//
+// 建立列视图时会遍历一次数据集，列视图保存了样本的指针，因此数据集中的样本在列视图
+// 使用期间不能修改。列视图建立后是只读的，可以在多个协程中同时访问。
+type ColumnView struct {
+	// 按遍历顺序排列的样本
+	instances []*Instance
+
+	// 出现过的特征（至少有一个样本的特征值非零），从小到大排列
+	features []int
+
+	// 各特征的非零元素
+	columns map[int][]ColumnEntry
+}
+
+// 列中的一个非零元素
+type ColumnEntry struct {
+	// 样本在列视图中的序号，见ColumnView.GetInstance
+	Instance int
+
+	// 特征值
+	Value float64
+}
+
+// 遍历数据集建立列视图
+func NewColumnView(set Dataset) *ColumnView {
+	view := new(ColumnView)
+	view.instances = make([]*Instance, 0, set.NumInstances())
+	view.columns = make(map[int][]ColumnEntry)
+
+	iter := set.CreateIterator()
+	iter.Start()
+	for !iter.End() {
+		instance := iter.GetInstance()
+		iInstance := len(view.instances)
+		view.instances = append(view.instances, instance)
+		for _, k := range instance.Features.Keys() {
+			value := instance.Features.Get(k)
+			if value == 0 {
+				continue
+			}
+			view.columns[k] = append(view.columns[k], ColumnEntry{Instance: iInstance, Value: value})
+		}
+		iter.Next()
+	}
+
+	view.features = make([]int, 0, len(view.columns))
+	for k := range view.columns {
+		view.features = append(view.features, k)
+	}
+	sort.Ints(view.features)
+	return view
+}
+
+// 样本数
+func (view *ColumnView) NumInstances() int {
+	return len(view.instances)
+}
+
+// 得到第i个样本
+func (view *ColumnView) GetInstance(i int) *Instance {
+	return view.instances[i]
+}
+
+// 出现过的特征，从小到大排列，请勿修改返回的切片
+func (view *ColumnView) Features() []int {
+	return view.features
+}
+
+// 得到特征的所有非零元素，按样本序号从小到大排列，请勿修改返回的切片
+func (view *ColumnView) GetColumn(feature int) []ColumnEntry {
+	return view.columns[feature]
+}
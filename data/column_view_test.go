@@ -0,0 +1,35 @@
+package data
+
+import (
+	"github.com/huichen/mlf/util"
+	"testing"
+)
+
+func TestColumnView(t *testing.T) {
+	set := NewInmemDataset()
+
+	instance1 := new(Instance)
+	instance1.Features = util.NewSparseVector()
+	instance1.Features.Set(0, 1)
+	instance1.Features.Set(5, 2)
+	util.Expect(t, "true", set.AddInstance(instance1))
+
+	instance2 := new(Instance)
+	instance2.Features = util.NewSparseVector()
+	instance2.Features.Set(0, 1)
+	instance2.Features.Set(3, 0)
+	instance2.Features.Set(2, -1)
+	util.Expect(t, "true", set.AddInstance(instance2))
+	set.Finalize()
+
+	view := NewColumnView(set)
+	util.Expect(t, "2", view.NumInstances())
+	util.Expect(t, "true", view.GetInstance(1) == instance2)
+
+	// 值为零的特征不出现在列视图中
+	util.Expect(t, "[0 2 5]", view.Features())
+	util.Expect(t, "[{0 1} {1 1}]", view.GetColumn(0))
+	util.Expect(t, "[{1 -1}]", view.GetColumn(2))
+	util.Expect(t, "[{0 2}]", view.GetColumn(5))
+	util.Expect(t, "0", len(view.GetColumn(3)))
+}
@@ -17,4 +17,7 @@ type InstanceOutput struct {
 
 	// 标注分布，用于分类问题的输出（各分类的概率分布）
 	LabelDistribution *util.Vector
+
+	// 各分类的决策值（比如线性SVM的 w_j . x），用于没有概率输出的分类模型
+	DecisionValues *util.Vector
 }
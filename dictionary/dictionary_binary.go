@@ -0,0 +1,40 @@
+package dictionary
+
+import (
+	"github.com/huichen/mlf/util"
+	"sort"
+)
+
+// 对Dictionary结构体进行二进制串行化
+// 词条按照ID从小到大的顺序写入，保证同一词典的输出总是一致
+func (d *Dictionary) EncodeBinary(e *util.BinaryEncoder) {
+	e.WriteInt(d.minId)
+	e.WriteInt(d.maxId)
+
+	ids := make([]int, 0, len(d.idToName))
+	for id := range d.idToName {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	e.WriteInt(len(ids))
+	for _, id := range ids {
+		e.WriteInt(id)
+		e.WriteString(d.idToName[id])
+	}
+}
+
+// 对Dictionary结构体进行二进制反串行化，d原有的内容会被覆盖
+func (d *Dictionary) DecodeBinary(decoder *util.BinaryDecoder) {
+	minId := decoder.ReadInt()
+	*d = *NewDictionary(minId)
+	d.maxId = decoder.ReadInt()
+
+	numWords := decoder.ReadLength()
+	for i := 0; i < numWords && decoder.Err() == nil; i++ {
+		id := decoder.ReadInt()
+		name := decoder.ReadString()
+		d.nameToId[name] = id
+		d.idToName[id] = name
+	}
+}
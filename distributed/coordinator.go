@@ -0,0 +1,119 @@
+package distributed
+
+import (
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/util"
+	"log"
+	"net/rpc"
+)
+
+// 分布式训练的协调进程一侧，实现了optimizer.DatasetLoss
+//
+// Evaluate把权重同时发送给所有工作进程（见Worker），等待它们返回各自分片上的损失之和
+// 与偏导数之和，然后按工作进程的顺序合并，保证结果不依赖于返回的先后。将Coordinator
+// 设置给实现了optimizer.DatasetLossOptimizer的优化器（lbfgs或owlqn）后，优化器在协调
+// 进程中更新权重，每次计算目标函数时同步地汇总所有分片，结果和在单机上用全部数据优化
+// 相同（只有浮点数求和顺序带来的差别）。
+//
+// Coordinator是协程不安全的。
+type Coordinator struct {
+	addresses []string
+	clients   []*rpc.Client
+	shards    []ShardInfo
+}
+
+// 连接address中的所有工作进程，并读取各数据分片的信息
+func NewCoordinator(addresses []string) (*Coordinator, error) {
+	c := new(Coordinator)
+	c.addresses = addresses
+	for _, address := range addresses {
+		client, err := rpc.Dial("tcp", address)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.clients = append(c.clients, client)
+
+		var shard ShardInfo
+		if err := client.Call("Worker.Info", &InfoArgs{}, &shard); err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.shards = append(c.shards, shard)
+	}
+	return c, nil
+}
+
+// 关闭和所有工作进程的连接
+func (c *Coordinator) Close() error {
+	var result error
+	for _, client := range c.clients {
+		if err := client.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// 所有分片的样本总数
+func (c *Coordinator) NumInstances() int {
+	n := 0
+	for _, shard := range c.shards {
+		n += shard.NumInstances
+	}
+	return n
+}
+
+// 合并的数据集参数，标注数和特征维度取各分片的最大值，用于建立权重矩阵
+func (c *Coordinator) DatasetOptions() data.DatasetOptions {
+	options := data.DatasetOptions{IsSupervisedLearning: true}
+	for i, shard := range c.shards {
+		if i > 0 && shard.FeatureIsSparse != options.FeatureIsSparse {
+			log.Fatal("工作进程", c.addresses[i], "的特征存储方式和其它工作进程不同")
+		}
+		options.FeatureIsSparse = shard.FeatureIsSparse
+		if shard.NumLabels > options.NumLabels {
+			options.NumLabels = shard.NumLabels
+		}
+		if shard.FeatureDimension > options.FeatureDimension {
+			options.FeatureDimension = shard.FeatureDimension
+		}
+	}
+	return options
+}
+
+// 计算weights处所有分片的损失之和，偏导数之和写入derivative
+//
+// 任何一个工作进程计算失败时优化无法继续，直接退出。
+func (c *Coordinator) Evaluate(weights, derivative *util.Matrix) float64 {
+	args := new(EvaluateArgs)
+	var err error
+	if args.Weights, err = encodeMatrix(weights); err != nil {
+		log.Fatal("无法串行化权重，错误", err)
+	}
+
+	// 同时向所有工作进程发出请求
+	replies := make([]*EvaluateReply, len(c.clients))
+	calls := make([]*rpc.Call, len(c.clients))
+	for i, client := range c.clients {
+		replies[i] = new(EvaluateReply)
+		calls[i] = client.Go("Worker.Evaluate", args, replies[i], nil)
+	}
+
+	// 按固定顺序合并
+	derivative.Clear()
+	loss := float64(0)
+	for i, call := range calls {
+		<-call.Done
+		if call.Error != nil {
+			log.Fatal("工作进程", c.addresses[i], "计算失败，错误", call.Error)
+		}
+		workerDerivative, err := decodeMatrix(replies[i].Derivative)
+		if err != nil {
+			log.Fatal("无法读取工作进程", c.addresses[i], "返回的偏导数，错误", err)
+		}
+		derivative.Increment(workerDerivative, 1)
+		loss += replies[i].Loss
+	}
+	return loss
+}
@@ -0,0 +1,156 @@
+package distributed
+
+import (
+	"context"
+	"github.com/huichen/mlf/contrib"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/optimizer"
+	"github.com/huichen/mlf/supervised"
+	"github.com/huichen/mlf/util"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDistributedLbfgs(t *testing.T) {
+	testDistributedLbfgs(t, false)
+	testDistributedLbfgs(t, true)
+}
+
+func testDistributedLbfgs(t *testing.T, sparse bool) {
+	set := contrib.LoadLibSVMDataset("../testdata/a1a", sparse)
+	options := optimizer.OptimizerOptions{
+		OptimizerName:         "lbfgs",
+		RegularizationScheme:  2,
+		RegularizationFactor:  1,
+		ConvergingDeltaWeight: 1e-6,
+		MaxIterations:         50,
+	}
+
+	// 单机优化
+	newWeights := func(options data.DatasetOptions) *util.Matrix {
+		if options.FeatureIsSparse {
+			return util.NewSparseMatrix(options.NumLabels - 1)
+		}
+		return util.NewMatrix(options.NumLabels-1, options.FeatureDimension)
+	}
+	localWeights := newWeights(set.GetOptions())
+	localResult := optimizer.NewOptimizer(options).OptimizeWeights(
+		context.Background(), localWeights, supervised.MaxEntComputeInstanceDerivative, set)
+
+	// 三个工作进程，第i个样本属于第i % 3个分片
+	addresses := []string{}
+	for iShard := 0; iShard < 3; iShard++ {
+		shard := data.NewSkipDataset(set, []data.SkipBucket{
+			{SkipMode: true, NumInstances: iShard},
+			{SkipMode: false, NumInstances: 1},
+			{SkipMode: true, NumInstances: 2 - iShard},
+		})
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		go NewWorker(shard, supervised.MaxEntComputeInstanceDerivative, 2).Serve(listener)
+		addresses = append(addresses, listener.Addr().String())
+	}
+
+	coordinator, err := NewCoordinator(addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coordinator.Close()
+	util.Expect(t, "1605", coordinator.NumInstances())
+	datasetOptions := coordinator.DatasetOptions()
+	util.Expect(t, "true", datasetOptions.FeatureIsSparse == sparse)
+	util.Expect(t, "true", datasetOptions.NumLabels == set.GetOptions().NumLabels)
+
+	opt := optimizer.NewOptimizer(options)
+	opt.(optimizer.DatasetLossOptimizer).SetDatasetLoss(coordinator)
+	weights := newWeights(datasetOptions)
+	result := opt.OptimizeWeights(context.Background(), weights, nil, nil)
+
+	// 除了浮点数求和的顺序，计算和单机相同
+	util.ExpectNear(t, localResult.Loss, result.Loss, 1e-8)
+	util.Expect(t, "true", result.Iterations == localResult.Iterations)
+	for _, k := range localWeights.GetValues(0).Keys() {
+		util.ExpectNear(t, localWeights.Get(0, k), weights.Get(0, k), 1e-4)
+	}
+}
+
+func TestDistributedLbfgsShardFiles(t *testing.T) {
+	// 每个工作进程从自己的文件载入分片，各分片中标注和特征出现的顺序不同
+	labels := []string{"-1", "+1"}
+	content, err := ioutil.ReadFile("../testdata/a1a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	shards := make([][]string, 3)
+	for i, l := range lines {
+		shards[i%3] = append(shards[i%3], l)
+	}
+	// 第二个分片以标注为+1的样本开头
+	for !strings.HasPrefix(shards[1][0], "+1") {
+		shards[1] = append(shards[1][1:], shards[1][0])
+	}
+
+	options := optimizer.OptimizerOptions{
+		OptimizerName:         "lbfgs",
+		RegularizationScheme:  2,
+		RegularizationFactor:  1,
+		ConvergingDeltaWeight: 1e-6,
+		MaxIterations:         50,
+	}
+	set := contrib.LoadLibSVMDatasetWithLabels("../testdata/a1a", labels)
+	localWeights := util.NewSparseMatrix(1)
+	localResult := optimizer.NewOptimizer(options).OptimizeWeights(
+		context.Background(), localWeights, supervised.MaxEntComputeInstanceDerivative, set)
+
+	addresses := []string{}
+	for iShard, shard := range shards {
+		path := "test_shard" + string('0'+rune(iShard)) + ".txt"
+		if err := ioutil.WriteFile(path, []byte(strings.Join(shard, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(path)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		worker := NewWorker(contrib.LoadLibSVMDatasetWithLabels(path, labels),
+			supervised.MaxEntComputeInstanceDerivative, 2)
+		go worker.Serve(listener)
+		addresses = append(addresses, listener.Addr().String())
+	}
+
+	coordinator, err := NewCoordinator(addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coordinator.Close()
+	util.Expect(t, "1605", coordinator.NumInstances())
+
+	opt := optimizer.NewOptimizer(options)
+	opt.(optimizer.DatasetLossOptimizer).SetDatasetLoss(coordinator)
+	weights := util.NewSparseMatrix(1)
+	result := opt.OptimizeWeights(context.Background(), weights, nil, nil)
+
+	util.ExpectNear(t, localResult.Loss, result.Loss, 1e-8)
+	util.Expect(t, "true", result.Iterations == localResult.Iterations)
+	for _, k := range localWeights.GetValues(0).Keys() {
+		util.ExpectNear(t, localWeights.Get(0, k), weights.Get(0, k), 1e-4)
+	}
+}
+
+func TestWorkerRejectsCorruptWeights(t *testing.T) {
+	// 不合法的请求返回错误，工作进程不会崩溃
+	set := contrib.LoadLibSVMDataset("../testdata/a1a", true)
+	service := &workerService{NewWorker(set, supervised.MaxEntComputeInstanceDerivative, 1)}
+	err := service.Evaluate(&EvaluateArgs{Weights: []byte{1, 2, 0xff, 0xff, 0xff, 0xff, 0x0f}}, new(EvaluateReply))
+	util.Expect(t, "true", err != nil)
+}
@@ -0,0 +1,192 @@
+package distributed
+
+import (
+	"errors"
+	"github.com/huichen/mlf/util"
+	"log"
+	"net/rpc"
+	"sort"
+)
+
+// 训练进程一侧的参数服务器客户端
+//
+// 特征k的权重保存在第k % len(addresses)个参数服务器上。每个训练进程用自己的编号创建
+// 一个客户端，反复地用Pull读取一批样本需要的权重，计算后用Push提交权重的增量。每次
+// Push后训练进程的时钟加一，Push会发送给所有参数服务器（没有增量的服务器也会收到），
+// 这样所有服务器看到的时钟相同。训练结束时请调用Finish，否则其它训练进程会在时钟
+// 超前Staleness后一直等待。
+//
+// 任何一个参数服务器出错时训练无法继续，直接退出。ParameterClient是协程不安全的。
+type ParameterClient struct {
+	addresses []string
+	clients   []*rpc.Client
+	trainer   int
+	numLabels int
+	clock     int
+}
+
+// 连接所有参数服务器，trainer为本训练进程的编号
+func NewParameterClient(addresses []string, trainer int) (*ParameterClient, error) {
+	c := new(ParameterClient)
+	c.addresses = addresses
+	c.trainer = trainer
+	for i, address := range addresses {
+		client, err := rpc.Dial("tcp", address)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.clients = append(c.clients, client)
+
+		var info ParameterServerInfo
+		if err := client.Call("ParameterServer.Info", &InfoArgs{}, &info); err != nil {
+			c.Close()
+			return nil, err
+		}
+		if i > 0 && info.NumLabels != c.numLabels {
+			c.Close()
+			return nil, errors.New("参数服务器的分类数目不一致")
+		}
+		if trainer < 0 || trainer >= info.NumTrainers {
+			c.Close()
+			return nil, errors.New("训练进程编号超出范围")
+		}
+		c.numLabels = info.NumLabels
+	}
+	return c, nil
+}
+
+// 关闭和所有参数服务器的连接
+func (c *ParameterClient) Close() error {
+	var result error
+	for _, client := range c.clients {
+		if err := client.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// 分类数目
+func (c *ParameterClient) NumLabels() int {
+	return c.numLabels
+}
+
+// 已经提交的更新次数
+func (c *ParameterClient) Clock() int {
+	return c.clock
+}
+
+// 读取keys中特征的权重写入weights（有NumLabels-1行的稀疏矩阵），其它特征的权重不变
+//
+// 当本训练进程的时钟超前最慢的训练进程Staleness以上时等待。
+func (c *ParameterClient) Pull(keys []int, weights *util.Matrix) {
+	shardKeys := c.splitKeys(keys)
+	replies := make([]*PullReply, len(c.clients))
+	calls := make([]*rpc.Call, len(c.clients))
+	for i, client := range c.clients {
+		if len(shardKeys[i]) == 0 {
+			continue
+		}
+		replies[i] = new(PullReply)
+		args := &PullArgs{Trainer: c.trainer, Clock: c.clock, Keys: shardKeys[i]}
+		calls[i] = client.Go("ParameterServer.Pull", args, replies[i], nil)
+	}
+
+	numRows := c.numLabels - 1
+	for i, call := range calls {
+		if call == nil {
+			continue
+		}
+		<-call.Done
+		if call.Error != nil {
+			log.Fatal("无法从参数服务器", c.addresses[i], "读取权重，错误", call.Error)
+		}
+		for j, k := range shardKeys[i] {
+			for iLabel := 0; iLabel < numRows; iLabel++ {
+				weights.Set(iLabel, k, replies[i].Values[j*numRows+iLabel])
+			}
+		}
+	}
+}
+
+// 提交权重的增量delta（只发送其中出现的元素），然后将时钟加一
+func (c *ParameterClient) Push(delta *util.Matrix) {
+	numRows := c.numLabels - 1
+	shardKeys := c.splitKeys(matrixKeys(delta))
+	c.clock++
+
+	calls := make([]*rpc.Call, len(c.clients))
+	for i, client := range c.clients {
+		args := &PushArgs{Trainer: c.trainer, Clock: c.clock, Keys: shardKeys[i]}
+		args.Deltas = make([]float64, len(shardKeys[i])*numRows)
+		for j, k := range shardKeys[i] {
+			for iLabel := 0; iLabel < numRows; iLabel++ {
+				args.Deltas[j*numRows+iLabel] = delta.Get(iLabel, k)
+			}
+		}
+		calls[i] = client.Go("ParameterServer.Push", args, new(PushReply), nil)
+	}
+	for i, call := range calls {
+		<-call.Done
+		if call.Error != nil {
+			log.Fatal("无法向参数服务器", c.addresses[i], "提交更新，错误", call.Error)
+		}
+	}
+}
+
+// 通知所有参数服务器本训练进程结束
+func (c *ParameterClient) Finish() {
+	for i, client := range c.clients {
+		if err := client.Call("ParameterServer.Finish",
+			&FinishArgs{Trainer: c.trainer}, new(PushReply)); err != nil {
+			log.Fatal("无法通知参数服务器", c.addresses[i], "，错误", err)
+		}
+	}
+}
+
+// 读取所有参数服务器上的全部权重，不等待其它训练进程，用于保存模型
+func (c *ParameterClient) Snapshot() *util.Matrix {
+	numRows := c.numLabels - 1
+	weights := util.NewSparseMatrix(numRows)
+	for i, client := range c.clients {
+		reply := new(SnapshotReply)
+		if err := client.Call("ParameterServer.Snapshot", &SnapshotArgs{}, reply); err != nil {
+			log.Fatal("无法从参数服务器", c.addresses[i], "读取权重，错误", err)
+		}
+		for j, k := range reply.Keys {
+			for iLabel := 0; iLabel < numRows; iLabel++ {
+				if value := reply.Values[j*numRows+iLabel]; value != 0 {
+					weights.Set(iLabel, k, value)
+				}
+			}
+		}
+	}
+	return weights
+}
+
+// 按所在的参数服务器对特征分组
+func (c *ParameterClient) splitKeys(keys []int) [][]int {
+	shardKeys := make([][]int, len(c.clients))
+	for _, k := range keys {
+		shard := k % len(c.clients)
+		shardKeys[shard] = append(shardKeys[shard], k)
+	}
+	return shardKeys
+}
+
+// 矩阵各行中出现的特征，从小到大排列
+func matrixKeys(m *util.Matrix) []int {
+	seen := make(map[int]bool)
+	for iLabel := 0; iLabel < m.NumLabels(); iLabel++ {
+		for _, k := range m.GetValues(iLabel).Keys() {
+			seen[k] = true
+		}
+	}
+	keys := make([]int, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
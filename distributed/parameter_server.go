@@ -0,0 +1,217 @@
+package distributed
+
+import (
+	"errors"
+	"github.com/huichen/mlf/util"
+	"math"
+	"net"
+	"net/rpc"
+	"sync"
+)
+
+// 参数服务器的选项
+type ParameterServerOptions struct {
+	// 分类数目，权重矩阵有NumLabels-1行
+	NumLabels int
+
+	// 训练进程的数目，训练进程的编号为[0, NumTrainers-1]
+	NumTrainers int
+
+	// 最大的时钟差（stale synchronous parallel）。时钟为训练进程提交更新的次数，
+	// 时钟为c的训练进程只有在所有训练进程的时钟都不小于c-Staleness时才能读取权重，
+	// 值为0时所有训练进程同步地前进（bulk synchronous parallel）
+	Staleness int
+}
+
+// 参数服务器，保存稀疏权重的一个分片
+//
+// 特征k的权重保存在第k % numShards个服务器上（见ParameterClient）。训练进程通过
+// net/rpc读取（Pull）需要的权重，计算更新后提交（Push）权重的增量，服务器直接把增量
+// 加到权重上。服务器记录每个训练进程的时钟，读取时按Staleness等待较慢的训练进程，
+// 这样任何训练进程读到的权重最多缺少其它训练进程最近Staleness次的更新。
+type ParameterServer struct {
+	options ParameterServerOptions
+
+	lock    sync.Mutex
+	clocks  sync.Cond
+	weights *util.Matrix
+
+	// 各训练进程的时钟，结束的训练进程为math.MaxInt32
+	trainerClocks []int
+}
+
+// Info请求的返回值
+type ParameterServerInfo struct {
+	NumLabels   int
+	NumTrainers int
+}
+
+// Pull请求的参数和返回值，Values[i*(NumLabels-1)+l]为Keys[i]在第l行的权重
+type PullArgs struct {
+	Trainer int
+	Clock   int
+	Keys    []int
+}
+
+type PullReply struct {
+	Values []float64
+}
+
+// Push请求的参数，Deltas的格式和PullReply.Values相同。提交后训练进程的时钟为Clock
+type PushArgs struct {
+	Trainer int
+	Clock   int
+	Keys    []int
+	Deltas  []float64
+}
+
+type PushReply struct{}
+
+// Finish请求的参数，训练进程结束后不再阻塞其它训练进程
+type FinishArgs struct {
+	Trainer int
+}
+
+// Snapshot请求的参数和返回值，返回分片中的所有权重，格式和PullReply相同，不等待任何训练进程
+type SnapshotArgs struct{}
+
+type SnapshotReply struct {
+	Keys   []int
+	Values []float64
+}
+
+// 创建参数服务器
+func NewParameterServer(options ParameterServerOptions) *ParameterServer {
+	server := new(ParameterServer)
+	server.options = options
+	if server.options.NumTrainers <= 0 {
+		server.options.NumTrainers = 1
+	}
+	server.clocks.L = &server.lock
+	server.weights = util.NewSparseMatrix(options.NumLabels - 1)
+	server.trainerClocks = make([]int, server.options.NumTrainers)
+	return server
+}
+
+// 在listener上接受训练进程的连接，直到listener被关闭
+func (server *ParameterServer) Serve(listener net.Listener) {
+	rpcServer := rpc.NewServer()
+	rpcServer.RegisterName("ParameterServer", &parameterService{server})
+	rpcServer.Accept(listener)
+}
+
+// 监听TCP地址address并提供服务
+func (server *ParameterServer) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	server.Serve(listener)
+	return nil
+}
+
+// 所有训练进程中最小的时钟，调用时需要持有锁
+func (server *ParameterServer) minClock() int {
+	clock := math.MaxInt32
+	for _, c := range server.trainerClocks {
+		if c < clock {
+			clock = c
+		}
+	}
+	return clock
+}
+
+func (server *ParameterServer) checkTrainer(trainer int) error {
+	if trainer < 0 || trainer >= len(server.trainerClocks) {
+		return errors.New("训练进程编号超出范围")
+	}
+	return nil
+}
+
+// 注册到rpc服务器的对象，只包含rpc方法
+type parameterService struct {
+	server *ParameterServer
+}
+
+func (s *parameterService) Info(args *InfoArgs, reply *ParameterServerInfo) error {
+	reply.NumLabels = s.server.options.NumLabels
+	reply.NumTrainers = s.server.options.NumTrainers
+	return nil
+}
+
+func (s *parameterService) Pull(args *PullArgs, reply *PullReply) error {
+	server := s.server
+	if err := server.checkTrainer(args.Trainer); err != nil {
+		return err
+	}
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	// 等待较慢的训练进程
+	for server.minClock() < args.Clock-server.options.Staleness {
+		server.clocks.Wait()
+	}
+
+	numRows := server.weights.NumLabels()
+	reply.Values = make([]float64, len(args.Keys)*numRows)
+	for i, k := range args.Keys {
+		for iLabel := 0; iLabel < numRows; iLabel++ {
+			reply.Values[i*numRows+iLabel] = server.weights.Get(iLabel, k)
+		}
+	}
+	return nil
+}
+
+func (s *parameterService) Push(args *PushArgs, reply *PushReply) error {
+	server := s.server
+	if err := server.checkTrainer(args.Trainer); err != nil {
+		return err
+	}
+	numRows := server.weights.NumLabels()
+	if len(args.Deltas) != len(args.Keys)*numRows {
+		return errors.New("权重增量的长度和特征数不匹配")
+	}
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	for i, k := range args.Keys {
+		for iLabel := 0; iLabel < numRows; iLabel++ {
+			if delta := args.Deltas[i*numRows+iLabel]; delta != 0 {
+				server.weights.Set(iLabel, k, server.weights.Get(iLabel, k)+delta)
+			}
+		}
+	}
+	if args.Clock > server.trainerClocks[args.Trainer] {
+		server.trainerClocks[args.Trainer] = args.Clock
+		server.clocks.Broadcast()
+	}
+	return nil
+}
+
+func (s *parameterService) Finish(args *FinishArgs, reply *PushReply) error {
+	server := s.server
+	if err := server.checkTrainer(args.Trainer); err != nil {
+		return err
+	}
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.trainerClocks[args.Trainer] = math.MaxInt32
+	server.clocks.Broadcast()
+	return nil
+}
+
+func (s *parameterService) Snapshot(args *SnapshotArgs, reply *SnapshotReply) error {
+	server := s.server
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	numRows := server.weights.NumLabels()
+	reply.Keys = matrixKeys(server.weights)
+	reply.Values = make([]float64, len(reply.Keys)*numRows)
+	for i, k := range reply.Keys {
+		for iLabel := 0; iLabel < numRows; iLabel++ {
+			reply.Values[i*numRows+iLabel] = server.weights.Get(iLabel, k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package distributed
+
+import (
+	"github.com/huichen/mlf/util"
+	"net"
+	"testing"
+	"time"
+)
+
+func startParameterServers(t *testing.T, numShards int, options ParameterServerOptions) []string {
+	addresses := []string{}
+	for i := 0; i < numShards; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go NewParameterServer(options).Serve(listener)
+		addresses = append(addresses, listener.Addr().String())
+	}
+	return addresses
+}
+
+func TestParameterServer(t *testing.T) {
+	addresses := startParameterServers(t, 2, ParameterServerOptions{
+		NumLabels:   3,
+		NumTrainers: 2,
+		Staleness:   0,
+	})
+	trainer0, err := NewParameterClient(addresses, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trainer0.Close()
+	trainer1, err := NewParameterClient(addresses, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trainer1.Close()
+	_, err = NewParameterClient(addresses, 2)
+	util.Expect(t, "true", err != nil)
+
+	// 两个训练进程的增量相加
+	delta := util.NewSparseMatrix(2)
+	delta.Set(0, 3, 1)
+	delta.Set(1, 4, 2)
+	trainer0.Push(delta)
+	trainer1.Push(delta)
+
+	weights := util.NewSparseMatrix(2)
+	trainer0.Pull([]int{3, 4, 5}, weights)
+	util.Expect(t, "2", weights.Get(0, 3))
+	util.Expect(t, "4", weights.Get(1, 4))
+	util.Expect(t, "0", weights.Get(0, 5))
+
+	// Staleness为0时，trainer0的时钟超前后读取需要等待trainer1
+	trainer0.Push(delta)
+	pulled := make(chan bool)
+	go func() {
+		trainer0.Pull([]int{3}, weights)
+		pulled <- true
+	}()
+	select {
+	case <-pulled:
+		t.Error("读取没有等待较慢的训练进程")
+	case <-time.After(100 * time.Millisecond):
+	}
+	trainer1.Finish()
+	<-pulled
+	util.Expect(t, "3", weights.Get(0, 3))
+
+	snapshot := trainer1.Snapshot()
+	util.Expect(t, "3", snapshot.Get(0, 3))
+	util.Expect(t, "6", snapshot.Get(1, 4))
+	util.Expect(t, "0", snapshot.Get(1, 3))
+}
@@ -0,0 +1,137 @@
+package distributed
+
+import (
+	"bytes"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/optimizer"
+	"github.com/huichen/mlf/util"
+	"net"
+	"net/rpc"
+	"sync"
+)
+
+// 分布式训练的工作进程
+//
+// 每个工作进程保存数据集的一个分片，通过net/rpc响应协调进程（见Coordinator）的请求：
+// 在收到的权重处用多个协程计算分片上所有样本的损失之和与偏导数之和（不包括正则化项），
+// 返回给协调进程。工作进程不保存优化的状态，协调进程重启后可以继续使用。
+type Worker struct {
+	set            data.Dataset
+	derivativeFunc optimizer.ComputeInstanceDerivativeFunc
+	numThreads     int
+
+	// 同一时间只计算一个请求，loss在收到第一个请求时按权重的类型和维度创建
+	lock       sync.Mutex
+	loss       optimizer.DatasetLoss
+	derivative *util.Matrix
+}
+
+// 工作进程上数据分片的信息
+type ShardInfo struct {
+	NumInstances     int
+	NumLabels        int
+	FeatureDimension int
+	FeatureIsSparse  bool
+}
+
+// Info请求的参数，没有内容
+type InfoArgs struct{}
+
+// Evaluate请求的参数和返回值，矩阵以util.Matrix的二进制格式传输
+type EvaluateArgs struct {
+	Weights []byte
+}
+
+type EvaluateReply struct {
+	Loss       float64
+	Derivative []byte
+}
+
+// 创建工作进程，numThreads为计算使用的协程数，值为0时使用所有CPU
+func NewWorker(set data.Dataset, derivative_func optimizer.ComputeInstanceDerivativeFunc,
+	numThreads int) *Worker {
+	worker := new(Worker)
+	worker.set = set
+	worker.derivativeFunc = derivative_func
+	worker.numThreads = numThreads
+	return worker
+}
+
+// 在listener上接受协调进程的连接，直到listener被关闭
+func (worker *Worker) Serve(listener net.Listener) {
+	server := rpc.NewServer()
+	server.RegisterName("Worker", &workerService{worker})
+	server.Accept(listener)
+}
+
+// 监听TCP地址address（比如":8000"）并提供服务
+func (worker *Worker) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	worker.Serve(listener)
+	return nil
+}
+
+// 计算weights处分片上的损失之和，返回串行化的偏导数之和
+// 偏导数在持有锁时串行化，避免被同时到达的下一个请求覆盖
+func (worker *Worker) evaluate(weights *util.Matrix) (float64, []byte, error) {
+	worker.lock.Lock()
+	defer worker.lock.Unlock()
+
+	if worker.loss == nil || !sameShape(worker.derivative, weights) {
+		worker.loss = optimizer.NewLocalDatasetLoss(
+			weights, worker.derivativeFunc, worker.set, worker.numThreads)
+		worker.derivative = weights.Populate()
+	}
+	loss := worker.loss.Evaluate(weights, worker.derivative)
+	derivative, err := encodeMatrix(worker.derivative)
+	return loss, derivative, err
+}
+
+// 两个矩阵的类型和维度是否相同
+func sameShape(m1, m2 *util.Matrix) bool {
+	if m1.IsSparse() != m2.IsSparse() || m1.NumLabels() != m2.NumLabels() {
+		return false
+	}
+	return m1.IsSparse() || m1.NumValues() == m2.NumValues()
+}
+
+// 注册到rpc服务器的对象，只包含rpc方法
+type workerService struct {
+	worker *Worker
+}
+
+func (s *workerService) Info(args *InfoArgs, reply *ShardInfo) error {
+	options := s.worker.set.GetOptions()
+	reply.NumInstances = s.worker.set.NumInstances()
+	reply.NumLabels = options.NumLabels
+	reply.FeatureDimension = options.FeatureDimension
+	reply.FeatureIsSparse = options.FeatureIsSparse
+	return nil
+}
+
+func (s *workerService) Evaluate(args *EvaluateArgs, reply *EvaluateReply) error {
+	weights, err := decodeMatrix(args.Weights)
+	if err != nil {
+		return err
+	}
+	reply.Loss, reply.Derivative, err = s.worker.evaluate(weights)
+	return err
+}
+
+// 将矩阵串行化为二进制格式
+func encodeMatrix(m *util.Matrix) ([]byte, error) {
+	var buffer bytes.Buffer
+	e := util.NewBinaryEncoder(&buffer)
+	m.EncodeBinary(e)
+	return buffer.Bytes(), e.Err()
+}
+
+func decodeMatrix(b []byte) (*util.Matrix, error) {
+	d := util.NewBinaryDecoder(bytes.NewReader(b))
+	m := new(util.Matrix)
+	m.DecodeBinary(d)
+	return m, d.Err()
+}
@@ -1,33 +1,41 @@
 package eval
 
 import (
+	"context"
 	"github.com/huichen/mlf/data"
 	"github.com/huichen/mlf/supervised"
 )
 
 // 进行N-fold cross-validation，输出评价
-func CrossValidate(trainer supervised.Trainer, set data.Dataset,
+//
+// ctx被取消时不再训练新的fold，输出已完成的fold的平均评价
+func CrossValidate(ctx context.Context, trainer supervised.Trainer, set data.Dataset,
 	evals *Evaluators, folds int) (output Evaluation) {
 	output.Metrics = make(map[string]float64)
+	completedFolds := 0
 	for iFold := 0; iFold < folds; iFold++ {
 		// 裂分训练数据
 		trainBuckets := []data.SkipBucket{
-			{false, iFold},
-			{true, 1},
-			{false, folds - 1 - iFold},
+			{SkipMode: false, NumInstances: iFold},
+			{SkipMode: true, NumInstances: 1},
+			{SkipMode: false, NumInstances: folds - 1 - iFold},
 		}
 		trainSet := data.NewSkipDataset(set, trainBuckets)
 
 		// 裂分评价数据
 		evalBuckets := []data.SkipBucket{
-			{true, iFold},
-			{false, 1},
-			{true, folds - 1 - iFold},
+			{SkipMode: true, NumInstances: iFold},
+			{SkipMode: false, NumInstances: 1},
+			{SkipMode: true, NumInstances: folds - 1 - iFold},
 		}
 		evalSet := data.NewSkipDataset(set, evalBuckets)
 
 		// 在训练数据上训练模型
-		model := trainer.Train(trainSet)
+		model := trainer.Train(ctx, trainSet)
+		if ctx.Err() != nil {
+			// 训练被取消，模型不完整
+			break
+		}
 
 		// 在评价数据上评价
 		metrics := evals.Evaluate(model, evalSet)
@@ -36,11 +44,12 @@ func CrossValidate(trainer supervised.Trainer, set data.Dataset,
 		for m, v := range metrics.Metrics {
 			output.Metrics[m] += v
 		}
+		completedFolds++
 	}
 
 	// 评价结果求平均
 	for m := range output.Metrics {
-		output.Metrics[m] /= float64(folds)
+		output.Metrics[m] /= float64(completedFolds)
 	}
 
 	return
@@ -0,0 +1,53 @@
+package eval
+
+import (
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/supervised"
+	"math"
+)
+
+// 回归问题的evaluator，比较模型预测的Value和样本的Value
+//
+// 输出的metric为
+//   mse：均方误差 sum((y' - y)^2) / N
+//   rmse：均方误差的平方根
+//   mae：平均绝对误差 sum(|y' - y|) / N
+//   r2：决定系数 1 - sum((y' - y)^2) / sum((y - mean(y))^2)，所有y都相同时为0
+type RegressionEvaluator struct {
+}
+
+func (e *RegressionEvaluator) Evaluate(m supervised.Model, set data.Dataset) (result Evaluation) {
+	squaredError := float64(0)
+	absoluteError := float64(0)
+	sum := float64(0)
+	squaredSum := float64(0)
+	n := 0
+
+	iter := set.CreateIterator()
+	iter.Start()
+	for !iter.End() {
+		instance := iter.GetInstance()
+		out := m.Predict(instance)
+		y := instance.Output.Value
+		residual := out.Value - y
+		squaredError += residual * residual
+		absoluteError += math.Abs(residual)
+		sum += y
+		squaredSum += y * y
+		n++
+		iter.Next()
+	}
+
+	result.Metrics = make(map[string]float64)
+	result.Metrics["mse"] = squaredError / float64(n)
+	result.Metrics["rmse"] = math.Sqrt(result.Metrics["mse"])
+	result.Metrics["mae"] = absoluteError / float64(n)
+	variance := squaredSum - sum*sum/float64(n)
+	if variance > 0 {
+		result.Metrics["r2"] = 1 - squaredError/variance
+	} else {
+		result.Metrics["r2"] = 0
+	}
+
+	return
+}
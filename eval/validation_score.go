@@ -0,0 +1,39 @@
+package eval
+
+import (
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/optimizer"
+	"github.com/huichen/mlf/supervised"
+	"github.com/huichen/mlf/util"
+	"log"
+)
+
+// 用评价器构造提前终止所需的验证集评价函数（见optimizer.OptimizerOptions.ValidationSet）
+//
+// newModel将优化中的权重包装成模型，evaluator在验证集上评价该模型，返回名为metric的指标。
+// 指标必须是越大越好的，比如"accuracy"或者"fscore"。
+func NewValidationScore(evaluator Evaluator, metric string,
+	newModel func(weights *util.Matrix) supervised.Model) optimizer.ValidationScoreFunc {
+	return func(weights *util.Matrix, set data.Dataset) float64 {
+		evaluation := evaluator.Evaluate(newModel(weights), set)
+		score, ok := evaluation.Metrics[metric]
+		if !ok {
+			log.Fatal("评价器没有输出指标", metric)
+		}
+		return score
+	}
+}
+
+// 最大熵分类器的验证集评价函数，trainSet为训练集，用于得到模型的维度和词典
+func NewMaxEntValidationScore(evaluator Evaluator, metric string,
+	trainSet data.Dataset) optimizer.ValidationScoreFunc {
+	return NewValidationScore(evaluator, metric, func(weights *util.Matrix) supervised.Model {
+		classifier := new(supervised.MaxEntClassifier)
+		classifier.Weights = weights
+		classifier.NumLabels = trainSet.GetOptions().NumLabels
+		classifier.FeatureDimension = trainSet.GetOptions().FeatureDimension
+		classifier.FeatureDictionary = trainSet.GetFeatureDictionary()
+		classifier.LabelDictionary = trainSet.GetLabelDictionary()
+		return classifier
+	})
+}
@@ -0,0 +1,112 @@
+package online
+
+import (
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/dictionary"
+	"github.com/huichen/mlf/eval"
+	"github.com/huichen/mlf/optimizer"
+	"github.com/huichen/mlf/supervised"
+	"github.com/huichen/mlf/util"
+	"log"
+)
+
+// 在线分类训练器接口，OnlineSGDClassifier和FTRLClassifier都实现了这个接口
+type OnlineClassifier interface {
+	// 读入一个训练样本，先用当前模型预测并记录评价结果，再更新模型
+	TrainOnOneInstance(instance *data.Instance)
+
+	// 使用当前训练出的模型对一个样本的输出进行预测
+	Predict(instance *data.Instance) data.InstanceOutput
+
+	// 评价最近的样本，得到评价metric
+	Evaluate() eval.Evaluation
+
+	// 将模型写入文件，文件可以用supervised.LoadModel载入
+	Write(path string)
+
+	// 从模型文件中载入初始权重
+	LoadWeightsFromFile(path string)
+}
+
+// 将样本中的命名特征和标注字符串转化为整数ID，样本没有标注时返回false
+func prepareInstance(instance *data.Instance,
+	featureDictionary, labelDictionary *dictionary.Dictionary) bool {
+	if instance.NamedFeatures != nil {
+		// 将样本中的特征转化为稀疏向量并加入词典
+		instance.Features = nil
+		data.ConvertNamedFeatures(instance, featureDictionary)
+	}
+
+	if instance.Output == nil {
+		return false
+	}
+
+	// 将样本中的标注字符串转化为整数ID
+	if instance.Output.LabelString != "" {
+		instance.Output.Label = labelDictionary.GetIdFromName(instance.Output.LabelString)
+	}
+	return true
+}
+
+// 最近样本的平均负对数似然，用作在线学习的目标函数值
+// 每累积numInstances个样本调用一次学习率的ObserveLoss，使plateau调度能够降低学习率
+type lossWindow struct {
+	sum   float64
+	count int
+}
+
+// 记录一个样本的负对数似然（用更新前的权重计算）
+func (w *lossWindow) add(loss float64, numInstances int, learningRate *optimizer.LearningRate) {
+	w.sum += loss
+	w.count++
+	if w.count >= numInstances {
+		learningRate.ObserveLoss(w.sum / float64(w.count))
+		w.sum = 0
+		w.count = 0
+	}
+}
+
+// 用最大熵模型的权重预测样本的标注，第0个标注的权重为零
+func predictWithWeights(weights *util.Matrix, instance *data.Instance) data.InstanceOutput {
+	output := data.InstanceOutput{}
+
+	predictedLabel := 0
+	maxWeight := float64(0)
+	for iLabel := 1; iLabel < weights.NumLabels()+1; iLabel++ {
+		sum := float64(0)
+		for _, k := range instance.Features.Keys() {
+			sum += weights.Get(iLabel-1, k) * instance.Features.Get(k)
+		}
+		if sum > maxWeight {
+			predictedLabel = iLabel
+			maxWeight = sum
+		}
+	}
+	output.Label = predictedLabel
+
+	return output
+}
+
+// 将权重和词典作为最大熵模型写入文件
+func writeMaxEntModel(path string, weights *util.Matrix,
+	featureDictionary, labelDictionary *dictionary.Dictionary) {
+	model := supervised.MaxEntClassifier{}
+	model.Weights = weights
+	model.NumLabels = weights.NumLabels() + 1
+	model.FeatureDictionary = featureDictionary
+	model.LabelDictionary = labelDictionary
+	model.Write(path)
+}
+
+// 载入最大熵模型文件，并检查分类数目是否为numLabels
+func loadMaxEntModel(path string, numLabels int) *supervised.MaxEntClassifier {
+	model, ok := supervised.LoadModel(path).(*supervised.MaxEntClassifier)
+	if !ok {
+		log.Fatal("无法载入权重，", path, "不是最大熵模型文件")
+	}
+
+	if numLabels != model.NumLabels {
+		log.Fatal("无法载入权重，标注数目不匹配")
+	}
+	return model
+}
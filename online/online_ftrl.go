@@ -0,0 +1,156 @@
+package online
+
+import (
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/dictionary"
+	"github.com/huichen/mlf/eval"
+	"github.com/huichen/mlf/supervised"
+	"github.com/huichen/mlf/util"
+	"math"
+)
+
+// FTRL-Proximal在线分类训练器
+//
+// 算法见下面的论文
+//   McMahan, H. B. et al. (2013). "Ad Click Prediction: a View from the Trenches".
+//   Proceedings of the 19th ACM SIGKDD: 1222–1230.
+//
+// 每个特征有自己的学习率，并且同时支持L1和L2正则化。对每个特征i，训练器保存
+//   z_i = sum(g_i - sigma_i * w_i)，其中 sigma_i = (sqrt(n_i + g_i^2) - sqrt(n_i)) / Alpha
+//   n_i = sum(g_i^2)
+// 权重由z_i和n_i闭式求出
+//   当|z_i| <= L1时，w_i = 0
+//   否则 w_i = - (z_i - sign(z_i) * L1) / ((Beta + sqrt(n_i)) / Alpha + L2)
+// 因此L1正则化下大部分特征的权重精确为零，得到的模型是稀疏的。
+//
+// 模型和OnlineSGDClassifier一样为最大熵模型，可以用supervised.LoadModel载入。
+// 请使用NewFTRLClassifier函数创建新的训练器。
+type FTRLClassifier struct {
+	weights            *util.Matrix
+	z                  *util.Matrix
+	n                  *util.Matrix
+	instanceDerivative *util.Matrix
+	options            FTRLClassifierOptions
+	evaluator          OnlineEvaluator
+	featureDictionary  *dictionary.Dictionary
+	labelDictionary    *dictionary.Dictionary
+}
+
+// 从options中创建训练器
+func NewFTRLClassifier(options FTRLClassifierOptions) *FTRLClassifier {
+	classifier := new(FTRLClassifier)
+	classifier.options = options
+	if classifier.options.Alpha == 0 {
+		classifier.options.Alpha = 0.1
+	}
+	if classifier.options.Beta == 0 {
+		classifier.options.Beta = 1
+	}
+	classifier.weights = util.NewSparseMatrix(options.NumLabels - 1)
+	classifier.z = util.NewSparseMatrix(options.NumLabels - 1)
+	classifier.n = util.NewSparseMatrix(options.NumLabels - 1)
+	classifier.instanceDerivative = util.NewSparseMatrix(options.NumLabels - 1)
+	classifier.evaluator = new(FrapEvaluator)
+	classifier.evaluator.Init(options.NumInstancesForEvaluation)
+	classifier.featureDictionary = dictionary.NewDictionary(1)
+	classifier.labelDictionary = dictionary.NewDictionary(0)
+
+	return classifier
+}
+
+// 评价目前为止训练好的模型，得到评价metric
+func (classifier *FTRLClassifier) Evaluate() eval.Evaluation {
+	return classifier.evaluator.Report()
+}
+
+// 读入一个训练样本
+func (classifier *FTRLClassifier) TrainOnOneInstance(instance *data.Instance) {
+	if !prepareInstance(instance, classifier.featureDictionary, classifier.labelDictionary) {
+		return
+	}
+
+	// 预测并记录
+	prediction := classifier.Predict(instance)
+	classifier.evaluator.Evaluate(*instance.Output, prediction)
+
+	classifier.instanceDerivative.Clear()
+	supervised.MaxEntComputeInstanceDerivative(
+		classifier.weights, instance, classifier.instanceDerivative)
+
+	// 逐个更新样本中出现的特征
+	alpha := classifier.options.Alpha
+	for iLabel := 0; iLabel < classifier.weights.NumLabels(); iLabel++ {
+		g := classifier.instanceDerivative.GetValues(iLabel)
+		z := classifier.z.GetValues(iLabel)
+		n := classifier.n.GetValues(iLabel)
+		w := classifier.weights.GetValues(iLabel)
+		for _, k := range instance.Features.Keys() {
+			gk := g.Get(k)
+			nk := n.Get(k)
+			sigma := (math.Sqrt(nk+gk*gk) - math.Sqrt(nk)) / alpha
+			z.Set(k, z.Get(k)+gk-sigma*w.Get(k))
+			n.Set(k, nk+gk*gk)
+			w.Set(k, classifier.computeWeight(z.Get(k), n.Get(k), k))
+		}
+	}
+	classifier.weights.RemoveZeros()
+}
+
+// 从z_i和n_i计算第k个特征的权重w_i
+func (classifier *FTRLClassifier) computeWeight(z, n float64, k int) float64 {
+	l1, l2 := classifier.regularization(k)
+	if math.Abs(z) <= l1 {
+		return 0
+	}
+	sign := float64(1)
+	if z < 0 {
+		sign = -1
+	}
+	return -(z - sign*l1) / ((classifier.options.Beta+math.Sqrt(n))/classifier.options.Alpha + l2)
+}
+
+// 第k个特征的L1和L2正则化系数
+func (classifier *FTRLClassifier) regularization(k int) (l1, l2 float64) {
+	multiplier := float64(1)
+	if k == 0 && classifier.options.ExcludeBiasFromRegularization {
+		multiplier = 0
+	} else if m, ok := classifier.options.RegularizationMultipliers[k]; ok {
+		multiplier = m
+	}
+	return classifier.options.L1 * multiplier, classifier.options.L2 * multiplier
+}
+
+// 使用当前训练出的模型对一个样本的输出进行预测
+func (classifier *FTRLClassifier) Predict(instance *data.Instance) data.InstanceOutput {
+	return predictWithWeights(classifier.weights, instance)
+}
+
+func (classifier *FTRLClassifier) Write(path string) {
+	writeMaxEntModel(path, classifier.weights,
+		classifier.featureDictionary, classifier.labelDictionary)
+}
+
+// 从最大熵模型文件中载入初始权重
+//
+// 文件中没有保存z和n，载入后n_i设为0，z_i设为使computeWeight恰好给出w_i的值。
+func (classifier *FTRLClassifier) LoadWeightsFromFile(path string) {
+	model := loadMaxEntModel(path, classifier.options.NumLabels)
+	classifier.weights = model.Weights
+	classifier.featureDictionary = model.FeatureDictionary
+	classifier.labelDictionary = model.LabelDictionary
+
+	classifier.z = classifier.weights.Populate()
+	classifier.n = classifier.weights.Populate()
+	for iLabel := 0; iLabel < classifier.weights.NumLabels(); iLabel++ {
+		for _, k := range classifier.weights.GetValues(iLabel).Keys() {
+			l1, l2 := classifier.regularization(k)
+			scale := classifier.options.Beta/classifier.options.Alpha + l2
+			w := classifier.weights.Get(iLabel, k)
+			if w > 0 {
+				classifier.z.Set(iLabel, k, -w*scale-l1)
+			} else if w < 0 {
+				classifier.z.Set(iLabel, k, -w*scale+l1)
+			}
+		}
+	}
+}
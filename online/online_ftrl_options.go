@@ -0,0 +1,24 @@
+package online
+
+type FTRLClassifierOptions struct {
+	// 分类数目
+	NumLabels int
+
+	// 每个特征的学习率为 Alpha / (Beta + sqrt(sum(g^2)))，其中g为该特征的历史偏导数
+	// Alpha为0时使用0.1，Beta为0时使用1
+	Alpha float64
+	Beta  float64
+
+	// L1和L2正则化系数，L1越大得到的权重越稀疏
+	L1 float64
+	L2 float64
+
+	// 各特征的正则化系数，特征k的L1和L2系数都乘以RegularizationMultipliers[k]，
+	// 没有指定的特征为1。ExcludeBiasFromRegularization为true时不对偏置特征（第0个特征）
+	// 做正则化。和optimizer.OptimizerOptions中的同名选项含义相同。
+	RegularizationMultipliers     map[int]float64
+	ExcludeBiasFromRegularization bool
+
+	// 对最近的多少个样本进行模型评估
+	NumInstancesForEvaluation int
+}
@@ -0,0 +1,61 @@
+package online
+
+import (
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/supervised"
+	"github.com/huichen/mlf/util"
+	"os"
+	"testing"
+)
+
+func TestFTRLClassifier(t *testing.T) {
+	classifier := NewFTRLClassifier(FTRLClassifierOptions{
+		NumLabels:                 2,
+		Alpha:                     0.5,
+		L1:                        2,
+		L2:                        0.1,
+		NumInstancesForEvaluation: 100,
+	})
+
+	// "click"决定标注，"noise"是很少出现的特征
+	for i := 0; i < 1000; i++ {
+		features := map[string]float64{"bias": 1}
+		label := 0
+		if i%2 == 0 {
+			features["click"] = 1
+			label = 1
+		}
+		if i%100 == 1 {
+			features["noise"] = 1
+		}
+		classifier.TrainOnOneInstance(&data.Instance{
+			NamedFeatures: features,
+			Output:        &data.InstanceOutput{Label: label},
+		})
+	}
+	util.ExpectNear(t, 1, classifier.Evaluate().Metrics["accuracy"], 1e-6)
+
+	// L1正则化使很少出现的特征的权重精确为零且不被保存
+	clickId := classifier.featureDictionary.GetIdFromName("click")
+	noiseId := classifier.featureDictionary.GetIdFromName("noise")
+	util.Expect(t, "true", classifier.weights.Get(0, clickId) > 0)
+	for _, k := range classifier.weights.GetValues(0).Keys() {
+		util.Expect(t, "true", k != noiseId)
+	}
+
+	// 模型可以作为最大熵模型载入
+	classifier.Write("test_ftrl.mlf")
+	defer os.Remove("test_ftrl.mlf")
+	model := supervised.LoadModel("test_ftrl.mlf")
+	util.Expect(t, "maxent_classifier", model.GetModelType())
+	output := model.Predict(&data.Instance{NamedFeatures: map[string]float64{"bias": 1, "click": 1}})
+	util.Expect(t, "1", output.Label)
+
+	// 载入权重后z和n重建出相同的权重
+	loaded := NewFTRLClassifier(classifier.options)
+	loaded.LoadWeightsFromFile("test_ftrl.mlf")
+	for _, k := range loaded.weights.GetValues(0).Keys() {
+		util.ExpectNear(t, loaded.weights.Get(0, k),
+			loaded.computeWeight(loaded.z.Get(0, k), loaded.n.Get(0, k), k), 1e-9)
+	}
+}
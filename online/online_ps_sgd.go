@@ -0,0 +1,149 @@
+package online
+
+import (
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/distributed"
+	"github.com/huichen/mlf/eval"
+	"github.com/huichen/mlf/optimizer"
+	"github.com/huichen/mlf/supervised"
+	"github.com/huichen/mlf/util"
+	"log"
+)
+
+// 使用参数服务器的在线梯度递降分类训练器
+//
+// 多个训练进程共享保存在参数服务器（见distributed.ParameterServer）上的权重：每收到
+// BatchSize个样本，训练器从参数服务器读取这批样本中出现的特征的权重，计算偏导数和
+// 正则化项，然后把权重的增量提交给参数服务器。更新公式和OnlineSGDClassifier相同，
+// 只是正则化项只作用于这批样本中出现的特征。其它训练进程的更新最多延迟Staleness个
+// 时钟，因此结果和单个训练进程不完全相同。
+//
+// 预测使用最近一次从参数服务器读取的权重。各进程的词典无法保持一致，样本必须使用
+// 整数特征（Features），使用NamedFeatures的样本被忽略；标注使用Output.Label，
+// LabelString不起作用。
+// 不支持ProximalRegularization。请使用NewParameterServerSGDClassifier函数创建。
+type ParameterServerSGDClassifier struct {
+	client             *distributed.ParameterClient
+	weights            *util.Matrix
+	derivative         *util.Matrix
+	instanceDerivative *util.Matrix
+	options            OnlineSGDClassifierOptions
+	learningRate       *optimizer.LearningRate
+	losses             lossWindow
+	batch              []*data.Instance
+	evaluator          OnlineEvaluator
+}
+
+// 从options中创建训练器，client为连接到参数服务器的客户端
+func NewParameterServerSGDClassifier(options OnlineSGDClassifierOptions,
+	client *distributed.ParameterClient) *ParameterServerSGDClassifier {
+	if options.Optimizer.ProximalRegularization {
+		log.Fatal("参数服务器训练器不支持ProximalRegularization")
+	}
+	if options.NumLabels != client.NumLabels() {
+		log.Fatal("训练器和参数服务器的分类数目不一致")
+	}
+	classifier := new(ParameterServerSGDClassifier)
+	classifier.client = client
+	classifier.options = options
+	if classifier.options.BatchSize <= 1 {
+		classifier.options.BatchSize = 1
+	}
+	classifier.weights = util.NewSparseMatrix(options.NumLabels - 1)
+	classifier.derivative = util.NewSparseMatrix(options.NumLabels - 1)
+	classifier.instanceDerivative = util.NewSparseMatrix(options.NumLabels - 1)
+	classifier.learningRate = optimizer.NewLearningRate(options.Optimizer)
+	classifier.evaluator = new(FrapEvaluator)
+	classifier.evaluator.Init(options.NumInstancesForEvaluation)
+
+	return classifier
+}
+
+// 评价目前为止训练好的模型，得到评价metric
+func (classifier *ParameterServerSGDClassifier) Evaluate() eval.Evaluation {
+	return classifier.evaluator.Report()
+}
+
+// 读入一个训练样本
+func (classifier *ParameterServerSGDClassifier) TrainOnOneInstance(instance *data.Instance) {
+	if instance.NamedFeatures != nil || instance.Features == nil {
+		log.Print("参数服务器训练器只接受整数特征的样本")
+		return
+	}
+	if instance.Output == nil {
+		return
+	}
+
+	// 预测并记录
+	prediction := classifier.Predict(instance)
+	classifier.evaluator.Evaluate(*instance.Output, prediction)
+
+	classifier.batch = append(classifier.batch, instance)
+	if len(classifier.batch) >= classifier.options.BatchSize {
+		classifier.Flush()
+	}
+}
+
+// 用尚未更新的样本（不足BatchSize个）更新权重
+func (classifier *ParameterServerSGDClassifier) Flush() {
+	if len(classifier.batch) == 0 {
+		return
+	}
+
+	// 读取这批样本中出现的特征的权重
+	seen := make(map[int]bool)
+	keys := []int{}
+	for _, instance := range classifier.batch {
+		for _, k := range instance.Features.Keys() {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	classifier.client.Pull(keys, classifier.weights)
+
+	classifier.derivative.Clear()
+	for _, instance := range classifier.batch {
+		classifier.instanceDerivative.Clear()
+		loss := supervised.MaxEntComputeInstanceDerivative(
+			classifier.weights, instance, classifier.instanceDerivative)
+		classifier.losses.add(loss, classifier.options.NumInstancesForEvaluation, classifier.learningRate)
+		classifier.derivative.Increment(classifier.instanceDerivative, 1.0)
+	}
+
+	numInstances := float64(classifier.options.NumInstancesForEvaluation)
+	learningRate := classifier.learningRate.Next() / numInstances
+
+	// 添加正则化项
+	classifier.derivative.Increment(optimizer.ComputeRegularizationOnKeys(
+		classifier.weights, classifier.derivative, classifier.options.Optimizer), 1.0/numInstances)
+
+	// 提交权重的增量，本地副本同时更新
+	classifier.derivative.Scale(-1 * learningRate)
+	classifier.client.Push(classifier.derivative)
+	classifier.weights.Increment(classifier.derivative, 1.0)
+
+	classifier.batch = classifier.batch[:0]
+}
+
+// 更新剩余的样本，并通知参数服务器本训练进程结束，之后不能再训练
+func (classifier *ParameterServerSGDClassifier) Finish() {
+	classifier.Flush()
+	classifier.client.Finish()
+}
+
+// 使用最近读取的权重对一个样本的输出进行预测
+func (classifier *ParameterServerSGDClassifier) Predict(instance *data.Instance) data.InstanceOutput {
+	return predictWithWeights(classifier.weights, instance)
+}
+
+// 将参数服务器上的全部权重写入文件，模型不包含词典
+func (classifier *ParameterServerSGDClassifier) Write(path string) {
+	writeMaxEntModel(path, classifier.client.Snapshot(), nil, nil)
+}
+
+// 权重保存在参数服务器上，多个训练进程无法各自载入初始权重
+func (classifier *ParameterServerSGDClassifier) LoadWeightsFromFile(path string) {
+	log.Fatal("参数服务器训练器不支持载入初始权重")
+}
@@ -0,0 +1,96 @@
+package online
+
+import (
+	"github.com/huichen/mlf/contrib"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/distributed"
+	"github.com/huichen/mlf/optimizer"
+	"net"
+	"testing"
+)
+
+func TestParameterServerSGD(t *testing.T) {
+	// 两个参数服务器，两个训练进程，时钟差不超过2
+	addresses := []string{}
+	for i := 0; i < 2; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		server := distributed.NewParameterServer(distributed.ParameterServerOptions{
+			NumLabels:   2,
+			NumTrainers: 2,
+			Staleness:   2,
+		})
+		go server.Serve(listener)
+		addresses = append(addresses, listener.Addr().String())
+	}
+
+	options := OnlineSGDClassifierOptions{
+		BatchSize:                 10,
+		NumLabels:                 2,
+		NumInstancesForEvaluation: 100,
+		Optimizer: optimizer.OptimizerOptions{
+			LearningRate:         20,
+			RegularizationFactor: 0.001,
+			RegularizationScheme: 2,
+		},
+	}
+
+	set := contrib.LoadLibSVMDataset("../testdata/a1a", true)
+	done := make(chan *ParameterServerSGDClassifier)
+	for iTrainer := 0; iTrainer < 2; iTrainer++ {
+		go func(trainer int) {
+			client, err := distributed.NewParameterClient(addresses, trainer)
+			if err != nil {
+				t.Error(err)
+				done <- nil
+				return
+			}
+			classifier := NewParameterServerSGDClassifier(options, client)
+
+			// 第i个样本由第i % 2个训练进程处理，遍历三次
+			for epoch := 0; epoch < 3; epoch++ {
+				iterator := set.CreateIterator()
+				iterator.Start()
+				for i := 0; !iterator.End(); i++ {
+					if i%2 == trainer {
+						// 只使用数据集转化好的整数特征
+						instance := iterator.GetInstance()
+						classifier.TrainOnOneInstance(&data.Instance{
+							Features: instance.Features,
+							Output:   instance.Output,
+						})
+					}
+					iterator.Next()
+				}
+			}
+			classifier.Finish()
+			done <- classifier
+		}(iTrainer)
+	}
+	classifier := <-done
+	<-done
+	if classifier == nil {
+		return
+	}
+
+	// 用参数服务器上的权重在训练集上评价
+	weights := classifier.client.Snapshot()
+	correct := 0
+	iterator := set.CreateIterator()
+	iterator.Start()
+	for !iterator.End() {
+		instance := iterator.GetInstance()
+		if predictWithWeights(weights, instance).Label == instance.Output.Label {
+			correct++
+		}
+		iterator.Next()
+	}
+	accuracy := float64(correct) / float64(set.NumInstances())
+	t.Logf("准确度 = %.2f %%", accuracy*100)
+	if accuracy < 0.8 {
+		t.Errorf("准确度过低：%f", accuracy)
+	}
+}
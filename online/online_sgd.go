@@ -16,6 +16,8 @@ type OnlineSGDClassifier struct {
 	derivative         *util.Matrix
 	instanceDerivative *util.Matrix
 	options            OnlineSGDClassifierOptions
+	learningRate       *optimizer.LearningRate
+	losses             lossWindow
 	instancesProcessed int
 	evaluator          OnlineEvaluator
 	featureDictionary  *dictionary.Dictionary
@@ -32,6 +34,7 @@ func NewOnlineSGDClassifier(options OnlineSGDClassifierOptions) *OnlineSGDClassi
 	classifier.weights = util.NewSparseMatrix(options.NumLabels - 1)
 	classifier.derivative = util.NewSparseMatrix(options.NumLabels - 1)
 	classifier.instanceDerivative = util.NewSparseMatrix(options.NumLabels - 1)
+	classifier.learningRate = optimizer.NewLearningRate(options.Optimizer)
 	classifier.evaluator = new(FrapEvaluator)
 	classifier.evaluator.Init(options.NumInstancesForEvaluation)
 	classifier.featureDictionary = dictionary.NewDictionary(1)
@@ -47,21 +50,8 @@ func (classifier *OnlineSGDClassifier) Evaluate() eval.Evaluation {
 
 // 读入一个训练样本
 func (classifier *OnlineSGDClassifier) TrainOnOneInstance(instance *data.Instance) {
-	if instance.NamedFeatures != nil {
-		// 将样本中的特征转化为稀疏向量并加入词典
-		instance.Features = nil
-		data.ConvertNamedFeatures(instance, classifier.featureDictionary)
-	}
-
-	if instance.Output == nil {
+	if !prepareInstance(instance, classifier.featureDictionary, classifier.labelDictionary) {
 		return
-	} else {
-		// 将样本中的标注字符串转化为整数ID
-		if instance.Output.LabelString != "" {
-			instance.Output.Label =
-				classifier.labelDictionary.GetIdFromName(
-					instance.Output.LabelString)
-		}
 	}
 
 	// 预测并记录
@@ -69,20 +59,30 @@ func (classifier *OnlineSGDClassifier) TrainOnOneInstance(instance *data.Instanc
 	classifier.evaluator.Evaluate(*instance.Output, prediction)
 
 	classifier.instanceDerivative.Clear()
-	supervised.MaxEntComputeInstanceDerivative(
+	loss := supervised.MaxEntComputeInstanceDerivative(
 		classifier.weights, instance, classifier.instanceDerivative)
+	classifier.losses.add(loss, classifier.options.NumInstancesForEvaluation, classifier.learningRate)
 	classifier.derivative.Increment(classifier.instanceDerivative, 1.0)
 	classifier.instancesProcessed++
 
 	if classifier.instancesProcessed >= classifier.options.BatchSize {
+		numInstances := float64(classifier.options.NumInstancesForEvaluation)
+		learningRate := classifier.learningRate.Next() / numInstances
+
 		// 添加正则化项
-		classifier.derivative.Increment(optimizer.ComputeRegularization(
-			classifier.weights, classifier.options.Optimizer), 1.0/float64(classifier.options.NumInstancesForEvaluation))
+		if !classifier.options.Optimizer.ProximalRegularization {
+			classifier.derivative.Increment(optimizer.ComputeRegularization(
+				classifier.weights, classifier.options.Optimizer), 1.0/numInstances)
+		}
 
 		// 根据学习率更新权重
-		classifier.weights.Increment(
-			classifier.derivative,
-			-1*classifier.options.Optimizer.LearningRate/float64(classifier.options.NumInstancesForEvaluation))
+		classifier.weights.Increment(classifier.derivative, -1*learningRate)
+
+		// 近端更新只作用于这一批样本中出现的特征
+		if classifier.options.Optimizer.ProximalRegularization {
+			optimizer.ApplyProximalRegularization(classifier.weights, classifier.derivative,
+				classifier.options.Optimizer, learningRate/numInstances)
+		}
 
 		// 重置
 		classifier.derivative.Clear()
@@ -92,21 +92,5 @@ func (classifier *OnlineSGDClassifier) TrainOnOneInstance(instance *data.Instanc
 
 // 使用当前训练出的模型对一个样本的输出进行预测
 func (classifier *OnlineSGDClassifier) Predict(instance *data.Instance) data.InstanceOutput {
-	output := data.InstanceOutput{}
-
-	predictedLabel := 0
-	maxWeight := float64(0)
-	for iLabel := 1; iLabel < classifier.weights.NumLabels()+1; iLabel++ {
-		sum := float64(0)
-		for _, k := range instance.Features.Keys() {
-			sum += classifier.weights.Get(iLabel-1, k) * instance.Features.Get(k)
-		}
-		if sum > maxWeight {
-			predictedLabel = iLabel
-			maxWeight = sum
-		}
-	}
-	output.Label = predictedLabel
-
-	return output
+	return predictWithWeights(classifier.weights, instance)
 }
@@ -3,6 +3,7 @@ package online
 import (
 	"github.com/huichen/mlf/data"
 	"github.com/huichen/mlf/optimizer"
+	"github.com/huichen/mlf/util"
 	"testing"
 )
 
@@ -53,3 +54,26 @@ func TestOnlineSGD(t *testing.T) {
 
 	classifier.Write("test.mlf")
 }
+
+func TestOnlineSGDPlateau(t *testing.T) {
+	// 两个特征相同、标注相反的样本交替出现，最近样本的平均负对数似然不再下降，
+	// plateau调度应该降低学习率
+	options := OnlineSGDClassifierOptions{
+		BatchSize:                 1,
+		NumLabels:                 2,
+		NumInstancesForEvaluation: 10,
+		Optimizer: optimizer.OptimizerOptions{
+			LearningRate:         1,
+			LearningRateSchedule: optimizer.LearningRateSchedule{Name: "plateau", Patience: 2, Decay: 0.5},
+		},
+	}
+	classifier := NewOnlineSGDClassifier(options)
+
+	for i := 0; i < 200; i++ {
+		classifier.TrainOnOneInstance(&data.Instance{
+			NamedFeatures: map[string]float64{"f1": 1},
+			Output:        &data.InstanceOutput{Label: i % 2},
+		})
+	}
+	util.Expect(t, "true", classifier.learningRate.Current() < 1)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"github.com/huichen/mlf/distributed"
+	"log"
+	"runtime"
+)
+
+var (
+	address      = flag.String("address", ":8100", "参数服务器监听的地址")
+	num_labels   = flag.Int("num_labels", 2, "分类数目")
+	num_trainers = flag.Int("num_trainers", 1, "训练服务器的数目")
+	staleness    = flag.Int("staleness", 0, "训练服务器之间最大的时钟差")
+)
+
+func main() {
+	flag.Parse()
+	runtime.GOMAXPROCS(runtime.NumCPU())
+
+	server := distributed.NewParameterServer(distributed.ParameterServerOptions{
+		NumLabels:   *num_labels,
+		NumTrainers: *num_trainers,
+		Staleness:   *staleness,
+	})
+	log.Print("参数服务器启动 ", *address)
+	if err := server.ListenAndServe(*address); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/huichen/mlf/data"
+	_ "github.com/huichen/mlf/rbm"
 	"github.com/huichen/mlf/supervised"
 	"io"
 	"log"
@@ -31,6 +32,7 @@ func main() {
 	runtime.GOMAXPROCS(1)
 
 	classifier = supervised.LoadModel(*model)
+	log.Print("载入模型 ", *model, "，类型 ", classifier.GetModelType())
 
 	http.HandleFunc("/predict", PredictRpc)
 	log.Print("服务器启动 ", *host, ":", *port)
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/distributed"
 	"github.com/huichen/mlf/online"
 	"io"
 	"log"
@@ -14,12 +15,13 @@ import (
 )
 
 var (
-	config_file = flag.String("config", "", "SGD分类服务器配置文件")
+	config_file = flag.String("config", "", "在线分类服务器配置文件")
 
-	classifier               *online.OnlineSGDClassifier
-	instanceCount            int
-	modelSavingInstanceCount int
-	config                   TrainerServerConfig
+	classifier                online.OnlineClassifier
+	instanceCount             int
+	modelSavingInstanceCount  int
+	numInstancesForEvaluation int
+	config                    TrainerServerConfig
 )
 
 type TrainerServerConfig struct {
@@ -29,7 +31,16 @@ type TrainerServerConfig struct {
 	SaveModelPath              string
 	ModelSavingEveryNInstances int
 
-	Options online.OnlineSGDClassifierOptions
+	// 训练器："sgd"（默认）使用Options，"ftrl"使用FTRLOptions，
+	// "ps"使用Options并通过参数服务器和其它训练服务器共享权重
+	Trainer string
+
+	Options     online.OnlineSGDClassifierOptions
+	FTRLOptions online.FTRLClassifierOptions
+
+	// "ps"训练器的参数服务器地址，以及本训练服务器的编号
+	ParameterServers []string
+	TrainerIndex     int
 }
 
 type TrainResponse struct {
@@ -46,7 +57,23 @@ func main() {
 	}
 	config = LoadServerConfig(*config_file)
 
-	classifier = online.NewOnlineSGDClassifier(config.Options)
+	switch config.Trainer {
+	case "", "sgd":
+		classifier = online.NewOnlineSGDClassifier(config.Options)
+		numInstancesForEvaluation = config.Options.NumInstancesForEvaluation
+	case "ftrl":
+		classifier = online.NewFTRLClassifier(config.FTRLOptions)
+		numInstancesForEvaluation = config.FTRLOptions.NumInstancesForEvaluation
+	case "ps":
+		client, err := distributed.NewParameterClient(config.ParameterServers, config.TrainerIndex)
+		if err != nil {
+			log.Fatal("无法连接参数服务器，错误", err)
+		}
+		classifier = online.NewParameterServerSGDClassifier(config.Options, client)
+		numInstancesForEvaluation = config.Options.NumInstancesForEvaluation
+	default:
+		log.Fatal("不支持的训练器", config.Trainer)
+	}
 	if config.LoadModelPath != "" {
 		classifier.LoadWeightsFromFile(config.LoadModelPath)
 	}
@@ -57,23 +84,15 @@ func main() {
 }
 
 func LoadServerConfig(path string) (config TrainerServerConfig) {
-	// 最大文件长度10M
-	data := make([]byte, 1024*1024*10)
-
 	f, err := os.Open(path)
-	defer f.Close()
 	if err != nil {
 		log.Fatal("无法打开", path, "文件")
 	}
+	defer f.Close()
 
-	count, errRead := f.Read(data)
-	if errRead != nil {
-		log.Fatal("无法读入", path, "文件")
-	}
-
-	errUnmarshal := json.Unmarshal(data[:count], &config)
-	if errUnmarshal != nil {
-		log.Fatal("无法解析", path, "文件，错误", errUnmarshal)
+	errDecode := json.NewDecoder(f).Decode(&config)
+	if errDecode != nil {
+		log.Fatal("无法解析", path, "文件，错误", errDecode)
 	}
 
 	return
@@ -92,7 +111,7 @@ func TrainRpc(w http.ResponseWriter, req *http.Request) {
 		classifier.TrainOnOneInstance(&instance)
 		instanceCount++
 		modelSavingInstanceCount++
-		if instanceCount == config.Options.NumInstancesForEvaluation {
+		if instanceCount == numInstancesForEvaluation {
 			output := classifier.Evaluate()
 			log.Printf("+/p/r/f1/a %% = %.2f %.2f %.2f %.2f %.2f",
 				100*output.Metrics["positive"],
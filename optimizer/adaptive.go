@@ -0,0 +1,132 @@
+package optimizer
+
+import (
+	"context"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/util"
+	"log"
+	"math"
+)
+
+// 自适应一阶优化器，包括动量（momentum）、Nesterov动量、AdaGrad、RMSProp和Adam
+//
+// 各方法的增量计算公式如下（g为偏导数，所有运算都是逐元素的）：
+//   momentum:  v = mu * v - g                        d = v
+//   nesterov:  v = mu * v - g                        d = mu * v - g
+//   adagrad:   G = G + g^2                           d = - g / (sqrt(G) + eps)
+//   rmsprop:   E = rho * E + (1 - rho) * g^2         d = - g / (sqrt(E) + eps)
+//   adam:      m = b1 * m + (1 - b1) * g
+//              v = b2 * v + (1 - b2) * g^2           d = - m' / (sqrt(v') + eps)
+//              其中m'和v'为经过偏差修正的m和v
+// 权重更新为 w = w + lr * d，lr由LearningRate计算。
+//
+// 各元素的状态是惰性的：只有在偏导数中出现的元素才会被更新，因此可以用于稀疏权重。
+// 对于稀疏权重，没有出现的元素的动量不会衰减，这是对原算法的近似。
+//
+// 优化器保存了各元素的状态，因此是协程不安全和迭代不安全的。
+type adaptiveOptimizer struct {
+	options OptimizerOptions
+
+	// 优化方法，和OptimizerName相同
+	method string
+
+	// 各元素的一阶矩（动量）和二阶矩状态，第一次调用GetDeltaX时开辟
+	firstMoment  *util.Matrix
+	secondMoment *util.Matrix
+
+	// Adam偏差修正用的步数
+	t int
+}
+
+// 开辟新的adaptiveOptimizer指针，OptimizerName必须为
+// "momentum"、"nesterov"、"adagrad"、"rmsprop"或"adam"之一
+func NewAdaptiveOptimizer(options OptimizerOptions) Optimizer {
+	opt := new(adaptiveOptimizer)
+	opt.options = options
+	opt.method = options.OptimizerName
+	switch opt.method {
+	case "momentum", "nesterov", "adagrad", "rmsprop", "adam":
+	default:
+		log.Fatal("不支持的自适应优化方法", opt.method)
+	}
+	if options.ProximalRegularization {
+		log.Fatal("自适应优化器各元素的步长不同，不支持ProximalRegularization")
+	}
+
+	if opt.options.Momentum == 0 {
+		opt.options.Momentum = 0.9
+	}
+	if opt.options.RMSPropDecay == 0 {
+		opt.options.RMSPropDecay = 0.9
+	}
+	if opt.options.AdamBeta1 == 0 {
+		opt.options.AdamBeta1 = 0.9
+	}
+	if opt.options.AdamBeta2 == 0 {
+		opt.options.AdamBeta2 = 0.999
+	}
+	if opt.options.AdaptiveEpsilon == 0 {
+		opt.options.AdaptiveEpsilon = 1e-8
+	}
+	return opt
+}
+
+// 清除结构体中保存的数据，以便重复使用结构体
+func (opt *adaptiveOptimizer) Clear() {
+	opt.firstMoment = nil
+	opt.secondMoment = nil
+	opt.t = 0
+}
+
+// 输入x_k和g_k，返回x需要更新的增量，只计算g_k中出现的元素
+func (opt *adaptiveOptimizer) GetDeltaX(x, g *util.Matrix) *util.Matrix {
+	if opt.firstMoment == nil {
+		opt.firstMoment = x.Populate()
+		opt.secondMoment = x.Populate()
+	}
+	opt.t++
+
+	mu := opt.options.Momentum
+	rho := opt.options.RMSPropDecay
+	b1 := opt.options.AdamBeta1
+	b2 := opt.options.AdamBeta2
+	eps := opt.options.AdaptiveEpsilon
+	b1Correction := 1 - math.Pow(b1, float64(opt.t))
+	b2Correction := 1 - math.Pow(b2, float64(opt.t))
+
+	delta := g.Populate()
+	for iLabel := 0; iLabel < g.NumLabels(); iLabel++ {
+		m := opt.firstMoment.GetValues(iLabel)
+		v := opt.secondMoment.GetValues(iLabel)
+		gv := g.GetValues(iLabel)
+		dv := delta.GetValues(iLabel)
+		for _, k := range gv.Keys() {
+			gk := gv.Get(k)
+			switch opt.method {
+			case "momentum":
+				m.Set(k, mu*m.Get(k)-gk)
+				dv.Set(k, m.Get(k))
+			case "nesterov":
+				m.Set(k, mu*m.Get(k)-gk)
+				dv.Set(k, mu*m.Get(k)-gk)
+			case "adagrad":
+				v.Set(k, v.Get(k)+gk*gk)
+				dv.Set(k, -gk/(math.Sqrt(v.Get(k))+eps))
+			case "rmsprop":
+				v.Set(k, rho*v.Get(k)+(1-rho)*gk*gk)
+				dv.Set(k, -gk/(math.Sqrt(v.Get(k))+eps))
+			case "adam":
+				m.Set(k, b1*m.Get(k)+(1-b1)*gk)
+				v.Set(k, b2*v.Get(k)+(1-b2)*gk*gk)
+				dv.Set(k, -(m.Get(k)/b1Correction)/(math.Sqrt(v.Get(k)/b2Correction)+eps))
+			}
+		}
+	}
+	return delta
+}
+
+func (opt *adaptiveOptimizer) OptimizeWeights(ctx context.Context,
+	weights *util.Matrix, derivative_func ComputeInstanceDerivativeFunc, set data.Dataset) OptimizationResult {
+	log.Printf("开始%s优化", opt.method)
+	return optimizeWithFirstOrderSteps(ctx, opt, opt.options, true, weights, derivative_func, set)
+}
@@ -0,0 +1,55 @@
+package optimizer
+
+import (
+	"context"
+	"github.com/huichen/mlf/util"
+	"testing"
+)
+
+func TestAdaptiveOptimizer(t *testing.T) {
+	for _, name := range []string{"momentum", "nesterov", "adagrad", "rmsprop", "adam"} {
+		for _, sparse := range []bool{false, true} {
+			set := newLogisticTestDataset(sparse)
+			var weights *util.Matrix
+			if sparse {
+				weights = util.NewSparseMatrix(1)
+			} else {
+				weights = util.NewMatrix(1, 3)
+			}
+
+			opt := NewOptimizer(OptimizerOptions{
+				OptimizerName: name,
+				LearningRate:  0.1,
+				GDBatchSize:   2,
+				MaxIterations: 200,
+			})
+			opt.OptimizeWeights(context.Background(), weights, logisticDerivative, set)
+
+			// 第1个特征决定标注
+			util.Expect(t, "true", weights.Get(0, 1) > 0.5)
+		}
+	}
+}
+
+func TestAdaptiveOptimizerLazyState(t *testing.T) {
+	opt := NewOptimizer(OptimizerOptions{OptimizerName: "adagrad"})
+
+	x := util.NewSparseMatrix(1)
+	g := util.NewSparseMatrix(1)
+	g.Set(0, 3, 2)
+	delta := opt.GetDeltaX(x, g)
+	util.ExpectNear(t, -1, delta.Get(0, 3), 1e-6)
+
+	// 没有出现在偏导数中的元素不产生增量，也没有状态
+	g.Clear()
+	g.Set(0, 5, 1)
+	delta = opt.GetDeltaX(x, g)
+	util.Expect(t, "1", len(delta.GetValues(0).Keys()))
+	util.ExpectNear(t, -1, delta.Get(0, 5), 1e-6)
+
+	// 第3个元素的累计平方和为 4 + 16
+	g.Clear()
+	g.Set(0, 3, 4)
+	delta = opt.GetDeltaX(x, g)
+	util.ExpectNear(t, -4/4.47213595, delta.Get(0, 3), 1e-6)
+}
@@ -0,0 +1,263 @@
+package optimizer
+
+import (
+	"fmt"
+	"github.com/huichen/mlf/util"
+	"log"
+	"os"
+)
+
+// 检查点文件的类型，见util.ModelFileMagic
+const checkpointFileType = "optimizer_checkpoint"
+
+// 优化器内部状态的串行化，所有优化器都实现了这个接口
+type checkpointState interface {
+	encodeState(e *util.BinaryEncoder)
+	decodeState(d *util.BinaryDecoder)
+}
+
+// 优化循环中需要保存到检查点的全部状态
+//
+// 从检查点恢复后继续优化得到的结果和不中断时完全相同（浮点运算的顺序也相同），
+// 前提是使用相同的数据和选项（包括NumThreads）。
+type optimizationState struct {
+	// 已经完成的迭代数
+	step int
+
+	weights       *util.Matrix
+	optimizer     checkpointState
+	learningRate  *LearningRate
+	checker       *convergenceChecker
+	earlyStopping *earlyStopping
+}
+
+// 如果设置了CheckpointPath，每CheckpointInterval次迭代将状态写入检查点文件
+//
+// 文件先写入临时文件再改名，因此进程在写入时退出不会破坏已有的检查点。
+func saveCheckpoint(options OptimizerOptions, state *optimizationState) {
+	if options.CheckpointPath == "" {
+		return
+	}
+	interval := options.CheckpointInterval
+	if interval <= 0 {
+		interval = 1
+	}
+	if state.step%interval != 0 {
+		return
+	}
+
+	tmpPath := options.CheckpointPath + ".tmp"
+	w, err := util.CreateModelFile(tmpPath, checkpointFileType,
+		map[string]string{"optimizer": options.OptimizerName})
+	if err != nil {
+		log.Fatal("无法写入", tmpPath, "文件，错误", err)
+	}
+	state.encode(w.BinaryEncoder)
+	if err := w.Close(); err != nil {
+		log.Fatal("无法写入", tmpPath, "文件，错误", err)
+	}
+	if err := os.Rename(tmpPath, options.CheckpointPath); err != nil {
+		log.Fatal("无法写入", options.CheckpointPath, "文件，错误", err)
+	}
+}
+
+// 如果ResumeFromCheckpoint为true并且检查点文件存在，从中恢复状态并返回true
+func loadCheckpoint(options OptimizerOptions, state *optimizationState) bool {
+	if !options.ResumeFromCheckpoint || options.CheckpointPath == "" {
+		return false
+	}
+	path := options.CheckpointPath
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false
+	}
+
+	r, err := util.OpenModelFile(path)
+	if err != nil {
+		log.Fatal("无法打开", path, "文件，错误", err)
+	}
+	defer r.Close()
+	if r.ModelType != checkpointFileType {
+		log.Fatal(path, "文件不是优化器检查点")
+	}
+	if r.Metadata["optimizer"] != options.OptimizerName {
+		log.Fatal(path, "文件是", r.Metadata["optimizer"], "优化器的检查点，和",
+			options.OptimizerName, "不一致")
+	}
+	state.decode(r.BinaryDecoder)
+	if r.Err() != nil {
+		log.Fatal("无法解析", path, "文件，错误", r.Err())
+	}
+	log.Printf("从检查点%s恢复，已完成%d次迭代", path, state.step)
+	return true
+}
+
+func (state *optimizationState) encode(e *util.BinaryEncoder) {
+	e.WriteInt(state.step)
+	state.weights.EncodeBinary(e)
+	state.optimizer.encodeState(e)
+	state.learningRate.EncodeBinary(e)
+	state.checker.encodeState(e)
+	state.earlyStopping.encodeState(e)
+}
+
+func (state *optimizationState) decode(d *util.BinaryDecoder) {
+	state.step = d.ReadInt()
+	weights := new(util.Matrix)
+	weights.DecodeBinary(d)
+	if d.Err() != nil {
+		return
+	}
+	if weights.IsSparse() != state.weights.IsSparse() ||
+		weights.NumLabels() != state.weights.NumLabels() ||
+		(!weights.IsSparse() && weights.NumValues() != state.weights.NumValues()) {
+		d.SetErr(fmt.Errorf("检查点中的权重维度和当前权重不一致"))
+		return
+	}
+	*state.weights = *weights
+	state.optimizer.decodeState(d)
+	state.learningRate.DecodeBinary(d)
+	state.checker.decodeState(d)
+	state.earlyStopping.decodeState(d)
+}
+
+func (c *convergenceChecker) encodeState(e *util.BinaryEncoder) {
+	e.WriteInt(c.convergingSteps)
+	e.WriteFloat64(c.oldLoss)
+	e.WriteBool(c.hasOldLoss)
+}
+
+func (c *convergenceChecker) decodeState(d *util.BinaryDecoder) {
+	c.convergingSteps = d.ReadInt()
+	c.oldLoss = d.ReadFloat64()
+	c.hasOldLoss = d.ReadBool()
+}
+
+// 不使用验证集时es为nil，此时只写入一个false
+func (es *earlyStopping) encodeState(e *util.BinaryEncoder) {
+	e.WriteBool(es != nil)
+	if es == nil {
+		return
+	}
+	es.bestWeights.EncodeBinary(e)
+	e.WriteFloat64(es.bestScore)
+	e.WriteInt(es.bestIteration)
+	e.WriteInt(es.stepsWithoutImprovement)
+}
+
+func (es *earlyStopping) decodeState(d *util.BinaryDecoder) {
+	hasState := d.ReadBool()
+	if hasState != (es != nil) {
+		d.SetErr(fmt.Errorf("检查点和当前选项中是否使用验证集不一致"))
+		return
+	}
+	if es == nil {
+		return
+	}
+	es.bestWeights.DecodeBinary(d)
+	es.bestScore = d.ReadFloat64()
+	es.bestIteration = d.ReadInt()
+	es.stepsWithoutImprovement = d.ReadInt()
+}
+
+// 梯度递降没有内部状态
+func (opt *gdOptimizer) encodeState(e *util.BinaryEncoder) {
+}
+
+func (opt *gdOptimizer) decodeState(d *util.BinaryDecoder) {
+}
+
+// Hogwild没有内部状态，已经处理的样本数保存在学习率中
+func (opt *hogwildOptimizer) encodeState(e *util.BinaryEncoder) {
+}
+
+func (opt *hogwildOptimizer) decodeState(d *util.BinaryDecoder) {
+}
+
+// 保存历史步数k以及所有的历史x、g、s、y和ro，owlqn共用
+func (opt *lbfgsOptimizer) encodeState(e *util.BinaryEncoder) {
+	e.WriteInt(opt.historySize)
+	e.WriteInt(opt.k)
+	if opt.k == 0 {
+		return
+	}
+	e.WriteInt(opt.labels)
+	for i := 0; i < opt.historySize; i++ {
+		opt.x[i].EncodeBinary(e)
+		opt.g[i].EncodeBinary(e)
+		opt.s[i].EncodeBinary(e)
+		opt.y[i].EncodeBinary(e)
+	}
+	opt.ro.EncodeBinary(e)
+}
+
+func (opt *lbfgsOptimizer) decodeState(d *util.BinaryDecoder) {
+	historySize := d.ReadInt()
+	if d.Err() == nil && historySize != opt.historySize {
+		d.SetErr(fmt.Errorf("检查点的LbfgsHistorySize为%d，和当前值%d不一致",
+			historySize, opt.historySize))
+		return
+	}
+	opt.k = d.ReadInt()
+	if opt.k == 0 || d.Err() != nil {
+		return
+	}
+	opt.labels = d.ReadInt()
+
+	decodeMatrix := func() *util.Matrix {
+		m := new(util.Matrix)
+		m.DecodeBinary(d)
+		return m
+	}
+	opt.x = make([]*util.Matrix, historySize)
+	opt.g = make([]*util.Matrix, historySize)
+	opt.s = make([]*util.Matrix, historySize)
+	opt.y = make([]*util.Matrix, historySize)
+	for i := 0; i < historySize && d.Err() == nil; i++ {
+		opt.x[i] = decodeMatrix()
+		opt.g[i] = decodeMatrix()
+		opt.s[i] = decodeMatrix()
+		opt.y[i] = decodeMatrix()
+	}
+	opt.ro = new(util.Vector)
+	opt.ro.DecodeBinary(d)
+	if d.Err() != nil {
+		return
+	}
+	opt.alpha = util.NewVector(historySize)
+	opt.beta = util.NewVector(historySize)
+	opt.q = opt.x[0].Populate()
+	opt.z = opt.x[0].Populate()
+}
+
+// 保存步数t和各元素的一阶矩、二阶矩
+func (opt *adaptiveOptimizer) encodeState(e *util.BinaryEncoder) {
+	e.WriteInt(opt.t)
+	e.WriteBool(opt.firstMoment != nil)
+	if opt.firstMoment != nil {
+		opt.firstMoment.EncodeBinary(e)
+		opt.secondMoment.EncodeBinary(e)
+	}
+}
+
+func (opt *adaptiveOptimizer) decodeState(d *util.BinaryDecoder) {
+	opt.t = d.ReadInt()
+	opt.firstMoment = nil
+	opt.secondMoment = nil
+	if d.ReadBool() {
+		opt.firstMoment = new(util.Matrix)
+		opt.firstMoment.DecodeBinary(d)
+		opt.secondMoment = new(util.Matrix)
+		opt.secondMoment.DecodeBinary(d)
+	}
+}
+
+// 保存信赖域半径和已经完成的信赖域迭代数
+func (opt *tronOptimizer) encodeState(e *util.BinaryEncoder) {
+	e.WriteFloat64(opt.radius)
+	e.WriteInt(opt.k)
+}
+
+func (opt *tronOptimizer) decodeState(d *util.BinaryDecoder) {
+	opt.radius = d.ReadFloat64()
+	opt.k = d.ReadInt()
+}
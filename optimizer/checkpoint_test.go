@@ -0,0 +1,55 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+	"github.com/huichen/mlf/util"
+	"os"
+	"testing"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	for _, name := range []string{"gd", "adam", "lbfgs", "owlqn"} {
+		for _, sparse := range []bool{false, true} {
+			options := OptimizerOptions{
+				OptimizerName:        name,
+				LearningRate:         0.5,
+				CharacteristicTime:   2,
+				GDBatchSize:          3,
+				RegularizationScheme: 1,
+				RegularizationFactor: 0.1,
+				MaxIterations:        6,
+			}
+			newWeights := func() *util.Matrix {
+				if sparse {
+					return util.NewSparseMatrix(1)
+				}
+				return util.NewMatrix(1, 3)
+			}
+
+			// 不中断地优化6次迭代
+			expected := newWeights()
+			NewOptimizer(options).OptimizeWeights(context.Background(),
+				expected, logisticDerivative, newLogisticTestDataset(sparse))
+
+			// 优化3次迭代后写入检查点，再用新的优化器从检查点继续
+			options.CheckpointPath = "test_checkpoint.mlf"
+			options.MaxIterations = 3
+			weights := newWeights()
+			NewOptimizer(options).OptimizeWeights(context.Background(),
+				weights, logisticDerivative, newLogisticTestDataset(sparse))
+
+			options.MaxIterations = 6
+			options.ResumeFromCheckpoint = true
+			weights = newWeights()
+			result := NewOptimizer(options).OptimizeWeights(context.Background(),
+				weights, logisticDerivative, newLogisticTestDataset(sparse))
+			os.Remove("test_checkpoint.mlf")
+
+			util.Expect(t, "6", result.Iterations)
+			for k := 0; k < 3; k++ {
+				util.Expect(t, fmt.Sprint(expected.Get(0, k)), weights.Get(0, k))
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package optimizer
+
+import (
+	"log"
+	"math"
+)
+
+// 收敛判断，各优化器共用
+//
+// 每次迭代后调用converged，当下面任一条件满足时该次迭代记为一个收敛步
+//   1. |dw| / |w| < ConvergingDeltaWeight
+//   2. 目标函数的相对变化 |f_old - f| / max(|f_old|, |f|, 1) < ConvergingRelativeLoss
+//   3. 目标函数偏导数的长度 |g| < ConvergingGradientNorm
+// 值为0的条件不使用。必须连续出现超过ConvergingSteps（见convergingSteps）个收敛步才认为收敛。
+type convergenceChecker struct {
+	options         OptimizerOptions
+	convergingSteps int
+	oldLoss         float64
+	hasOldLoss      bool
+}
+
+func newConvergenceChecker(options OptimizerOptions) *convergenceChecker {
+	return &convergenceChecker{options: options}
+}
+
+// 输入本次迭代的权重变化比例|dw|/|w|、目标函数值和偏导数长度，返回是否收敛
+func (c *convergenceChecker) converged(weightsDeltaRatio, loss, gradientNorm float64) bool {
+	reason := ""
+	if weightsDeltaRatio < c.options.ConvergingDeltaWeight {
+		reason = "|dw|/|w|"
+	}
+	if c.hasOldLoss && c.options.ConvergingRelativeLoss > 0 {
+		scale := math.Max(math.Max(math.Abs(c.oldLoss), math.Abs(loss)), 1)
+		if math.Abs(c.oldLoss-loss)/scale < c.options.ConvergingRelativeLoss {
+			reason = "目标函数相对变化"
+		}
+	}
+	if gradientNorm < c.options.ConvergingGradientNorm {
+		reason = "|g|"
+	}
+	c.oldLoss = loss
+	c.hasOldLoss = true
+
+	if reason == "" {
+		c.convergingSteps = 0
+		return false
+	}
+	c.convergingSteps++
+	if c.convergingSteps > convergingSteps(c.options) {
+		log.Printf("收敛（%s）", reason)
+		return true
+	}
+	return false
+}
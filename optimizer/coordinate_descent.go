@@ -0,0 +1,376 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/util"
+	"log"
+	"math"
+)
+
+// 坐标下降法（coordinate descent）优化器
+//
+// 算法见下面的论文（CDN），每次只优化一个权重元素
+//   Yuan, G.-X., Chang, K.-W., Hsieh, C.-J. and Lin, C.-J. (2010). "A Comparison of
+//   Optimization Methods and Software for Large-scale L1-regularized Linear Classification".
+//   Journal of Machine Learning Research 11: 3183-3234.
+//
+// 每次迭代按特征从小到大、每个特征按权重的行依次更新所有出现过的特征。对第l行第j个元素，
+// 用损失对该元素的一阶和二阶导数构造二次近似，加上正则化项后的极小值点有解析解（L1部分
+// 为软阈值），然后用回溯线搜索保证目标函数下降。L1正则化时元素可以被精确地置为零。
+//
+// 优化器在数据集的列视图（见data.ColumnView）上工作，并为每个样本保存各行的得分，
+// 因此只支持广义线性模型：样本损失只通过得分 w_l . x 依赖于权重，使用前必须调用
+// SetScoreLossFunc。OptimizeWeights的derivative_func不被使用。
+//
+// 和lbfgs一样，optimizer是协程不安全的。
+type cdOptimizer struct {
+	options OptimizerOptions
+
+	scoreLossFunc ComputeInstanceScoreLossFunc
+
+	// 数据集的列视图
+	view *data.ColumnView
+
+	// 各样本的得分、损失以及损失对得分的一阶和二阶导数
+	scores      [][]float64
+	losses      []float64
+	derivatives [][]float64
+	curvatures  [][]float64
+
+	// 线搜索中试探点的得分、损失和导数，接受试探点时和上面的数组交换
+	trialScores      [][]float64
+	trialLosses      []float64
+	trialDerivatives [][]float64
+	trialCurvatures  [][]float64
+
+	// 正则化项中L1部分和L2部分的因子，还需要乘以各特征的正则化系数
+	l1, l2 float64
+}
+
+// 坐标下降法线搜索的参数
+const (
+	// 充分下降条件 F(w + t * d) - F(w) <= cdSigma * t * Delta 中的系数
+	cdSigma = 0.01
+
+	// 每次回溯步长乘以cdBeta，最多回溯cdMaxLineSearchSteps次
+	cdBeta               = 0.5
+	cdMaxLineSearchSteps = 20
+
+	// 二阶导数的下限，避免除零
+	cdMinCurvature = 1e-12
+)
+
+// 开辟新的cdOptimizer指针
+func NewCdOptimizer(options OptimizerOptions) Optimizer {
+	opt := new(cdOptimizer)
+	opt.options = options
+	return opt
+}
+
+// 设置单个样本的损失函数
+func (opt *cdOptimizer) SetScoreLossFunc(score_loss_func ComputeInstanceScoreLossFunc) {
+	opt.scoreLossFunc = score_loss_func
+}
+
+// 坐标下降法没有跨越多次优化的状态
+func (opt *cdOptimizer) Clear() {
+}
+
+// 坐标下降法需要逐个特征访问数据，只输入x和g时无法计算，这里返回负梯度方向
+func (opt *cdOptimizer) GetDeltaX(x, g *util.Matrix) *util.Matrix {
+	return g.Opposite()
+}
+
+func (opt *cdOptimizer) OptimizeWeights(ctx context.Context,
+	weights *util.Matrix, derivative_func ComputeInstanceDerivativeFunc, set data.Dataset) OptimizationResult {
+	if opt.scoreLossFunc == nil {
+		log.Fatal("cd优化器需要广义线性模型的损失函数，请先调用SetScoreLossFunc")
+	}
+	var group float64
+	opt.l1, opt.l2, group = regularizationFactors(opt.options)
+	if group != 0 {
+		log.Fatal("cd优化器不支持组套索正则化")
+	}
+	opt.view = data.NewColumnView(set)
+	opt.initStruct(weights.NumLabels())
+	if !weights.IsSparse() {
+		features := opt.view.Features()
+		if len(features) > 0 && features[len(features)-1] >= weights.NumValues() {
+			log.Fatal("特征维度超出了权重的维度")
+		}
+	}
+	numInstances := float64(opt.view.NumInstances())
+
+	// 优化循环
+	step := 0
+	checker := newConvergenceChecker(opt.options)
+	es := newEarlyStopping(opt.options, weights)
+
+	// 从检查点恢复，cd不使用学习率，保存它只是为了和其它优化器使用相同的检查点格式
+	state := &optimizationState{
+		weights:       weights,
+		optimizer:     opt,
+		learningRate:  NewLearningRate(opt.options),
+		checker:       checker,
+		earlyStopping: es,
+	}
+	if loadCheckpoint(opt.options, state) {
+		step = state.step
+	} else {
+		opt.computeScores(weights)
+	}
+	for i := range opt.scores {
+		opt.losses[i] = opt.scoreLossFunc(
+			opt.scores[i], opt.view.GetInstance(i), opt.derivatives[i], opt.curvatures[i])
+	}
+
+	oldWeights := weights.Populate()
+	oldWeights.DeepCopy(weights)
+	weightsDelta := weights.Populate()
+
+	log.Print("开始坐标下降优化")
+	loss := opt.objective(weights) / numInstances
+	canceled := false
+	for {
+		if opt.options.MaxIterations > 0 && step >= opt.options.MaxIterations {
+			break
+		}
+		if ctx.Err() != nil {
+			canceled = true
+			break
+		}
+		step++
+
+		// 依次更新所有坐标
+		for _, feature := range opt.view.Features() {
+			for iLabel := 0; iLabel < weights.NumLabels(); iLabel++ {
+				opt.updateCoordinate(weights, iLabel, feature)
+			}
+		}
+		weights.RemoveZeros()
+		loss = opt.objective(weights) / numInstances
+
+		weightsDelta.WeightedSum(weights, oldWeights, 1, -1)
+		oldWeights.DeepCopy(weights)
+		weightsNorm := weights.Norm()
+		weightsDeltaNorm := weightsDelta.Norm()
+		derivativeNorm := opt.pseudoGradientNorm(weights) / numInstances
+
+		// L1正则化很强时权重可能全部为零
+		weightsDeltaRatio := float64(0)
+		if weightsDeltaNorm != 0 {
+			weightsDeltaRatio = weightsDeltaNorm / weightsNorm
+		}
+		log.Printf("#%d f=%1.6g |g|=%1.3g |dw|/|w|=%f |w|=%f 非零权重=%d",
+			step, loss, derivativeNorm, weightsDeltaRatio, weightsNorm, countNonZeros(weights))
+
+		// 判断是否溢出
+		if math.IsNaN(loss) {
+			log.Fatal("优化失败：不收敛")
+		}
+
+		reportProgress(opt.options, step, loss, weightsDeltaRatio, 0, weights)
+
+		// 先在验证集上评价这次迭代的权重，收敛时最后的权重也参与比较
+		if es.update(step, weights) {
+			break
+		}
+		// 判断是否收敛
+		if checker.converged(weightsDeltaRatio, loss, derivativeNorm) {
+			break
+		}
+
+		state.step = step
+		saveCheckpoint(opt.options, state)
+	}
+
+	result := OptimizationResult{Iterations: step, Loss: loss, Canceled: canceled}
+	es.finish(weights, &result)
+	return result
+}
+
+// 为各样本开辟得分和导数的存储空间
+func (opt *cdOptimizer) initStruct(numLabels int) {
+	numInstances := opt.view.NumInstances()
+	newRows := func() [][]float64 {
+		rows := make([][]float64, numInstances)
+		for i := range rows {
+			rows[i] = make([]float64, numLabels)
+		}
+		return rows
+	}
+	opt.scores = newRows()
+	opt.derivatives = newRows()
+	opt.curvatures = newRows()
+	opt.losses = make([]float64, numInstances)
+	opt.trialScores = newRows()
+	opt.trialDerivatives = newRows()
+	opt.trialCurvatures = newRows()
+	opt.trialLosses = make([]float64, numInstances)
+}
+
+// 根据权重计算各样本的得分
+func (opt *cdOptimizer) computeScores(weights *util.Matrix) {
+	for i := range opt.scores {
+		features := opt.view.GetInstance(i).Features
+		for iLabel := 0; iLabel < weights.NumLabels(); iLabel++ {
+			opt.scores[i][iLabel] = util.VecDotProduct(features, weights.GetValues(iLabel))
+		}
+	}
+}
+
+// 目标函数值 sum_i loss_i + R(w)，没有除以样本数
+func (opt *cdOptimizer) objective(weights *util.Matrix) float64 {
+	loss := float64(0)
+	for _, l := range opt.losses {
+		loss += l
+	}
+	return loss + ComputeRegularizationLoss(weights, opt.options)
+}
+
+// 用CDN更新第iLabel行第feature个权重元素
+func (opt *cdOptimizer) updateCoordinate(weights *util.Matrix, iLabel, feature int) {
+	column := opt.view.GetColumn(feature)
+	w := weights.Get(iLabel, feature)
+	l1 := opt.l1 * regularizationMultiplier(opt.options, feature)
+	l2 := opt.l2 * regularizationMultiplier(opt.options, feature)
+
+	// 损失对该元素的一阶和二阶导数，加上L2部分
+	g := l2 * w
+	h := l2
+	for _, entry := range column {
+		g += opt.derivatives[entry.Instance][iLabel] * entry.Value
+		h += opt.curvatures[entry.Instance][iLabel] * entry.Value * entry.Value
+	}
+	if h < cdMinCurvature {
+		h = cdMinCurvature
+	}
+
+	// 二次近似 g * d + h * d^2 / 2 + l1 * |w + d| 的极小值点
+	var d float64
+	if g+l1 <= h*w {
+		d = -(g + l1) / h
+	} else if g-l1 >= h*w {
+		d = -(g - l1) / h
+	} else {
+		d = -w
+	}
+	if d == 0 {
+		return
+	}
+
+	// 回溯线搜索，只需要计算该特征出现的样本的损失
+	oldLoss := coordinatePenalty(w, l1, l2)
+	for _, entry := range column {
+		oldLoss += opt.losses[entry.Instance]
+	}
+	delta := g*d + l1*(math.Abs(w+d)-math.Abs(w))
+	t := float64(1)
+	for iStep := 0; iStep < cdMaxLineSearchSteps; iStep++ {
+		newW := w + t*d
+		newLoss := coordinatePenalty(newW, l1, l2)
+		for _, entry := range column {
+			i := entry.Instance
+			copy(opt.trialScores[i], opt.scores[i])
+			opt.trialScores[i][iLabel] += (newW - w) * entry.Value
+			opt.trialLosses[i] = opt.scoreLossFunc(opt.trialScores[i], opt.view.GetInstance(i),
+				opt.trialDerivatives[i], opt.trialCurvatures[i])
+			newLoss += opt.trialLosses[i]
+		}
+
+		if newLoss-oldLoss <= cdSigma*t*delta {
+			for _, entry := range column {
+				i := entry.Instance
+				opt.scores[i], opt.trialScores[i] = opt.trialScores[i], opt.scores[i]
+				opt.derivatives[i], opt.trialDerivatives[i] = opt.trialDerivatives[i], opt.derivatives[i]
+				opt.curvatures[i], opt.trialCurvatures[i] = opt.trialCurvatures[i], opt.curvatures[i]
+				opt.losses[i] = opt.trialLosses[i]
+			}
+			weights.Set(iLabel, feature, newW)
+			return
+		}
+		t *= cdBeta
+	}
+}
+
+// 单个权重元素的正则化项
+func coordinatePenalty(w, l1, l2 float64) float64 {
+	return l1*math.Abs(w) + l2*w*w/2
+}
+
+// 目标函数（没有除以样本数）的伪梯度长度，不可导的零点处取次梯度中长度最小的元素，
+// 和owlqn的伪梯度相同
+func (opt *cdOptimizer) pseudoGradientNorm(weights *util.Matrix) float64 {
+	result := float64(0)
+	for _, feature := range opt.view.Features() {
+		l1 := opt.l1 * regularizationMultiplier(opt.options, feature)
+		l2 := opt.l2 * regularizationMultiplier(opt.options, feature)
+		for iLabel := 0; iLabel < weights.NumLabels(); iLabel++ {
+			w := weights.Get(iLabel, feature)
+			g := l2 * w
+			for _, entry := range opt.view.GetColumn(feature) {
+				g += opt.derivatives[entry.Instance][iLabel] * entry.Value
+			}
+			if w > 0 {
+				g += l1
+			} else if w < 0 {
+				g -= l1
+			} else if g > l1 {
+				g -= l1
+			} else if g < -l1 {
+				g += l1
+			} else {
+				g = 0
+			}
+			result += g * g
+		}
+	}
+	return math.Sqrt(result)
+}
+
+// 非零权重的个数
+func countNonZeros(weights *util.Matrix) int {
+	count := 0
+	for iLabel := 0; iLabel < weights.NumLabels(); iLabel++ {
+		vec := weights.GetValues(iLabel)
+		for _, k := range vec.Keys() {
+			if vec.Get(k) != 0 {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// 保存各样本的得分：从权重重新计算的得分和增量更新的得分在浮点误差上不同，
+// 保存得分才能保证从检查点继续的结果和不中断时相同
+func (opt *cdOptimizer) encodeState(e *util.BinaryEncoder) {
+	e.WriteInt(len(opt.scores))
+	for _, row := range opt.scores {
+		e.WriteInt(len(row))
+		for _, score := range row {
+			e.WriteFloat64(score)
+		}
+	}
+}
+
+func (opt *cdOptimizer) decodeState(d *util.BinaryDecoder) {
+	numInstances := d.ReadInt()
+	if d.Err() == nil && numInstances != len(opt.scores) {
+		d.SetErr(fmt.Errorf("检查点的样本数为%d，和当前数据集的%d不一致",
+			numInstances, len(opt.scores)))
+		return
+	}
+	for i := 0; i < numInstances && d.Err() == nil; i++ {
+		numLabels := d.ReadInt()
+		if d.Err() == nil && numLabels != len(opt.scores[i]) {
+			d.SetErr(fmt.Errorf("检查点中的得分维度和当前权重不一致"))
+			return
+		}
+		for iLabel := 0; iLabel < numLabels; iLabel++ {
+			opt.scores[i][iLabel] = d.ReadFloat64()
+		}
+	}
+}
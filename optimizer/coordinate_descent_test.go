@@ -0,0 +1,100 @@
+package optimizer
+
+import (
+	"context"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/util"
+	"math"
+	"testing"
+)
+
+// 二分类logistic回归的广义线性模型损失
+func logisticScoreLoss(scores []float64, instance *data.Instance, derivative, curvature []float64) float64 {
+	p := 1 / (1 + math.Exp(-scores[0]))
+	y := float64(instance.Output.Label)
+	derivative[0] = p - y
+	curvature[0] = p * (1 - p)
+	return math.Log(1+math.Exp(scores[0])) - y*scores[0]
+}
+
+func TestCdOptimizer(t *testing.T) {
+	for _, sparse := range []bool{false, true} {
+		newWeights := func() *util.Matrix {
+			if sparse {
+				return util.NewSparseMatrix(1)
+			}
+			return util.NewMatrix(1, 3)
+		}
+		options := OptimizerOptions{
+			RegularizationScheme:   1,
+			RegularizationFactor:   2,
+			ConvergingGradientNorm: 1e-8,
+			MaxIterations:          200,
+		}
+
+		options.OptimizerName = "owlqn"
+		expected := newWeights()
+		NewOptimizer(options).OptimizeWeights(context.Background(),
+			expected, logisticDerivative, newLogisticTestDataset(sparse))
+
+		// 和owlqn收敛到同一个极小值点，噪音特征的权重被精确地置为零
+		options.OptimizerName = "cd"
+		opt := NewOptimizer(options)
+		opt.(ScoreLossOptimizer).SetScoreLossFunc(logisticScoreLoss)
+		weights := newWeights()
+		opt.OptimizeWeights(context.Background(),
+			weights, logisticDerivative, newLogisticTestDataset(sparse))
+		for k := 0; k < 3; k++ {
+			util.ExpectNear(t, expected.Get(0, k), weights.Get(0, k), 1e-4)
+		}
+		util.Expect(t, "0", weights.Get(0, 2))
+	}
+}
+
+func TestCdOptimizerElasticNet(t *testing.T) {
+	options := OptimizerOptions{
+		RegularizationScheme:   3,
+		RegularizationFactor:   1,
+		ElasticNetRatio:        0.5,
+		ConvergingGradientNorm: 1e-8,
+		MaxIterations:          200,
+	}
+
+	// 弹性网络的目标函数可导处和lbfgs结果相同
+	options.OptimizerName = "cd"
+	opt := NewOptimizer(options)
+	opt.(ScoreLossOptimizer).SetScoreLossFunc(logisticScoreLoss)
+	weights := util.NewMatrix(1, 3)
+	opt.OptimizeWeights(context.Background(), weights, logisticDerivative, newLogisticTestDataset(false))
+
+	set := newLogisticTestDataset(false)
+	objective := newDatasetObjective(weights, logisticDerivative, set, options, 1)
+	derivative := weights.Populate()
+	objective.Evaluate(weights, derivative)
+	for k := 0; k < 3; k++ {
+		if weights.Get(0, k) != 0 {
+			util.ExpectNear(t, 0, derivative.Get(0, k), 1e-6)
+		}
+	}
+}
+
+func TestRegularizationPath(t *testing.T) {
+	set := newLogisticTestDataset(true)
+	weights := util.NewSparseMatrix(1)
+	path := ComputeRegularizationPath(context.Background(), OptimizerOptions{
+		OptimizerName:         "cd",
+		RegularizationScheme:  1,
+		ConvergingDeltaWeight: 1e-6,
+		MaxIterations:         100,
+	}, RegularizationPathOptions{NumFactors: 10, MinFactorRatio: 0.01},
+		weights, logisticDerivative, logisticScoreLoss, set)
+
+	// 第一个因子下所有权重为零，因子递减时非零权重增加
+	util.Expect(t, "10", len(path))
+	util.Expect(t, "0", path[0].NumNonZeros)
+	for i := 1; i < len(path); i++ {
+		util.Expect(t, "true", path[i].Factor < path[i-1].Factor)
+		util.Expect(t, "true", path[i].NumNonZeros >= path[i-1].NumNonZeros)
+	}
+	util.Expect(t, "3", path[len(path)-1].NumNonZeros)
+}
@@ -0,0 +1,82 @@
+package optimizer
+
+import (
+	"github.com/huichen/mlf/util"
+	"log"
+)
+
+// 基于验证集的提前终止，各优化器共用，见OptimizerOptions.ValidationSet
+//
+// 使用方法
+//   es := newEarlyStopping(options, weights)
+//   for 每次迭代 {
+//     ...
+//     if es.update(step, weights) {
+//       break
+//     }
+//     if 收敛 {  // 必须在es.update之后判断，否则收敛时最后的权重不会被评价
+//       break
+//     }
+//   }
+//   es.finish(weights, &result)
+//
+// 不使用验证集时newEarlyStopping返回nil，nil的earlyStopping上的方法什么也不做。
+type earlyStopping struct {
+	options OptimizerOptions
+
+	// 验证集上分数最高的权重和对应的迭代
+	bestWeights   *util.Matrix
+	bestScore     float64
+	bestIteration int
+
+	// 连续多少次迭代分数没有提高
+	stepsWithoutImprovement int
+}
+
+func newEarlyStopping(options OptimizerOptions, weights *util.Matrix) *earlyStopping {
+	if options.ValidationSet == nil {
+		return nil
+	}
+	if options.ValidationScore == nil {
+		log.Fatal("使用验证集时必须指定ValidationScore")
+	}
+	es := new(earlyStopping)
+	es.options = options
+	es.bestWeights = weights.Populate()
+	return es
+}
+
+// 在验证集上评价第step次迭代后的权重，返回是否应该停止优化
+func (es *earlyStopping) update(step int, weights *util.Matrix) bool {
+	if es == nil {
+		return false
+	}
+
+	score := es.options.ValidationScore(weights, es.options.ValidationSet)
+	if es.bestIteration == 0 || score > es.bestScore {
+		es.bestScore = score
+		es.bestIteration = step
+		es.bestWeights.DeepCopy(weights)
+		es.stepsWithoutImprovement = 0
+	} else {
+		es.stepsWithoutImprovement++
+	}
+	log.Printf("#%d 验证集分数=%f 最佳迭代=#%d", step, score, es.bestIteration)
+
+	if es.options.EarlyStoppingSteps > 0 &&
+		es.stepsWithoutImprovement >= es.options.EarlyStoppingSteps {
+		log.Printf("验证集分数连续%d次迭代没有提高，提前终止", es.stepsWithoutImprovement)
+		return true
+	}
+	return false
+}
+
+// 优化结束时将权重恢复为验证集上分数最高的权重，并记录到result中
+func (es *earlyStopping) finish(weights *util.Matrix, result *OptimizationResult) {
+	if es == nil || es.bestIteration == 0 {
+		return
+	}
+	weights.DeepCopy(es.bestWeights)
+	result.BestIteration = es.bestIteration
+	result.ValidationScore = es.bestScore
+}
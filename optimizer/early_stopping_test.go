@@ -0,0 +1,72 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/util"
+	"testing"
+)
+
+func TestEarlyStopping(t *testing.T) {
+	set := newLogisticTestDataset(false)
+	weights := util.NewMatrix(1, 3)
+
+	// 验证集分数在第3次迭代后下降
+	scores := []float64{0.5, 0.7, 0.9, 0.8, 0.6, 0.6, 0.6}
+	var scoredWeights []float64
+	calls := 0
+	opt := NewOptimizer(OptimizerOptions{
+		OptimizerName: "gd",
+		LearningRate:  0.5,
+		MaxIterations: 100,
+		ValidationSet: set,
+		ValidationScore: func(weights *util.Matrix, set data.Dataset) float64 {
+			scoredWeights = append(scoredWeights, weights.Get(0, 1))
+			calls++
+			return scores[calls-1]
+		},
+		EarlyStoppingSteps: 2,
+	})
+	result := opt.OptimizeWeights(context.Background(), weights, logisticDerivative, set)
+
+	util.Expect(t, "5", result.Iterations)
+	util.Expect(t, "3", result.BestIteration)
+	util.Expect(t, "0.9", result.ValidationScore)
+	util.Expect(t, "3", result.Metadata()["best_iteration"])
+
+	// 权重恢复为第3次迭代的权重
+	util.Expect(t, "5", len(scoredWeights))
+	util.Expect(t, "true", scoredWeights[2] != scoredWeights[4])
+	util.ExpectNear(t, scoredWeights[2], weights.Get(0, 1), 1e-12)
+}
+
+func TestEarlyStoppingWithConvergence(t *testing.T) {
+	// 验证集分数一直提高，优化因收敛而结束时最后的权重也要被评价和保留
+	set := newLogisticTestDataset(false)
+	for _, name := range []string{"gd", "lbfgs", "owlqn", "svrg"} {
+		weights := util.NewMatrix(1, 3)
+		var lastScored *util.Matrix
+		calls := 0
+		opt := NewOptimizer(OptimizerOptions{
+			OptimizerName:         name,
+			LearningRate:          0.5,
+			ConvergingDeltaWeight: 1e-3,
+			ConvergingSteps:       1,
+			MaxIterations:         1000,
+			ValidationSet:         set,
+			ValidationScore: func(weights *util.Matrix, set data.Dataset) float64 {
+				lastScored = weights.Populate()
+				lastScored.DeepCopy(weights)
+				calls++
+				return float64(calls)
+			},
+		})
+		result := opt.OptimizeWeights(context.Background(), weights, logisticDerivative, set)
+
+		util.Expect(t, "true", result.Iterations < 1000)
+		util.Expect(t, fmt.Sprint(result.Iterations), calls)
+		util.Expect(t, fmt.Sprint(result.Iterations), result.BestIteration)
+		util.ExpectNear(t, lastScored.Get(0, 1), weights.Get(0, 1), 1e-12)
+	}
+}
@@ -1,6 +1,7 @@
 package optimizer
 
 import (
+	"context"
 	"github.com/huichen/mlf/data"
 	"github.com/huichen/mlf/util"
 	"log"
@@ -28,87 +29,162 @@ func (opt *gdOptimizer) GetDeltaX(x, g *util.Matrix) *util.Matrix {
 	return g.Opposite()
 }
 
-func (opt *gdOptimizer) OptimizeWeights(
-	weights *util.Matrix, derivative_func ComputeInstanceDerivativeFunc, set data.Dataset) {
+func (opt *gdOptimizer) OptimizeWeights(ctx context.Context,
+	weights *util.Matrix, derivative_func ComputeInstanceDerivativeFunc, set data.Dataset) OptimizationResult {
+	log.Print("开始梯度递降优化")
+	return optimizeWithFirstOrderSteps(ctx, opt, opt.options, false, weights, derivative_func, set)
+}
+
+// 一阶优化器（梯度递降及其变种）共用的优化循环
+//
+// 每处理GDBatchSize个样本（为0时为全部样本）后调用opt.GetDeltaX得到增量，并按学习率
+// 更新权重。每次遍历样本后汇报目标函数值（见datasetObjective），对于mini-batch和
+// stochastic GD这是遍历过程中各样本损失的平均值，只是目标函数的近似。
+// 当lazyRegularization为true时正则化项只作用于当前批次中偏导数非零的
+// 特征，这样稀疏权重中未出现的特征不会被更新。
+//
+// 每个批次之后检查ctx，取消时权重恢复为最近一次完整遍历后的值。
+func optimizeWithFirstOrderSteps(ctx context.Context, opt Optimizer, options OptimizerOptions,
+	lazyRegularization bool, weights *util.Matrix, derivative_func ComputeInstanceDerivativeFunc, set data.Dataset) OptimizationResult {
 	// 偏导数向量
 	derivative := weights.Populate()
 
 	// 学习率计算器
-	learningRate := NewLearningRate(opt.options)
+	learningRate := NewLearningRate(options)
 
 	// 优化循环
 	iterator := set.CreateIterator()
 	step := 0
 	var learning_rate float64
-	convergingSteps := 0
+	checker := newConvergenceChecker(options)
+	es := newEarlyStopping(options, weights)
+	loss := float64(0)
+
+	// 从检查点恢复
+	state := &optimizationState{
+		weights:       weights,
+		optimizer:     opt.(checkpointState),
+		learningRate:  learningRate,
+		checker:       checker,
+		earlyStopping: es,
+	}
+	if loadCheckpoint(options, state) {
+		step = state.step
+	}
+
 	oldWeights := weights.Populate()
+	oldWeights.DeepCopy(weights)
 	weightsDelta := weights.Populate()
 	instanceDerivative := weights.Populate()
-	log.Print("开始梯度递降优化")
+
+	// 一次遍历中所有批次偏导数之和，用于判断收敛
+	epochDerivative := weights.Populate()
+	regularization := func() *util.Matrix {
+		if lazyRegularization {
+			return ComputeRegularizationOnKeys(weights, derivative, options)
+		}
+		return ComputeRegularization(weights, options)
+	}
+
+	// 处理numInstances个样本后更新权重
+	updateWeights := func(numInstances int) {
+		// 正则化项在偏导数中的比例
+		regularizationScale := float64(numInstances) / (float64(set.NumInstances()) * float64(set.NumInstances()))
+		if !options.ProximalRegularization {
+			derivative.Increment(regularization(), regularizationScale)
+		}
+
+		// 计算特征权重的增量，根据学习率更新权重
+		delta := opt.GetDeltaX(weights, derivative)
+		learning_rate = learningRate.Next()
+		weights.Increment(delta, learning_rate)
+		if options.ProximalRegularization {
+			ApplyProximalRegularization(weights, nil, options, learning_rate*regularizationScale)
+		}
+		epochDerivative.Increment(derivative, 1)
+	}
+	canceled := false
 	for {
-		if opt.options.MaxIterations > 0 && step >= opt.options.MaxIterations {
+		if options.MaxIterations > 0 && step >= options.MaxIterations {
+			break
+		}
+		if ctx.Err() != nil {
+			canceled = true
 			break
 		}
 		step++
 
 		// 每次遍历样本前对偏导数向量清零
 		derivative.Clear()
+		epochDerivative.Clear()
+		epochLoss := float64(0)
 
 		// 遍历所有样本，计算偏导数向量并累加
 		iterator.Start()
 		instancesProcessed := 0
 		for !iterator.End() {
 			instance := iterator.GetInstance()
-			derivative_func(weights, instance, instanceDerivative)
+			epochLoss += derivative_func(weights, instance, instanceDerivative)
 			derivative.Increment(instanceDerivative, 1.0/float64(set.NumInstances()))
 			iterator.Next()
 			instancesProcessed++
 
-			if opt.options.GDBatchSize > 0 && instancesProcessed >= opt.options.GDBatchSize {
-				// 添加正则化项
-				derivative.Increment(ComputeRegularization(weights, opt.options),
-					float64(instancesProcessed)/(float64(set.NumInstances())*float64(set.NumInstances())))
-
-				// 计算特征权重的增量
-				delta := opt.GetDeltaX(weights, derivative)
-
-				// 根据学习率更新权重
-				learning_rate = learningRate.ComputeLearningRate(delta)
-				weights.Increment(delta, learning_rate)
+			if options.GDBatchSize > 0 && instancesProcessed >= options.GDBatchSize {
+				updateWeights(instancesProcessed)
 
 				// 重置
 				derivative.Clear()
 				instancesProcessed = 0
+
+				if ctx.Err() != nil {
+					canceled = true
+					break
+				}
 			}
 		}
+		if canceled {
+			// 丢弃未完成的遍历
+			step--
+			weights.DeepCopy(oldWeights)
+			break
+		}
 
 		if instancesProcessed > 0 {
 			// 处理剩余的样本
-			derivative.Increment(ComputeRegularization(weights, opt.options),
-				float64(instancesProcessed)/(float64(set.NumInstances())*float64(set.NumInstances())))
-			delta := opt.GetDeltaX(weights, derivative)
-			learning_rate = learningRate.ComputeLearningRate(delta)
-			weights.Increment(delta, learning_rate)
+			updateWeights(instancesProcessed)
 		}
+		loss = (epochLoss + ComputeRegularizationLoss(weights, options)) / float64(set.NumInstances())
+		learningRate.ObserveLoss(loss)
 
 		weightsDelta.WeightedSum(weights, oldWeights, 1, -1)
 		oldWeights.DeepCopy(weights)
 		weightsNorm := weights.Norm()
 		weightsDeltaNorm := weightsDelta.Norm()
-		log.Printf("#%d |dw|/|w|=%f |w|=%f lr=%1.3g", step, weightsDeltaNorm/weightsNorm, weightsNorm, learning_rate)
+		derivativeNorm := epochDerivative.Norm()
+		log.Printf("#%d f=%1.6g |g|=%1.3g |dw|/|w|=%f |w|=%f lr=%1.3g",
+			step, loss, derivativeNorm, weightsDeltaNorm/weightsNorm, weightsNorm, learning_rate)
 
 		// 判断是否溢出
 		if math.IsNaN(weightsNorm) {
 			log.Fatal("优化失败：不收敛")
 		}
 
+		reportProgress(options, step, loss, weightsDeltaNorm/weightsNorm, learning_rate, weights)
+
+		// 先在验证集上评价这次迭代的权重，收敛时最后的权重也参与比较
+		if es.update(step, weights) {
+			break
+		}
 		// 判断是否收敛
-		if weightsDelta.Norm()/weights.Norm() < opt.options.ConvergingDeltaWeight {
-			convergingSteps++
-			if convergingSteps > opt.options.ConvergingSteps {
-				log.Printf("收敛")
-				break
-			}
+		if checker.converged(weightsDeltaNorm/weightsNorm, loss, derivativeNorm) {
+			break
 		}
+
+		state.step = step
+		saveCheckpoint(options, state)
 	}
+
+	result := OptimizationResult{Iterations: step, Loss: loss, Canceled: canceled}
+	es.finish(weights, &result)
+	return result
 }
@@ -1,7 +1,9 @@
 package optimizer
 
 import (
+	"context"
 	"fmt"
+	"github.com/huichen/mlf/data"
 	"github.com/huichen/mlf/util"
 	"testing"
 )
@@ -30,3 +32,66 @@ func TestGdOptimizer(t *testing.T) {
 	fmt.Println("循环数", k)
 	fmt.Println("x = ", x)
 }
+
+func TestOptimizeWeightsProgressAndCancel(t *testing.T) {
+	for _, name := range []string{"gd", "adam", "lbfgs", "owlqn"} {
+		set := newLogisticTestDataset(false)
+		weights := util.NewMatrix(1, 3)
+
+		// 在第3次迭代的回调中取消优化
+		ctx, cancel := context.WithCancel(context.Background())
+		var iterations []int
+		var reported float64
+		opt := NewOptimizer(OptimizerOptions{
+			OptimizerName:        name,
+			LearningRate:         0.5,
+			GDBatchSize:          2,
+			RegularizationScheme: 1,
+			RegularizationFactor: 0.1,
+			Progress: func(progress Progress) {
+				iterations = append(iterations, progress.Iteration)
+				if progress.Iteration == 3 {
+					reported = progress.Weights.Get(0, 1)
+					cancel()
+				}
+			},
+		})
+		result := opt.OptimizeWeights(ctx, weights, logisticDerivative, set)
+
+		util.Expect(t, "[1 2 3]", iterations)
+		util.Expect(t, "true", result.Canceled)
+		util.Expect(t, "3", result.Iterations)
+		util.ExpectNear(t, reported, weights.Get(0, 1), 1e-12)
+	}
+}
+
+func TestOptimizeWeightsCancelInsideIteration(t *testing.T) {
+	set := newLogisticTestDataset(false)
+	weights := util.NewMatrix(1, 3)
+
+	// 第2次遍历进行到一半时取消，权重恢复为第1次遍历后的值
+	ctx, cancel := context.WithCancel(context.Background())
+	var reported float64
+	calls := 0
+	derivative := func(w *util.Matrix, instance *data.Instance, d *util.Matrix) float64 {
+		calls++
+		if calls == set.NumInstances()+3 {
+			cancel()
+		}
+		return logisticDerivative(w, instance, d)
+	}
+	opt := NewOptimizer(OptimizerOptions{
+		OptimizerName: "gd",
+		LearningRate:  0.5,
+		GDBatchSize:   2,
+		Progress: func(progress Progress) {
+			reported = progress.Weights.Get(0, 1)
+		},
+	})
+	result := opt.OptimizeWeights(ctx, weights, derivative, set)
+
+	util.Expect(t, "true", result.Canceled)
+	util.Expect(t, "1", result.Iterations)
+	util.Expect(t, "true", reported != 0)
+	util.ExpectNear(t, reported, weights.Get(0, 1), 1e-12)
+}
@@ -0,0 +1,128 @@
+package optimizer
+
+import (
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/util"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// 梯度检查的选项，见CheckGradient
+type GradientCheckOptions struct {
+	// 中心差分的步长，值为0时为1e-6
+	Epsilon float64
+
+	// 随机检查多少个元素，值为0时检查所有候选元素
+	NumSamples int
+
+	// 随机选择元素使用的种子
+	Seed int64
+}
+
+// 一个元素的检查结果
+type GradientCheckEntry struct {
+	Label, Index int
+
+	// 偏导数函数给出的值和中心差分的值
+	Analytic, Numeric float64
+
+	// |Analytic - Numeric| / max(|Analytic|, |Numeric|, 1)，偏导数很小时为绝对误差
+	RelativeError float64
+}
+
+// 梯度检查的结果
+type GradientCheckResult struct {
+	// 被检查的元素，按行和索引从小到大排列
+	Entries []GradientCheckEntry
+
+	// 所有元素中最大的相对误差
+	MaxRelativeError float64
+}
+
+// 用中心差分检查单个样本的偏导数
+//
+// 在weights处调用derivative_func得到偏导数，并对选中的元素w_lk计算
+//   (loss(w + eps * e_lk) - loss(w - eps * e_lk)) / (2 * eps)
+// 其中loss为derivative_func的返回值。两者应当非常接近，双精度下正确实现的相对误差
+// 通常小于1e-6，远大于此时偏导数或者损失函数有错误。
+//
+// 候选元素为稠密矩阵的所有元素，稀疏矩阵各行中样本出现的特征和weights中已有的元素。
+// weights不会被修改。
+func CheckGradient(derivative_func ComputeInstanceDerivativeFunc, weights *util.Matrix,
+	instance *data.Instance, options GradientCheckOptions) GradientCheckResult {
+	epsilon := options.Epsilon
+	if epsilon == 0 {
+		epsilon = 1e-6
+	}
+
+	analytic := weights.Populate()
+	derivative_func(weights, instance, analytic)
+
+	// 候选元素
+	type element struct {
+		label, index int
+	}
+	candidates := []element{}
+	for iLabel := 0; iLabel < weights.NumLabels(); iLabel++ {
+		var keys []int
+		if weights.IsSparse() {
+			seen := make(map[int]bool)
+			for _, k := range instance.Features.Keys() {
+				seen[k] = true
+			}
+			for _, k := range weights.GetValues(iLabel).Keys() {
+				seen[k] = true
+			}
+			for k := range seen {
+				keys = append(keys, k)
+			}
+			sort.Ints(keys)
+		} else {
+			keys = weights.GetValues(iLabel).Keys()
+		}
+		for _, k := range keys {
+			candidates = append(candidates, element{iLabel, k})
+		}
+	}
+	if options.NumSamples > 0 && options.NumSamples < len(candidates) {
+		r := rand.New(rand.NewSource(options.Seed))
+		r.Shuffle(len(candidates), func(i, j int) {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		})
+		candidates = candidates[:options.NumSamples]
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].label != candidates[j].label {
+				return candidates[i].label < candidates[j].label
+			}
+			return candidates[i].index < candidates[j].index
+		})
+	}
+
+	// 在副本上做扰动
+	perturbed := weights.Populate()
+	perturbed.DeepCopy(weights)
+	buffer := weights.Populate()
+
+	result := GradientCheckResult{}
+	for _, c := range candidates {
+		w := perturbed.Get(c.label, c.index)
+		perturbed.Set(c.label, c.index, w+epsilon)
+		lossPlus := derivative_func(perturbed, instance, buffer)
+		perturbed.Set(c.label, c.index, w-epsilon)
+		lossMinus := derivative_func(perturbed, instance, buffer)
+		perturbed.Set(c.label, c.index, w)
+
+		entry := GradientCheckEntry{
+			Label:    c.label,
+			Index:    c.index,
+			Analytic: analytic.Get(c.label, c.index),
+			Numeric:  (lossPlus - lossMinus) / (2 * epsilon),
+		}
+		scale := math.Max(math.Max(math.Abs(entry.Analytic), math.Abs(entry.Numeric)), 1)
+		entry.RelativeError = math.Abs(entry.Analytic-entry.Numeric) / scale
+		result.MaxRelativeError = math.Max(result.MaxRelativeError, entry.RelativeError)
+		result.Entries = append(result.Entries, entry)
+	}
+	return result
+}
@@ -0,0 +1,47 @@
+package optimizer
+
+import (
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/util"
+	"testing"
+)
+
+func TestCheckGradient(t *testing.T) {
+	for _, sparse := range []bool{false, true} {
+		set := newLogisticTestDataset(sparse)
+		var weights *util.Matrix
+		if sparse {
+			weights = util.NewSparseMatrix(1)
+		} else {
+			weights = util.NewMatrix(1, 3)
+		}
+		weights.Set(0, 0, 0.3)
+		weights.Set(0, 1, -0.7)
+
+		iterator := set.CreateIterator()
+		for iterator.Start(); !iterator.End(); iterator.Next() {
+			result := CheckGradient(logisticDerivative, weights, iterator.GetInstance(), GradientCheckOptions{})
+			util.Expect(t, "3", len(result.Entries))
+			util.Expect(t, "true", result.MaxRelativeError < 1e-6)
+		}
+		util.Expect(t, "-0.7", weights.Get(0, 1))
+	}
+
+	// 错误的偏导数（漏掉了特征的值）
+	wrongDerivative := func(weights *util.Matrix, instance *data.Instance, instanceDerivative *util.Matrix) float64 {
+		loss := logisticDerivative(weights, instance, instanceDerivative)
+		for _, k := range instance.Features.Keys() {
+			if x := instance.Features.Get(k); x != 0 {
+				instanceDerivative.Set(0, k, instanceDerivative.Get(0, k)/x)
+			}
+		}
+		return loss
+	}
+	instance := new(data.Instance)
+	instance.Features = util.NewVector(3)
+	instance.Features.SetValues([]float64{1, 2, -3})
+	instance.Output = &data.InstanceOutput{Label: 1}
+	result := CheckGradient(wrongDerivative, util.NewMatrix(1, 3), instance, GradientCheckOptions{NumSamples: 2})
+	util.Expect(t, "2", len(result.Entries))
+	util.Expect(t, "true", result.MaxRelativeError > 0.1)
+}
@@ -0,0 +1,199 @@
+package optimizer
+
+import (
+	"context"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/util"
+	"log"
+	"math"
+	"sync/atomic"
+)
+
+// Hogwild并行随机梯度递降优化器
+//
+// 算法见下面的论文
+//   Niu, F., Recht, B., Re, C. and Wright, S. J. (2011). "HOGWILD!: A Lock-Free Approach
+//   to Parallelizing Stochastic Gradient Descent". Advances in Neural Information
+//   Processing Systems 24: 693-701.
+//
+// 数据集被裂分为NumThreads份（第i个样本属于第i % NumThreads份），每份由一个
+// 协程做stochastic GD，所有协程无锁地异步更新共享的权重（见util.ConcurrentMatrix）。
+// 每个样本只更新它出现的特征对应的权重，因此当数据很稀疏、不同样本很少更新同一个
+// 权重时，异步更新几乎不会互相干扰，并行的加速接近线性。
+//
+// 每次迭代为一次遍历，学习率按所有协程处理过的样本总数调度（见OptimizerOptions中的
+// LearningRate、CharacteristicTime和LearningRateSchedule），正则化项只作用于样本中出现的特征。
+// 由于更新的顺序不确定，同样的输入每次优化的结果不完全相同，从检查点继续优化的结果
+// 和不中断时也不完全相同。
+type hogwildOptimizer struct {
+	options OptimizerOptions
+}
+
+// 开辟新的hogwildOptimizer指针
+func NewHogwildOptimizer(options OptimizerOptions) Optimizer {
+	if options.ProximalRegularization {
+		log.Fatal("Hogwild优化器异步更新权重，不支持ProximalRegularization")
+	}
+	opt := new(hogwildOptimizer)
+	opt.options = options
+	return opt
+}
+
+// 清除结构体中保存的数据，以便重复使用结构体
+func (opt *hogwildOptimizer) Clear() {
+}
+
+// 输入x_k和g_k，返回x需要更新的增量 d_k = - g_k
+func (opt *hogwildOptimizer) GetDeltaX(x, g *util.Matrix) *util.Matrix {
+	return g.Opposite()
+}
+
+func (opt *hogwildOptimizer) OptimizeWeights(ctx context.Context,
+	weights *util.Matrix, derivative_func ComputeInstanceDerivativeFunc, set data.Dataset) OptimizationResult {
+	numThreads := numThreads(opt.options)
+	numInstances := float64(set.NumInstances())
+	l1, l2, group := regularizationFactors(opt.options)
+
+	// 各协程处理的数据
+	workerSets := make([]data.Dataset, numThreads)
+	for iWorker := 0; iWorker < numThreads; iWorker++ {
+		workerSets[iWorker] = data.NewSkipDataset(set, []data.SkipBucket{
+			{SkipMode: true, NumInstances: iWorker},
+			{SkipMode: false, NumInstances: 1},
+			{SkipMode: true, NumInstances: numThreads - 1 - iWorker},
+		})
+	}
+
+	// 学习率计算器，step为所有协程处理过的样本总数
+	learningRate := NewLearningRate(opt.options)
+
+	// 优化循环
+	step := 0
+	checker := newConvergenceChecker(opt.options)
+	es := newEarlyStopping(opt.options, weights)
+
+	// 从检查点恢复
+	state := &optimizationState{
+		weights:       weights,
+		optimizer:     opt,
+		learningRate:  learningRate,
+		checker:       checker,
+		earlyStopping: es,
+	}
+	if loadCheckpoint(opt.options, state) {
+		step = state.step
+	}
+
+	oldWeights := weights.Populate()
+	oldWeights.DeepCopy(weights)
+	weightsDelta := weights.Populate()
+
+	log.Printf("开始Hogwild优化，%d个协程", numThreads)
+	loss := float64(0)
+	learning_rate := float64(0)
+	canceled := false
+	for {
+		if opt.options.MaxIterations > 0 && step >= opt.options.MaxIterations {
+			break
+		}
+		if ctx.Err() != nil {
+			canceled = true
+			break
+		}
+		step++
+
+		// 各协程异步地更新共享权重
+		shared := util.NewConcurrentMatrix(weights, 16*numThreads)
+		instancesProcessed := int64(learningRate.step)
+		workerLoss := make([]float64, numThreads)
+		workerCanceled := make([]bool, numThreads)
+		workerChannel := make(chan int, numThreads)
+		for iWorker := 0; iWorker < numThreads; iWorker++ {
+			go func(iw int) {
+				localWeights := weights.Populate()
+				instanceDerivative := weights.Populate()
+				iterator := workerSets[iw].CreateIterator()
+				iterator.Start()
+				for !iterator.End() {
+					if ctx.Err() != nil {
+						workerCanceled[iw] = true
+						break
+					}
+					instance := iterator.GetInstance()
+					shared.CopyFeaturesTo(localWeights, instance.Features)
+					workerLoss[iw] += derivative_func(localWeights, instance, instanceDerivative)
+
+					// 学习率按已经处理的样本总数退火
+					rate := learningRate.rateAtStep(int(atomic.AddInt64(&instancesProcessed, 1) - 1))
+
+					// 和GDBatchSize为1的gd相同：样本偏导数除以N，正则化项除以N^2。
+					// localWeights中只有样本出现的特征是最新的，因此只在这些特征上计算和更新，
+					// 每个样本的开销和它的非零特征数成正比
+					for iLabel := 0; iLabel < instanceDerivative.NumLabels(); iLabel++ {
+						g := instanceDerivative.GetValues(iLabel)
+						for _, k := range instance.Features.Keys() {
+							r := regularizationDerivative(localWeights, iLabel, k, opt.options, l1, l2, group)
+							delta := g.Get(k)/numInstances + r/(numInstances*numInstances)
+							if delta != 0 {
+								shared.Add(iLabel, k, -rate*delta)
+							}
+						}
+					}
+					iterator.Next()
+				}
+				workerChannel <- iw
+			}(iWorker)
+		}
+		for iWorker := 0; iWorker < numThreads; iWorker++ {
+			<-workerChannel
+		}
+		for iWorker := 0; iWorker < numThreads; iWorker++ {
+			canceled = canceled || workerCanceled[iWorker]
+		}
+		if canceled {
+			// 丢弃未完成的遍历
+			step--
+			break
+		}
+		learningRate.step = int(instancesProcessed)
+		learning_rate = learningRate.Current()
+		shared.CopyTo(weights)
+
+		epochLoss := float64(0)
+		for iWorker := 0; iWorker < numThreads; iWorker++ {
+			epochLoss += workerLoss[iWorker]
+		}
+		loss = (epochLoss + ComputeRegularizationLoss(weights, opt.options)) / numInstances
+		learningRate.ObserveLoss(loss)
+
+		weightsDelta.WeightedSum(weights, oldWeights, 1, -1)
+		oldWeights.DeepCopy(weights)
+		weightsNorm := weights.Norm()
+		weightsDeltaNorm := weightsDelta.Norm()
+		log.Printf("#%d f=%1.6g |dw|/|w|=%f |w|=%f lr=%1.3g",
+			step, loss, weightsDeltaNorm/weightsNorm, weightsNorm, learning_rate)
+
+		// 判断是否溢出
+		if math.IsNaN(weightsNorm) {
+			log.Fatal("优化失败：不收敛")
+		}
+
+		reportProgress(opt.options, step, loss, weightsDeltaNorm/weightsNorm, learning_rate, weights)
+
+		// 先在验证集上评价这次迭代的权重，收敛时最后的权重也参与比较
+		if es.update(step, weights) {
+			break
+		}
+		// 判断是否收敛，异步更新时没有一次遍历的偏导数之和，不使用偏导数长度条件
+		if checker.converged(weightsDeltaNorm/weightsNorm, loss, math.Inf(1)) {
+			break
+		}
+
+		state.step = step
+		saveCheckpoint(opt.options, state)
+	}
+
+	result := OptimizationResult{Iterations: step, Loss: loss, Canceled: canceled}
+	es.finish(weights, &result)
+	return result
+}
@@ -0,0 +1,40 @@
+package optimizer
+
+import (
+	"context"
+	"github.com/huichen/mlf/util"
+	"testing"
+)
+
+func TestHogwildOptimizer(t *testing.T) {
+	for _, sparse := range []bool{false, true} {
+		set := newLogisticTestDataset(sparse)
+		newWeights := func() *util.Matrix {
+			if sparse {
+				return util.NewSparseMatrix(1)
+			}
+			return util.NewMatrix(1, 3)
+		}
+		options := OptimizerOptions{
+			OptimizerName:        "lbfgs",
+			RegularizationScheme: 2,
+			RegularizationFactor: 0.1,
+		}
+
+		// 和lbfgs的解比较
+		expected := newWeights()
+		NewOptimizer(options).OptimizeWeights(context.Background(), expected, logisticDerivative, set)
+
+		options.OptimizerName = "hogwild"
+		options.NumThreads = 4
+		options.LearningRate = 8
+		options.CharacteristicTime = 100
+		options.MaxIterations = 500
+		weights := newWeights()
+		result := NewOptimizer(options).OptimizeWeights(context.Background(), weights, logisticDerivative, set)
+		util.Expect(t, "500", result.Iterations)
+		for k := 0; k < 3; k++ {
+			util.ExpectNear(t, expected.Get(0, k), weights.Get(0, k), 0.05)
+		}
+	}
+}
@@ -1,17 +1,11 @@
 package optimizer
 
 import (
-	"flag"
+	"context"
 	"github.com/huichen/mlf/data"
 	"github.com/huichen/mlf/util"
 	"log"
 	"math"
-	"runtime"
-)
-
-var (
-	lbfgs_history_size = flag.Int("lbfgs_history_size", 5, "L-BFGS中存储的历史步长")
-	lbfgs_threads      = flag.Int("lbfgs_threads", 0, "使用多少个协程进行LBFGS收敛，值为0时使用所有CPU")
 )
 
 // limited-memory BFGS优化器
@@ -20,7 +14,8 @@ var (
 //   Nocedal, J. (1980). "Updating Quasi-Newton Matrices with Limited Storage".
 //   Mathematics of Computation 35 (151): 773–782. doi:10.1090/S0025-5718-1980-0572855-7
 //
-// 这种方法最多保存最近m步的中间结果用以计算海森矩阵的近似值。
+// 这种方法最多保存最近m步的中间结果用以计算海森矩阵的近似值。每一步的步长通过满足
+// 强Wolfe条件的线搜索确定（见lineSearchStrongWolfe），学习率作为线搜索的初始试探步长。
 //
 // 请用NewOptimizer函数建立新的优化器。
 //
@@ -32,6 +27,9 @@ type lbfgsOptimizer struct {
 	// 初始化参数
 	options OptimizerOptions
 
+	// 保存的历史步数m，见OptimizerOptions.LbfgsHistorySize
+	historySize int
+
 	// 当前的步数，从0开始
 	// 如果需要重新优化，请调用Clear函数
 	k int
@@ -54,15 +52,22 @@ type lbfgsOptimizer struct {
 	// 特征向量维度
 	labels int
 
+	// 不为nil时用它代替derivative_func和set计算目标函数，见DatasetLossOptimizer
+	datasetLoss DatasetLoss
+
 	// 临时变量
 	q, z        *util.Matrix
 	alpha, beta *util.Vector
 }
 
+// 更新历史时要求 y . s > lbfgsCurvatureEpsilon * |s| * |y|
+const lbfgsCurvatureEpsilon = 1e-10
+
 // 开辟新的lbfgsOptimizer指针
 func NewLbfgsOptimizer(options OptimizerOptions) Optimizer {
 	opt := new(lbfgsOptimizer)
 	opt.options = options
+	opt.historySize = lbfgsHistorySize(options)
 	opt.k = 0
 	return opt
 }
@@ -72,18 +77,18 @@ func NewLbfgsOptimizer(options OptimizerOptions) Optimizer {
 func (opt *lbfgsOptimizer) initStruct(labels, features int, isSparse bool) {
 	opt.labels = labels
 
-	opt.x = make([]*util.Matrix, *lbfgs_history_size)
-	opt.g = make([]*util.Matrix, *lbfgs_history_size)
-	opt.s = make([]*util.Matrix, *lbfgs_history_size)
-	opt.y = make([]*util.Matrix, *lbfgs_history_size)
+	opt.x = make([]*util.Matrix, opt.historySize)
+	opt.g = make([]*util.Matrix, opt.historySize)
+	opt.s = make([]*util.Matrix, opt.historySize)
+	opt.y = make([]*util.Matrix, opt.historySize)
 
-	opt.ro = util.NewVector(*lbfgs_history_size)
-	opt.alpha = util.NewVector(*lbfgs_history_size)
-	opt.beta = util.NewVector(*lbfgs_history_size)
+	opt.ro = util.NewVector(opt.historySize)
+	opt.alpha = util.NewVector(opt.historySize)
+	opt.beta = util.NewVector(opt.historySize)
 	if !isSparse {
 		opt.q = util.NewMatrix(labels, features)
 		opt.z = util.NewMatrix(labels, features)
-		for i := 0; i < *lbfgs_history_size; i++ {
+		for i := 0; i < opt.historySize; i++ {
 			opt.x[i] = util.NewMatrix(labels, features)
 			opt.g[i] = util.NewMatrix(labels, features)
 			opt.s[i] = util.NewMatrix(labels, features)
@@ -92,7 +97,7 @@ func (opt *lbfgsOptimizer) initStruct(labels, features int, isSparse bool) {
 	} else {
 		opt.q = util.NewSparseMatrix(labels)
 		opt.z = util.NewSparseMatrix(labels)
-		for i := 0; i < *lbfgs_history_size; i++ {
+		for i := 0; i < opt.historySize; i++ {
 			opt.x[i] = util.NewSparseMatrix(labels)
 			opt.g[i] = util.NewSparseMatrix(labels)
 			opt.s[i] = util.NewSparseMatrix(labels)
@@ -108,6 +113,17 @@ func (opt *lbfgsOptimizer) Clear() {
 
 // 输入x_k和g_k，返回x需要更新的增量 d_k = - H_k * g_k
 func (opt *lbfgsOptimizer) GetDeltaX(x, g *util.Matrix) *util.Matrix {
+	opt.updateHistory(x, g)
+
+	// 当为第0步时，使用简单的gradient descent
+	if opt.k == 1 {
+		return g.Opposite()
+	}
+	return opt.twoLoopRecursion(g).Opposite()
+}
+
+// 记录x_k和g_k，更新s_(k-1)、y_(k-1)和ro_(k-1)，然后将k加一
+func (opt *lbfgsOptimizer) updateHistory(x, g *util.Matrix) {
 	if x.NumLabels() != g.NumLabels() {
 		log.Fatal("x和g的维度不一致")
 	}
@@ -121,7 +137,7 @@ func (opt *lbfgsOptimizer) GetDeltaX(x, g *util.Matrix) *util.Matrix {
 		}
 	}
 
-	currIndex := util.Mod(opt.k, *lbfgs_history_size)
+	currIndex := util.Mod(opt.k, opt.historySize)
 
 	// 更新x_k
 	opt.x[currIndex].DeepCopy(x)
@@ -129,33 +145,43 @@ func (opt *lbfgsOptimizer) GetDeltaX(x, g *util.Matrix) *util.Matrix {
 	// 更新g_k
 	opt.g[currIndex].DeepCopy(g)
 
-	// 当为第0步时，使用简单的gradient descent
-	if opt.k == 0 {
-		opt.k++
-		return g.Opposite()
-	}
+	if opt.k > 0 {
+		prevIndex := util.Mod(opt.k-1, opt.historySize)
 
-	prevIndex := util.Mod(opt.k-1, *lbfgs_history_size)
+		// 更新s_(k-1)
+		opt.s[prevIndex].WeightedSum(opt.x[currIndex], opt.x[prevIndex], 1, -1)
 
-	// 更新s_(k-1)
-	opt.s[prevIndex].WeightedSum(opt.x[currIndex], opt.x[prevIndex], 1, -1)
+		// 更新y_(k-1)
+		opt.y[prevIndex].WeightedSum(opt.g[currIndex], opt.g[prevIndex], 1, -1)
 
-	// 更新y_(k-1)
-	opt.y[prevIndex].WeightedSum(opt.g[currIndex], opt.g[prevIndex], 1, -1)
+		// 更新ro_(k-1)。y . s不够大时（owlqn的回溯线搜索不保证曲率条件）跳过这一对s和y：
+		// ro为0时它们在twoLoopRecursion中不起作用，否则会得到非下降方向
+		ys := util.MatrixDotProduct(opt.y[prevIndex], opt.s[prevIndex])
+		if ys > lbfgsCurvatureEpsilon*opt.s[prevIndex].Norm()*opt.y[prevIndex].Norm() {
+			opt.ro.Set(prevIndex, 1.0/ys)
+		} else {
+			opt.ro.Set(prevIndex, 0)
+		}
+	}
 
-	// 更新ro_(k-1)
-	opt.ro.Set(prevIndex, 1.0/util.MatrixDotProduct(opt.y[prevIndex], opt.s[prevIndex]))
+	// 更新k
+	opt.k++
+}
 
-	// 计算两个循环的下限
-	lowerBound := opt.k - *lbfgs_history_size
+// 用保存的历史s和y通过两个循环计算 H_k * v，必须在updateHistory之后调用
+// 返回的矩阵是优化器的临时变量，下次调用时会被覆盖
+func (opt *lbfgsOptimizer) twoLoopRecursion(v *util.Matrix) *util.Matrix {
+	// 可用的历史为第lowerBound到第upperBound步
+	upperBound := opt.k - 2
+	lowerBound := opt.k - 1 - opt.historySize
 	if lowerBound < 0 {
 		lowerBound = 0
 	}
 
 	// 第一个循环
-	opt.q.DeepCopy(g)
-	for i := opt.k - 1; i >= lowerBound; i-- {
-		currIndex := util.Mod(i, *lbfgs_history_size)
+	opt.q.DeepCopy(v)
+	for i := upperBound; i >= lowerBound; i-- {
+		currIndex := util.Mod(i, opt.historySize)
 		opt.alpha.Set(currIndex,
 			opt.ro.Get(currIndex)*util.MatrixDotProduct(opt.s[currIndex], opt.q))
 		opt.q.Increment(opt.y[currIndex], -opt.alpha.Get(currIndex))
@@ -163,121 +189,162 @@ func (opt *lbfgsOptimizer) GetDeltaX(x, g *util.Matrix) *util.Matrix {
 
 	// 第二个循环
 	opt.z.DeepCopy(opt.q)
-	for i := lowerBound; i <= opt.k-1; i++ {
-		currIndex := util.Mod(i, *lbfgs_history_size)
+	for i := lowerBound; i <= upperBound; i++ {
+		currIndex := util.Mod(i, opt.historySize)
 		opt.beta.Set(currIndex,
 			opt.ro.Get(currIndex)*util.MatrixDotProduct(opt.y[currIndex], opt.z))
 		opt.z.Increment(opt.s[currIndex],
 			opt.alpha.Get(currIndex)-opt.beta.Get(currIndex))
 	}
 
-	// 更新k
-	opt.k++
+	return opt.z
+}
 
-	return opt.z.Opposite()
+// 设置计算损失之和的DatasetLoss，见DatasetLossOptimizer
+func (opt *lbfgsOptimizer) SetDatasetLoss(loss DatasetLoss) {
+	opt.datasetLoss = loss
 }
 
-func (opt *lbfgsOptimizer) OptimizeWeights(
-	weights *util.Matrix, derivative_func ComputeInstanceDerivativeFunc, set data.Dataset) {
+// 创建目标函数，设置了DatasetLoss时用它计算损失
+func (opt *lbfgsOptimizer) newObjective(weights *util.Matrix, derivative_func ComputeInstanceDerivativeFunc,
+	set data.Dataset, options OptimizerOptions) *datasetObjective {
+	if opt.datasetLoss != nil {
+		return newDatasetLossObjective(opt.datasetLoss, options)
+	}
+	return newDatasetObjective(weights, derivative_func, set, options, numThreads(opt.options))
+}
+
+func (opt *lbfgsOptimizer) OptimizeWeights(ctx context.Context,
+	weights *util.Matrix, derivative_func ComputeInstanceDerivativeFunc, set data.Dataset) OptimizationResult {
 
-	// 学习率计算器
+	// 学习率计算器，学习率作为线搜索的初始试探步长
 	learningRate := NewLearningRate(opt.options)
 
+	// 目标函数
+	objective := opt.newObjective(weights, derivative_func, set, opt.options)
+
 	// 偏导数向量
 	derivative := weights.Populate()
 
+	// 线搜索中的试探点
+	trialWeights := weights.Populate()
+	trialDerivative := weights.Populate()
+
 	// 优化循环
 	step := 0
-	convergingSteps := 0
-	oldWeights := weights.Populate()
-	weightsDelta := weights.Populate()
-
-	// 为各个工作协程开辟临时资源
-	numLbfgsThreads := *lbfgs_threads
-	if numLbfgsThreads == 0 {
-		numLbfgsThreads = runtime.NumCPU()
+	checker := newConvergenceChecker(opt.options)
+	es := newEarlyStopping(opt.options, weights)
+
+	// 从检查点恢复
+	state := &optimizationState{
+		weights:       weights,
+		optimizer:     opt,
+		learningRate:  learningRate,
+		checker:       checker,
+		earlyStopping: es,
 	}
-	workerSet := make([]data.Dataset, numLbfgsThreads)
-	workerDerivative := make([]*util.Matrix, numLbfgsThreads)
-	workerInstanceDerivative := make([]*util.Matrix, numLbfgsThreads)
-	for iWorker := 0; iWorker < numLbfgsThreads; iWorker++ {
-		workerBuckets := []data.SkipBucket{
-			{true, iWorker},
-			{false, 1},
-			{true, numLbfgsThreads - 1 - iWorker},
-		}
-		workerSet[iWorker] = data.NewSkipDataset(set, workerBuckets)
-		workerDerivative[iWorker] = weights.Populate()
-		workerInstanceDerivative[iWorker] = weights.Populate()
+	if loadCheckpoint(opt.options, state) {
+		step = state.step
 	}
 
+	oldWeights := weights.Populate()
+	oldWeights.DeepCopy(weights)
+	weightsDelta := weights.Populate()
+
 	log.Print("开始L-BFGS优化")
+	loss := objective.Evaluate(weights, derivative)
+	canceled := false
 	for {
 		if opt.options.MaxIterations > 0 && step >= opt.options.MaxIterations {
 			break
 		}
+		if ctx.Err() != nil {
+			canceled = true
+			break
+		}
 		step++
 
-		// 开始工作协程
-		workerChannel := make(chan int, numLbfgsThreads)
-		for iWorker := 0; iWorker < numLbfgsThreads; iWorker++ {
-			go func(iw int) {
-				workerDerivative[iw].Clear()
-				iterator := workerSet[iw].CreateIterator()
-				iterator.Start()
-				for !iterator.End() {
-					instance := iterator.GetInstance()
-					derivative_func(
-						weights, instance, workerInstanceDerivative[iw])
-					//					log.Print(workerInstanceDerivative[iw].GetValues(0))
-					workerDerivative[iw].Increment(
-						workerInstanceDerivative[iw], float64(1)/float64(set.NumInstances()))
-					iterator.Next()
-				}
-				workerChannel <- iw
-			}(iWorker)
+		// 计算搜索方向，如果不是下降方向则丢弃历史重新开始
+		delta := opt.GetDeltaX(weights, derivative)
+		dphi0 := util.MatrixDotProduct(derivative, delta)
+		if !(dphi0 < 0) && opt.k > 1 {
+			opt.Clear()
+			delta = opt.GetDeltaX(weights, derivative)
+			dphi0 = util.MatrixDotProduct(derivative, delta)
+		}
+		if !(dphi0 < 0) {
+			log.Printf("偏导数为零，停止优化")
+			// 权重没有更新，这次迭代不计数
+			step--
+			break
 		}
 
-		derivative.Clear()
-
-		// 等待工作协程结束
-		for iWorker := 0; iWorker < numLbfgsThreads; iWorker++ {
-			<-workerChannel
+		// 初始试探步长，第一步（梯度递降）时根据方向的长度缩放
+		initialStep := learningRate.ComputeLearningRate(delta)
+		if initialStep == 0 {
+			initialStep = 1
 		}
-		for iWorker := 0; iWorker < numLbfgsThreads; iWorker++ {
-			derivative.Increment(workerDerivative[iWorker], 1)
+		if opt.k == 1 {
+			initialStep = math.Min(initialStep, initialStep/delta.Norm())
 		}
 
-		// 添加正则化项
-		derivative.Increment(ComputeRegularization(weights, opt.options), 1.0/float64(set.NumInstances()))
-
-		// 计算特征权重的增量
-		delta := opt.GetDeltaX(weights, derivative)
+		// 沿delta方向做线搜索
+		lastTrialStep := math.NaN()
+		phi := func(alpha float64) (float64, float64) {
+			lastTrialStep = alpha
+			trialWeights.WeightedSum(weights, delta, 1, alpha)
+			f := objective.Evaluate(trialWeights, trialDerivative)
+			return f, util.MatrixDotProduct(trialDerivative, delta)
+		}
+		learning_rate, newLoss, ok := lineSearchStrongWolfe(phi, loss, dphi0, initialStep)
+		if learning_rate == 0 {
+			log.Printf("线搜索失败，停止优化")
+			// 权重没有更新，这次迭代不计数
+			step--
+			break
+		}
+		if learning_rate != lastTrialStep {
+			phi(learning_rate)
+		}
+		if !ok {
+			// 步长不满足曲率条件，历史信息可能破坏海森矩阵近似的正定性
+			opt.Clear()
+		}
 
-		// 根据学习率更新权重
-		learning_rate := learningRate.ComputeLearningRate(delta)
-		weights.Increment(delta, learning_rate)
+		// 更新权重
+		weights.DeepCopy(trialWeights)
+		derivative.DeepCopy(trialDerivative)
+		loss = newLoss
 
 		weightsDelta.WeightedSum(weights, oldWeights, 1, -1)
 		oldWeights.DeepCopy(weights)
 		weightsNorm := weights.Norm()
 		weightsDeltaNorm := weightsDelta.Norm()
-		log.Printf("#%d |dw|/|w|=%f |w|=%f lr=%1.3g", step, weightsDeltaNorm/weightsNorm, weightsNorm, learning_rate)
+		derivativeNorm := derivative.Norm()
+		log.Printf("#%d f=%1.6g |g|=%1.3g |dw|/|w|=%f |w|=%f lr=%1.3g",
+			step, loss, derivativeNorm, weightsDeltaNorm/weightsNorm, weightsNorm, learning_rate)
 
 		// 判断是否溢出
 		if math.IsNaN(weightsNorm) {
 			log.Fatal("优化失败：不收敛")
 		}
 
+		reportProgress(opt.options, step, loss, weightsDeltaNorm/weightsNorm, learning_rate, weights)
+
+		// 先在验证集上评价这次迭代的权重，收敛时最后的权重也参与比较
+		if es.update(step, weights) {
+			break
+		}
 		// 判断是否收敛
-		if weightsDeltaNorm/weightsNorm < opt.options.ConvergingDeltaWeight {
-			convergingSteps++
-			if convergingSteps > opt.options.ConvergingSteps {
-				log.Printf("收敛")
-				break
-			}
-		} else {
-			convergingSteps = 0
+		if checker.converged(weightsDeltaNorm/weightsNorm, loss, derivativeNorm) {
+			break
 		}
+
+		state.step = step
+		saveCheckpoint(opt.options, state)
 	}
+
+	result := OptimizationResult{Iterations: step, Loss: loss, Canceled: canceled}
+	es.finish(weights, &result)
+	return result
 }
@@ -1,8 +1,10 @@
 package optimizer
 
 import (
+	"context"
 	"fmt"
 	"github.com/huichen/mlf/util"
+	"math"
 	"testing"
 )
 
@@ -28,3 +30,92 @@ func TestLbfgsOptimizer(t *testing.T) {
 	fmt.Println("循环数", k)
 	fmt.Println("x = ", x)
 }
+
+func TestLbfgsSkipsNegativeCurvature(t *testing.T) {
+	opt := NewLbfgsOptimizer(OptimizerOptions{}).(*lbfgsOptimizer)
+	x := util.NewMatrix(1, 2)
+	g := util.NewMatrix(1, 2)
+
+	// 第一步 y . s > 0，第二步梯度沿s变小，y . s < 0
+	for _, step := range [][]float64{{0, 0, 1, 1}, {1, 0, 2, 1}, {2, 0, 1, 1}} {
+		x.GetValues(0).SetValues(step[:2])
+		g.GetValues(0).SetValues(step[2:])
+		opt.GetDeltaX(x, g)
+	}
+	util.ExpectNear(t, 1, opt.ro.Get(0), 1e-12)
+	util.Expect(t, "0", opt.ro.Get(1))
+
+	// 方向仍然是下降方向
+	delta := opt.GetDeltaX(x, g)
+	util.Expect(t, "true", util.MatrixDotProduct(delta, g) < 0)
+}
+
+func TestLineSearchStrongWolfe(t *testing.T) {
+	// phi(alpha) = (alpha - 3)^4 - alpha，极小值点在 alpha = 3 + 4^(-1/3)
+	phi := func(alpha float64) (float64, float64) {
+		d := alpha - 3
+		return d*d*d*d - alpha, 4*d*d*d - 1
+	}
+	phi0, dphi0 := phi(0)
+
+	for _, alpha1 := range []float64{0.01, 1, 100} {
+		alpha, phiAlpha, ok := lineSearchStrongWolfe(phi, phi0, dphi0, alpha1)
+		_, dphiAlpha := phi(alpha)
+		util.Expect(t, "true", ok)
+		util.Expect(t, "true", phiAlpha <= phi0+wolfeC1*alpha*dphi0)
+		util.Expect(t, "true", math.Abs(dphiAlpha) <= -wolfeC2*dphi0)
+	}
+}
+
+func TestConvergenceChecker(t *testing.T) {
+	checker := newConvergenceChecker(OptimizerOptions{
+		ConvergingRelativeLoss: 1e-3,
+		ConvergingGradientNorm: 1e-6,
+		ConvergingSteps:        1,
+	})
+
+	util.Expect(t, "false", checker.converged(1, 10, 1))
+	util.Expect(t, "false", checker.converged(1, 5, 1))
+	util.Expect(t, "false", checker.converged(1, 4.9999, 1))
+
+	// 不满足条件时重新计数
+	util.Expect(t, "false", checker.converged(1, 4, 1))
+	util.Expect(t, "false", checker.converged(1, 3.9999, 1))
+	util.Expect(t, "true", checker.converged(1, 3.9998, 1))
+
+	// 偏导数长度，ConvergingSteps小于0时第一次满足即收敛
+	checker = newConvergenceChecker(OptimizerOptions{ConvergingGradientNorm: 1e-6, ConvergingSteps: -1})
+	util.Expect(t, "false", checker.converged(1, 1, 1e-5))
+	util.Expect(t, "true", checker.converged(1, 1, 1e-7))
+
+	// ConvergingSteps为0时需要连续满足超过3次
+	checker = newConvergenceChecker(OptimizerOptions{ConvergingGradientNorm: 1e-6})
+	for i := 0; i < 3; i++ {
+		util.Expect(t, "false", checker.converged(1, 1, 1e-7))
+	}
+	util.Expect(t, "true", checker.converged(1, 1, 1e-7))
+}
+
+func TestLbfgsOptionsPerOptimizer(t *testing.T) {
+	// 不同设置的优化器可以在同一个进程中同时使用
+	util.Expect(t, "5", NewOptimizer(OptimizerOptions{OptimizerName: "lbfgs"}).(*lbfgsOptimizer).historySize)
+	util.Expect(t, "2", NewOptimizer(OptimizerOptions{OptimizerName: "owlqn", LbfgsHistorySize: 2}).(*owlqnOptimizer).historySize)
+
+	set := newLogisticTestDataset(false)
+	options := OptimizerOptions{
+		OptimizerName:          "lbfgs",
+		RegularizationScheme:   2,
+		RegularizationFactor:   0.1,
+		ConvergingGradientNorm: 1e-8,
+	}
+	expected := util.NewMatrix(1, 3)
+	NewOptimizer(options).OptimizeWeights(context.Background(), expected, logisticDerivative, set)
+
+	options.LbfgsHistorySize = 2
+	options.NumThreads = 3
+	weights := util.NewMatrix(1, 3)
+	NewOptimizer(options).OptimizeWeights(context.Background(), weights, logisticDerivative, set)
+	for k := 0; k < 3; k++ {
+		util.ExpectNear(t, expected.Get(0, k), weights.Get(0, k), 1e-6)
+	}
+}
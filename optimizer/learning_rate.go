@@ -2,13 +2,65 @@ package optimizer
 
 import (
 	"github.com/huichen/mlf/util"
+	"log"
+	"math"
 )
 
+// 学习率调度的选项，见OptimizerOptions.LearningRateSchedule
+//
+// 学习率随更新次数t（从0开始）变化，t的含义由使用者决定：gd和自适应优化器为批次数
+// （full-batch时等于迭代数），hogwild为处理过的样本数，在线sgd和RBM为批次数。
+// 下面的公式中lr为LearningRate：
+//   ""：          CharacteristicTime为0时为常数lr，否则为 lr / (1 + t / CharacteristicTime)
+//   "step"：      lr * Decay^floor(t / StepSize)
+//   "exponential"：lr * Decay^(t / StepSize)
+//   "cosine"：    余弦退火，第一个周期为StepSize步，之后每个周期的长度乘以RestartMultiplier，
+//                 每个周期内从lr按余弦曲线降到MinLearningRate，然后重启（SGDR）
+//   "plateau"：   lr，每当目标函数连续Patience次迭代没有相对下降Threshold时乘以Decay，
+//                 需要使用者在每次迭代后调用ObserveLoss（在线sgd训练器每NumInstancesForEvaluation
+//                 个样本调用一次）
+// WarmupSteps大于0时，前WarmupSteps步学习率从lr / WarmupSteps线性增加到调度的初始值，
+// 之后调度从t = 0开始。除了预热阶段，学习率不小于MinLearningRate。
+type LearningRateSchedule struct {
+	// 调度方法，见上
+	Name string
+
+	// step和exponential每StepSize步衰减Decay倍，exponential的值为0时为1；
+	// cosine的第一个周期长度，必须大于0
+	StepSize int
+
+	// step、exponential和plateau的衰减系数，值为0时为0.1
+	Decay float64
+
+	// 学习率的下限，cosine为每个周期的最小值
+	MinLearningRate float64
+
+	// cosine每次重启后周期长度的倍数，值为0时为1（周期不变）
+	RestartMultiplier float64
+
+	// 线性预热的步数，值为0时不预热
+	WarmupSteps int
+
+	// plateau的耐心步数和相对下降阈值，值为0时分别为10和1e-4
+	Patience  int
+	Threshold float64
+}
+
+// 学习率计算器
+//
+// 请用NewLearningRate创建。Next返回当前的学习率并将更新次数加一，plateau调度还需要在
+// 每次迭代后调用ObserveLoss。状态（更新次数和plateau的状态）可以用EncodeBinary保存到
+// 检查点中。
 type LearningRate struct {
 	rate               float64
 	step               int
 	characteristicTime float64
-	oldDeltaX          *util.Matrix
+	schedule           LearningRateSchedule
+
+	// plateau的状态：学习率的缩放系数、最小的目标函数值以及没有下降的连续迭代数
+	scale    float64
+	bestLoss float64
+	badSteps int
 }
 
 func NewLearningRate(options OptimizerOptions) (lr *LearningRate) {
@@ -16,14 +68,128 @@ func NewLearningRate(options OptimizerOptions) (lr *LearningRate) {
 	lr.rate = options.LearningRate
 	lr.step = 0
 	lr.characteristicTime = options.CharacteristicTime
+	lr.schedule = options.LearningRateSchedule
+	lr.scale = 1
+	lr.bestLoss = math.Inf(1)
+
+	schedule := &lr.schedule
+	switch schedule.Name {
+	case "", "plateau":
+	case "step", "cosine":
+		if schedule.StepSize <= 0 {
+			log.Fatal(schedule.Name, "学习率调度需要指定正的StepSize")
+		}
+		if schedule.Name == "cosine" && schedule.RestartMultiplier != 0 && schedule.RestartMultiplier < 1 {
+			log.Fatal("cosine学习率调度的RestartMultiplier不能小于1")
+		}
+	case "exponential":
+		if schedule.StepSize <= 0 {
+			schedule.StepSize = 1
+		}
+	default:
+		log.Fatal("不支持的学习率调度", schedule.Name)
+	}
+	if schedule.Decay == 0 {
+		schedule.Decay = 0.1
+	}
+	if schedule.RestartMultiplier == 0 {
+		schedule.RestartMultiplier = 1
+	}
+	if schedule.Patience == 0 {
+		schedule.Patience = 10
+	}
+	if schedule.Threshold == 0 {
+		schedule.Threshold = 1e-4
+	}
 	return
 }
 
-func (lr *LearningRate) ComputeLearningRate(newDeltaX *util.Matrix) (r float64) {
+// 返回当前的学习率，并将更新次数加一
+func (lr *LearningRate) Next() float64 {
+	r := lr.rateAtStep(lr.step)
+	lr.step++
+	return r
+}
+
+// 和Next相同，newDeltaX没有被使用，保留这个函数是为了兼容以前的代码
+func (lr *LearningRate) ComputeLearningRate(newDeltaX *util.Matrix) float64 {
+	return lr.Next()
+}
+
+// 下一次Next返回的学习率
+func (lr *LearningRate) Current() float64 {
+	return lr.rateAtStep(lr.step)
+}
+
+// 汇报一次迭代后的目标函数值，只对plateau调度起作用
+func (lr *LearningRate) ObserveLoss(loss float64) {
+	if lr.schedule.Name != "plateau" {
+		return
+	}
+	if loss < lr.bestLoss-lr.schedule.Threshold*math.Abs(lr.bestLoss) || math.IsInf(lr.bestLoss, 1) {
+		lr.bestLoss = loss
+		lr.badSteps = 0
+		return
+	}
+	lr.badSteps++
+	if lr.badSteps >= lr.schedule.Patience {
+		lr.scale *= lr.schedule.Decay
+		lr.badSteps = 0
+		log.Printf("目标函数连续%d次迭代没有下降，学习率降为%1.3g",
+			lr.schedule.Patience, lr.rateAtStep(lr.step))
+	}
+}
+
+// 第step次更新（从0开始）的学习率，不改变lr的状态，可以在多个协程中同时调用
+func (lr *LearningRate) rateAtStep(step int) float64 {
+	schedule := &lr.schedule
+	if step < schedule.WarmupSteps {
+		return lr.scheduledRate(0) * float64(step+1) / float64(schedule.WarmupSteps)
+	}
+	return math.Max(lr.scheduledRate(step-schedule.WarmupSteps), schedule.MinLearningRate)
+}
+
+// 不考虑预热和下限时第t步的学习率
+func (lr *LearningRate) scheduledRate(t int) float64 {
+	schedule := &lr.schedule
+	switch schedule.Name {
+	case "step":
+		return lr.rate * math.Pow(schedule.Decay, float64(t/schedule.StepSize))
+	case "exponential":
+		return lr.rate * math.Pow(schedule.Decay, float64(t)/float64(schedule.StepSize))
+	case "cosine":
+		// 找到t所在的周期
+		period := float64(schedule.StepSize)
+		position := float64(t)
+		if schedule.RestartMultiplier == 1 {
+			position = math.Mod(position, period)
+		}
+		for position >= period {
+			position -= period
+			period *= schedule.RestartMultiplier
+		}
+		return schedule.MinLearningRate +
+			(lr.rate-schedule.MinLearningRate)*(1+math.Cos(math.Pi*position/period))/2
+	case "plateau":
+		return lr.rate * lr.scale
+	}
 	if lr.characteristicTime == 0 {
 		return lr.rate
 	}
-	r = lr.rate / (float64(1) + float64(lr.step)/float64(lr.characteristicTime))
-	lr.step++
-	return
+	return lr.rate / (float64(1) + float64(t)/float64(lr.characteristicTime))
+}
+
+// 保存更新次数和plateau的状态
+func (lr *LearningRate) EncodeBinary(e *util.BinaryEncoder) {
+	e.WriteInt(lr.step)
+	e.WriteFloat64(lr.scale)
+	e.WriteFloat64(lr.bestLoss)
+	e.WriteInt(lr.badSteps)
+}
+
+func (lr *LearningRate) DecodeBinary(d *util.BinaryDecoder) {
+	lr.step = d.ReadInt()
+	lr.scale = d.ReadFloat64()
+	lr.bestLoss = d.ReadFloat64()
+	lr.badSteps = d.ReadInt()
 }
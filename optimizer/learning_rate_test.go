@@ -0,0 +1,68 @@
+package optimizer
+
+import (
+	"bytes"
+	"github.com/huichen/mlf/util"
+	"testing"
+)
+
+func TestLearningRateSchedules(t *testing.T) {
+	rates := func(schedule LearningRateSchedule, n int) []float64 {
+		lr := NewLearningRate(OptimizerOptions{LearningRate: 1, LearningRateSchedule: schedule})
+		result := make([]float64, n)
+		for i := range result {
+			result[i] = lr.Next()
+		}
+		return result
+	}
+
+	// 原有的常数学习率和 lr / (1 + t / T)
+	util.Expect(t, "[1 1 1]", rates(LearningRateSchedule{}, 3))
+	lr := NewLearningRate(OptimizerOptions{LearningRate: 1, CharacteristicTime: 1})
+	util.Expect(t, "1", lr.Next())
+	util.Expect(t, "0.5", lr.Next())
+
+	util.Expect(t, "[1 1 0.5 0.5 0.25]",
+		rates(LearningRateSchedule{Name: "step", StepSize: 2, Decay: 0.5}, 5))
+	exponential := rates(LearningRateSchedule{Name: "exponential", StepSize: 2, Decay: 0.25}, 3)
+	util.ExpectNear(t, 0.5, exponential[1], 1e-12)
+	util.ExpectNear(t, 0.25, exponential[2], 1e-12)
+
+	// 余弦退火：周期为2、4、8...
+	cosine := rates(LearningRateSchedule{Name: "cosine", StepSize: 2, RestartMultiplier: 2, MinLearningRate: 0.2}, 7)
+	util.Expect(t, "1", cosine[0])
+	util.ExpectNear(t, 0.6, cosine[1], 1e-12)
+	util.Expect(t, "1", cosine[2])
+	util.ExpectNear(t, 0.6, cosine[4], 1e-12)
+	util.Expect(t, "1", cosine[6])
+
+	// 预热后从调度的起点开始，下限不影响预热阶段
+	util.Expect(t, "[0.25 0.5 0.75 1 1 1 0.5]",
+		rates(LearningRateSchedule{Name: "step", StepSize: 2, Decay: 0.25, WarmupSteps: 4, MinLearningRate: 0.5}, 7))
+}
+
+func TestLearningRatePlateau(t *testing.T) {
+	lr := NewLearningRate(OptimizerOptions{
+		LearningRate:         1,
+		LearningRateSchedule: LearningRateSchedule{Name: "plateau", Patience: 2, Decay: 0.5},
+	})
+	for _, loss := range []float64{3, 2, 2, 1.9999999} {
+		lr.ObserveLoss(loss)
+	}
+	util.Expect(t, "0.5", lr.Current())
+	lr.ObserveLoss(1)
+	lr.ObserveLoss(1)
+	util.Expect(t, "0.5", lr.Current())
+	lr.ObserveLoss(1)
+	util.Expect(t, "0.25", lr.Current())
+
+	// 状态可以保存和恢复
+	var buf bytes.Buffer
+	lr.EncodeBinary(util.NewBinaryEncoder(&buf))
+	restored := NewLearningRate(OptimizerOptions{
+		LearningRate:         1,
+		LearningRateSchedule: LearningRateSchedule{Name: "plateau", Patience: 2, Decay: 0.5},
+	})
+	restored.DecodeBinary(util.NewBinaryDecoder(&buf))
+	util.Expect(t, "0.25", restored.Current())
+}
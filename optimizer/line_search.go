@@ -0,0 +1,110 @@
+package optimizer
+
+import (
+	"math"
+)
+
+const (
+	// 充分下降条件（Armijo条件）的系数
+	wolfeC1 = 1e-4
+
+	// 曲率条件的系数，拟牛顿法通常取0.9
+	wolfeC2 = 0.9
+
+	// 线搜索最多计算多少次目标函数
+	maxLineSearchSteps = 20
+)
+
+// 一维函数 phi(alpha) = f(x + alpha * d)，返回函数值和导数 phi'(alpha) = g(x + alpha * d)·d
+type lineSearchFunc func(alpha float64) (phi, dphi float64)
+
+// 满足强Wolfe条件的线搜索
+//
+// 寻找步长alpha使得
+//   phi(alpha) <= phi(0) + c1 * alpha * phi'(0)     （充分下降条件）
+//   |phi'(alpha)| <= c2 * |phi'(0)|                 （曲率条件）
+// 算法见 Nocedal, J. & Wright, S. (2006). "Numerical Optimization" (2nd ed.),
+// Algorithm 3.5和3.6，区间内的试探步长使用带保护的三次插值。
+//
+// phi0和dphi0为phi(0)和phi'(0)，要求dphi0 < 0（d为下降方向），alpha1为初始试探步长。
+// 返回找到的步长和该步长处的函数值。当ok为false时没有找到满足强Wolfe条件的步长，
+// 此时如果alpha > 0则该步长仍然满足充分下降条件；alpha为0表示搜索失败。
+func lineSearchStrongWolfe(phi lineSearchFunc, phi0, dphi0, alpha1 float64) (alpha, phiAlpha float64, ok bool) {
+	alphaPrev, phiPrev, dphiPrev := 0.0, phi0, dphi0
+	alpha = alpha1
+	for i := 0; i < maxLineSearchSteps; i++ {
+		phiAlpha, dphiAlpha := phi(alpha)
+		if !isFinite(phiAlpha) || phiAlpha > phi0+wolfeC1*alpha*dphi0 ||
+			(i > 0 && phiAlpha >= phiPrev) {
+			return zoom(phi, phi0, dphi0,
+				alphaPrev, phiPrev, dphiPrev, alpha, phiAlpha, dphiAlpha)
+		}
+		if math.Abs(dphiAlpha) <= -wolfeC2*dphi0 {
+			return alpha, phiAlpha, true
+		}
+		if dphiAlpha >= 0 {
+			return zoom(phi, phi0, dphi0,
+				alpha, phiAlpha, dphiAlpha, alphaPrev, phiPrev, dphiPrev)
+		}
+		alphaPrev, phiPrev, dphiPrev = alpha, phiAlpha, dphiAlpha
+		alpha *= 2
+	}
+	return alphaPrev, phiPrev, false
+}
+
+// 在区间[lo, hi]（lo可以大于hi）内寻找满足强Wolfe条件的步长
+// lo总是满足充分下降条件且函数值最小的步长
+func zoom(phi lineSearchFunc, phi0, dphi0 float64,
+	lo, phiLo, dphiLo, hi, phiHi, dphiHi float64) (float64, float64, bool) {
+	for i := 0; i < maxLineSearchSteps; i++ {
+		alpha := interpolateStep(lo, phiLo, dphiLo, hi, phiHi, dphiHi)
+		phiAlpha, dphiAlpha := phi(alpha)
+		if !isFinite(phiAlpha) || phiAlpha > phi0+wolfeC1*alpha*dphi0 || phiAlpha >= phiLo {
+			hi, phiHi, dphiHi = alpha, phiAlpha, dphiAlpha
+		} else {
+			if math.Abs(dphiAlpha) <= -wolfeC2*dphi0 {
+				return alpha, phiAlpha, true
+			}
+			if dphiAlpha*(hi-lo) >= 0 {
+				hi, phiHi, dphiHi = lo, phiLo, dphiLo
+			}
+			lo, phiLo, dphiLo = alpha, phiAlpha, dphiAlpha
+		}
+		if math.Abs(hi-lo) <= 1e-12*math.Max(1, math.Abs(lo)) {
+			break
+		}
+	}
+	return lo, phiLo, false
+}
+
+// 用a和b两点的函数值和导数做三次插值，返回插值函数在区间内的极小值点
+// 当插值失败或者极小值点太靠近区间端点时使用区间中点（二分法）
+func interpolateStep(a, phiA, dphiA, b, phiB, dphiB float64) float64 {
+	mid := (a + b) / 2
+	if !isFinite(phiA) || !isFinite(phiB) || !isFinite(dphiA) || !isFinite(dphiB) {
+		return mid
+	}
+
+	d1 := dphiA + dphiB - 3*(phiA-phiB)/(a-b)
+	d2Square := d1*d1 - dphiA*dphiB
+	if d2Square < 0 {
+		return mid
+	}
+	d2 := math.Sqrt(d2Square)
+	if b < a {
+		d2 = -d2
+	}
+	alpha := b - (b-a)*(dphiB+d2-d1)/(dphiB-dphiA+2*d2)
+
+	// 保护：试探步长必须在区间内部且离端点不太近
+	low, high := math.Min(a, b), math.Max(a, b)
+	margin := 0.1 * (high - low)
+	if !isFinite(alpha) || alpha < low+margin || alpha > high-margin {
+		return mid
+	}
+	return alpha
+}
+
+func isFinite(x float64) bool {
+	return !math.IsNaN(x) && !math.IsInf(x, 0)
+}
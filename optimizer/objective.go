@@ -0,0 +1,222 @@
+package optimizer
+
+import (
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/util"
+	"log"
+	"runtime"
+)
+
+// 数据集上的目标函数
+//
+// 目标函数定义为样本平均损失加上正则化项
+//   f(w) = (sum_i loss_i(w) + R(w)) / N
+// 其中N为样本数，R(w)见ComputeRegularizationLoss。
+//
+// 计算时数据集被裂分为numThreads份，每份由一个协程处理，最后合并各协程的结果。
+// 用newDatasetLossObjective创建时损失之和由DatasetLoss计算，此时不能计算海森矩阵。
+type datasetObjective struct {
+	set            data.Dataset
+	derivativeFunc ComputeInstanceDerivativeFunc
+	options        OptimizerOptions
+
+	// 不为nil时代替set和derivativeFunc计算损失之和
+	datasetLoss DatasetLoss
+
+	// 为各个工作协程开辟的临时资源
+	numThreads               int
+	workerSet                []data.Dataset
+	workerDerivative         []*util.Matrix
+	workerInstanceDerivative []*util.Matrix
+	workerLoss               []float64
+
+	// 海森矩阵和向量的乘积，只有tron使用
+	hessianVectorFunc ComputeInstanceHessianVectorFunc
+	workerHv          []*util.Matrix
+	workerInstanceHv  []*util.Matrix
+}
+
+// 创建目标函数，weights仅用于确定偏导数矩阵的类型和维度
+func newDatasetObjective(weights *util.Matrix, derivative_func ComputeInstanceDerivativeFunc,
+	set data.Dataset, options OptimizerOptions, numThreads int) *datasetObjective {
+	o := new(datasetObjective)
+	o.set = set
+	o.derivativeFunc = derivative_func
+	o.options = options
+	o.numThreads = numThreads
+
+	o.workerSet = make([]data.Dataset, numThreads)
+	o.workerDerivative = make([]*util.Matrix, numThreads)
+	o.workerInstanceDerivative = make([]*util.Matrix, numThreads)
+	o.workerLoss = make([]float64, numThreads)
+	for iWorker := 0; iWorker < numThreads; iWorker++ {
+		workerBuckets := []data.SkipBucket{
+			{SkipMode: true, NumInstances: iWorker},
+			{SkipMode: false, NumInstances: 1},
+			{SkipMode: true, NumInstances: numThreads - 1 - iWorker},
+		}
+		o.workerSet[iWorker] = data.NewSkipDataset(set, workerBuckets)
+		o.workerDerivative[iWorker] = weights.Populate()
+		o.workerInstanceDerivative[iWorker] = weights.Populate()
+	}
+	return o
+}
+
+// 创建用DatasetLoss计算损失之和的目标函数
+func newDatasetLossObjective(loss DatasetLoss, options OptimizerOptions) *datasetObjective {
+	o := new(datasetObjective)
+	o.options = options
+	o.datasetLoss = loss
+	return o
+}
+
+// 样本数N
+func (o *datasetObjective) numInstances() int {
+	if o.datasetLoss != nil {
+		return o.datasetLoss.NumInstances()
+	}
+	return o.set.NumInstances()
+}
+
+// 计算weights处的目标函数值，并将目标函数的偏导数写入derivative
+func (o *datasetObjective) Evaluate(weights, derivative *util.Matrix) float64 {
+	numInstances := float64(o.numInstances())
+
+	var loss float64
+	if o.datasetLoss != nil {
+		loss = o.datasetLoss.Evaluate(weights, derivative)
+		derivative.Scale(1 / numInstances)
+	} else {
+		loss = o.accumulate(weights, derivative, 1/numInstances)
+	}
+
+	// 添加正则化项
+	derivative.Increment(ComputeRegularization(weights, o.options), 1/numInstances)
+	loss += ComputeRegularizationLoss(weights, o.options)
+
+	return loss / numInstances
+}
+
+// 多协程计算所有样本的损失之和，并将样本偏导数乘以scale后的和写入derivative
+func (o *datasetObjective) accumulate(weights, derivative *util.Matrix, scale float64) float64 {
+
+	// 开始工作协程
+	workerChannel := make(chan int, o.numThreads)
+	for iWorker := 0; iWorker < o.numThreads; iWorker++ {
+		go func(iw int) {
+			o.workerDerivative[iw].Clear()
+			o.workerLoss[iw] = 0
+			iterator := o.workerSet[iw].CreateIterator()
+			iterator.Start()
+			for !iterator.End() {
+				instance := iterator.GetInstance()
+				o.workerLoss[iw] += o.derivativeFunc(
+					weights, instance, o.workerInstanceDerivative[iw])
+				o.workerDerivative[iw].Increment(
+					o.workerInstanceDerivative[iw], scale)
+				iterator.Next()
+			}
+			workerChannel <- iw
+		}(iWorker)
+	}
+
+	// 等待工作协程结束
+	for iWorker := 0; iWorker < o.numThreads; iWorker++ {
+		<-workerChannel
+	}
+
+	// 按固定顺序合并，保证结果不依赖于协程结束的先后
+	derivative.Clear()
+	loss := float64(0)
+	for iWorker := 0; iWorker < o.numThreads; iWorker++ {
+		derivative.Increment(o.workerDerivative[iWorker], 1)
+		loss += o.workerLoss[iWorker]
+	}
+	return loss
+}
+
+// 计算weights处目标函数的海森矩阵和向量v的乘积，结果写入hv
+//
+// 需要先设置hessianVectorFunc。正则化项只计算L2部分（海森矩阵为对角阵 factor * m_k），
+// L1部分和组套索在零点不可导，没有海森矩阵。
+func (o *datasetObjective) HessianVector(weights, v, hv *util.Matrix) {
+	if o.hessianVectorFunc == nil {
+		log.Fatal("没有设置海森矩阵和向量乘积的计算函数")
+	}
+	if o.datasetLoss != nil {
+		log.Fatal("使用DatasetLoss时无法计算海森矩阵和向量的乘积")
+	}
+	numInstances := float64(o.set.NumInstances())
+
+	// 第一次调用时开辟工作协程的临时资源
+	if o.workerHv == nil {
+		o.workerHv = make([]*util.Matrix, o.numThreads)
+		o.workerInstanceHv = make([]*util.Matrix, o.numThreads)
+		for iWorker := 0; iWorker < o.numThreads; iWorker++ {
+			o.workerHv[iWorker] = weights.Populate()
+			o.workerInstanceHv[iWorker] = weights.Populate()
+		}
+	}
+
+	workerChannel := make(chan int, o.numThreads)
+	for iWorker := 0; iWorker < o.numThreads; iWorker++ {
+		go func(iw int) {
+			o.workerHv[iw].Clear()
+			iterator := o.workerSet[iw].CreateIterator()
+			iterator.Start()
+			for !iterator.End() {
+				instance := iterator.GetInstance()
+				o.hessianVectorFunc(weights, instance, v, o.workerInstanceHv[iw])
+				o.workerHv[iw].Increment(o.workerInstanceHv[iw], 1/numInstances)
+				iterator.Next()
+			}
+			workerChannel <- iw
+		}(iWorker)
+	}
+	for iWorker := 0; iWorker < o.numThreads; iWorker++ {
+		<-workerChannel
+	}
+
+	hv.Clear()
+	for iWorker := 0; iWorker < o.numThreads; iWorker++ {
+		hv.Increment(o.workerHv[iWorker], 1)
+	}
+	if _, l2, _ := regularizationFactors(o.options); l2 != 0 {
+		if hasRegularizationMultipliers(o.options) {
+			for iLabel := 0; iLabel < v.NumLabels(); iLabel++ {
+				vec := v.GetValues(iLabel)
+				for _, k := range vec.Keys() {
+					multiplier := regularizationMultiplier(o.options, k)
+					hv.Set(iLabel, k, hv.Get(iLabel, k)+vec.Get(k)*l2*multiplier/numInstances)
+				}
+			}
+		} else {
+			hv.Increment(v, l2/numInstances)
+		}
+	}
+}
+
+// 在本进程的数据集上计算损失之和的DatasetLoss，见NewLocalDatasetLoss
+type localDatasetLoss struct {
+	objective *datasetObjective
+}
+
+// 创建在set上用numThreads个协程计算损失之和的DatasetLoss，weights仅用于确定偏导数矩阵
+// 的类型和维度。分布式训练的工作进程用它计算本地数据分片上的损失。
+func NewLocalDatasetLoss(weights *util.Matrix, derivative_func ComputeInstanceDerivativeFunc,
+	set data.Dataset, numThreads int) DatasetLoss {
+	if numThreads <= 0 {
+		numThreads = runtime.NumCPU()
+	}
+	return &localDatasetLoss{
+		objective: newDatasetObjective(weights, derivative_func, set, OptimizerOptions{}, numThreads),
+	}
+}
+
+func (l *localDatasetLoss) NumInstances() int {
+	return l.objective.set.NumInstances()
+}
+
+func (l *localDatasetLoss) Evaluate(weights, derivative *util.Matrix) float64 {
+	return l.objective.accumulate(weights, derivative, 1)
+}
@@ -1,20 +1,135 @@
 package optimizer
 
 import (
+	"context"
 	"github.com/huichen/mlf/data"
 	"github.com/huichen/mlf/util"
 	"log"
+	"strconv"
 )
 
+// 计算单个样本的损失函数值以及损失函数对权重的偏导数
+//
+// 偏导数写入instanceDerivative，返回值为样本的损失（比如负对数似然）。
+// 优化器通过损失值计算目标函数，用于线搜索等需要函数值的场合。
 type ComputeInstanceDerivativeFunc func(
-	weights *util.Matrix, instance *data.Instance, instanceDerivative *util.Matrix)
+	weights *util.Matrix, instance *data.Instance, instanceDerivative *util.Matrix) float64
+
+// 计算单个样本的损失函数对权重的海森矩阵H_i和向量v的乘积H_i * v，结果写入instanceHv
+//
+// 只有需要二阶信息的优化器（tron）使用，见HessianVectorOptimizer。
+type ComputeInstanceHessianVectorFunc func(
+	weights *util.Matrix, instance *data.Instance, v, instanceHv *util.Matrix)
+
+// 广义线性模型的单个样本损失函数
+//
+// 样本损失只通过样本在权重各行上的得分 scores[l] = w_l . x 依赖于权重。输入各行的得分，
+// 返回样本的损失，并将损失对各得分的一阶导数写入derivative，二阶导数（海森矩阵的
+// 对角元素）写入curvature。只有坐标下降法（cd）使用，见ScoreLossOptimizer。
+type ComputeInstanceScoreLossFunc func(
+	scores []float64, instance *data.Instance, derivative, curvature []float64) float64
+
+// 在验证集上评价权重的函数，返回值越大表示权重越好，见OptimizerOptions.ValidationSet
+type ValidationScoreFunc func(weights *util.Matrix, set data.Dataset) float64
+
+// 优化的结果
+type OptimizationResult struct {
+	// 执行了多少次优化循环
+	Iterations int
+
+	// 最后一次迭代的目标函数值
+	Loss float64
+
+	// 使用验证集时，最终选中的权重来自第几次迭代（从1开始），以及该权重在验证集上的分数
+	// 不使用验证集时BestIteration为0
+	BestIteration   int
+	ValidationScore float64
+
+	// 优化是否因为ctx被取消而提前结束
+	Canceled bool
+}
+
+// 将优化结果写入模型的元数据
+func (result OptimizationResult) Metadata() map[string]string {
+	metadata := map[string]string{
+		"iterations": strconv.Itoa(result.Iterations),
+		"loss":       strconv.FormatFloat(result.Loss, 'g', -1, 64),
+	}
+	if result.BestIteration > 0 {
+		metadata["best_iteration"] = strconv.Itoa(result.BestIteration)
+		metadata["validation_score"] = strconv.FormatFloat(result.ValidationScore, 'g', -1, 64)
+	}
+	return metadata
+}
+
+// 每次迭代后的优化进度，见OptimizerOptions.Progress
+type Progress struct {
+	// 迭代数，从1开始
+	Iteration int
+
+	// 目标函数值，|dw|/|w|和学习率（线搜索的优化器为步长）
+	Loss             float64
+	DeltaWeightRatio float64
+	LearningRate     float64
+
+	// 当前的权重，回调函数返回后会被继续修改，需要保存时请复制
+	Weights *util.Matrix
+}
+
+// 进度回调函数，在优化协程中同步调用
+type ProgressFunc func(progress Progress)
+
+func reportProgress(options OptimizerOptions, iteration int, loss, deltaWeightRatio, learningRate float64,
+	weights *util.Matrix) {
+	if options.Progress == nil {
+		return
+	}
+	options.Progress(Progress{
+		Iteration:        iteration,
+		Loss:             loss,
+		DeltaWeightRatio: deltaWeightRatio,
+		LearningRate:     learningRate,
+		Weights:          weights,
+	})
+}
 
 // 通用优化器接口
 type Optimizer interface {
 	Clear()
 	GetDeltaX(x, g *util.Matrix) *util.Matrix
-	OptimizeWeights(weights *util.Matrix,
-		derivative_func ComputeInstanceDerivativeFunc, set data.Dataset)
+
+	// 优化weights，weights的初始值为优化的起点
+	//
+	// 每次迭代之间检查ctx，ctx被取消时立即返回目前为止最好的权重（使用验证集时为
+	// 验证集上分数最高的权重，否则为最近一次完整迭代后的权重），此时结果的Canceled为true。
+	OptimizeWeights(ctx context.Context, weights *util.Matrix,
+		derivative_func ComputeInstanceDerivativeFunc, set data.Dataset) OptimizationResult
+}
+
+// 需要海森矩阵和向量乘积的优化器实现这个接口，模型的训练器应在OptimizeWeights之前
+// 检查优化器是否实现了这个接口并设置对应的函数
+type HessianVectorOptimizer interface {
+	SetHessianVectorFunc(hessian_vector_func ComputeInstanceHessianVectorFunc)
+}
+
+// 需要广义线性模型损失函数的优化器实现这个接口，用法和HessianVectorOptimizer相同
+type ScoreLossOptimizer interface {
+	SetScoreLossFunc(score_loss_func ComputeInstanceScoreLossFunc)
+}
+
+// 数据集上的损失之和，用于数据集不在本进程中的场合（比如分布式训练，见distributed包）
+//
+// Evaluate返回weights处所有样本的损失之和，并将所有样本的偏导数之和写入derivative，
+// 两者都不包括正则化项。
+type DatasetLoss interface {
+	NumInstances() int
+	Evaluate(weights, derivative *util.Matrix) float64
+}
+
+// 可以用DatasetLoss代替样本偏导数函数和数据集计算目标函数的优化器（lbfgs和owlqn）实现
+// 这个接口。设置后OptimizeWeights忽略derivative_func和set参数，两者可以为nil。
+type DatasetLossOptimizer interface {
+	SetDatasetLoss(loss DatasetLoss)
 }
 
 func NewOptimizer(options OptimizerOptions) Optimizer {
@@ -22,6 +137,20 @@ func NewOptimizer(options OptimizerOptions) Optimizer {
 		return NewLbfgsOptimizer(options)
 	} else if options.OptimizerName == "gd" {
 		return NewGdOptimizer(options)
+	} else if options.OptimizerName == "owlqn" {
+		return NewOwlqnOptimizer(options)
+	} else if options.OptimizerName == "cd" {
+		return NewCdOptimizer(options)
+	} else if options.OptimizerName == "tron" {
+		return NewTronOptimizer(options)
+	} else if options.OptimizerName == "hogwild" {
+		return NewHogwildOptimizer(options)
+	} else if options.OptimizerName == "svrg" || options.OptimizerName == "saga" {
+		return NewVarianceReducedOptimizer(options)
+	} else if options.OptimizerName == "momentum" || options.OptimizerName == "nesterov" ||
+		options.OptimizerName == "adagrad" || options.OptimizerName == "rmsprop" ||
+		options.OptimizerName == "adam" {
+		return NewAdaptiveOptimizer(options)
 	}
 
 	log.Fatal("必须指定合法的OptimizerName")
@@ -1,5 +1,10 @@
 package optimizer
 
+import (
+	"github.com/huichen/mlf/data"
+	"runtime"
+)
+
 type OptimizerOptions struct {
 	// 优化器名称
 	OptimizerName string
@@ -8,12 +13,27 @@ type OptimizerOptions struct {
 	// 当值为 0 时，不使用正则化
 	// 当值为 1 时，使用L1正则化
 	// 当值为 2 时，使用L2正则化
+	// 当值为 3 时，使用弹性网络（elastic net）正则化，即L1和L2正则化的混合
+	// 当值为 4 时，使用组套索（group lasso）正则化，每个特征在权重矩阵所有行上的元素为一组
 	RegularizationScheme int
 
 	// 正则化因子
 	// 当值为0.0值，使用 --default_regularization_factor
 	RegularizationFactor float64
 
+	// 弹性网络中L1正则化所占的比例，取值范围[0, 1]，见ComputeRegularizationLoss
+	ElasticNetRatio float64
+
+	// 各特征的正则化系数，特征k的正则化因子为 RegularizationFactor * RegularizationMultipliers[k]，
+	// 没有指定的特征系数为1。ExcludeBiasFromRegularization为true时偏置特征（第0个特征）的
+	// 系数为0，即不对偏置做正则化。
+	RegularizationMultipliers     map[int]float64
+	ExcludeBiasFromRegularization bool
+
+	// 为true时梯度递降（gd）不在偏导数中加入正则化项的次梯度，而是在每次更新权重后
+	// 做近端更新（见ApplyProximalRegularization），L1和组套索可以得到精确为零的权重
+	ProximalRegularization bool
+
 	// 下面两个参数定义带Annealing的学习率：
 	//
 	// alpha = LearningRate / (1 + t / CharacteristicTime)
@@ -22,21 +42,90 @@ type OptimizerOptions struct {
 	// 1. t为循环数（从0开始）
 	// 2. LearningRate为初始的学习率，如果值为0则使用学习率=1
 	// 3. 当CharacteristicTime为0时我们使用常数学习率LearningRate
-	LearningRate       float64
-	CharacteristicTime float64
+	// LearningRateSchedule.Name不为空时使用其它的学习率调度，见LearningRateSchedule
+	LearningRate         float64
+	CharacteristicTime   float64
+	LearningRateSchedule LearningRateSchedule
 
 	// 最多执行多少次优化循环，当值为0时不设限
 	MaxIterations int
 
 	// 收敛条件：
-	// 1. |dw| / |w| < ConvergingDeltaWeight
-	// 2. 必须连续满足上一个条件NumConvergingSteps次
-	ConvergingDeltaWeight float64
-	ConvergingSteps       int
+	// 1. 满足下面任一条件（值为0的条件不使用）
+	//    |dw| / |w| < ConvergingDeltaWeight
+	//    目标函数的相对变化 |f_old - f| / max(|f_old|, |f|, 1) < ConvergingRelativeLoss
+	//    目标函数偏导数（L1正则化的owlqn为伪梯度）的长度 |g| < ConvergingGradientNorm
+	// 2. 必须连续满足上一个条件超过ConvergingSteps次，值为0时为3，小于0时第一次满足即收敛
+	ConvergingDeltaWeight  float64
+	ConvergingRelativeLoss float64
+	ConvergingGradientNorm float64
+	ConvergingSteps        int
 
 	// 每批次更新权重前累加多少个样本的的偏导数（仅对GD优化器起作用）
 	// GDBatchSize = 0         // full-bath gradient descent
 	// GDBatchSize = 1         // stochastic gradient descent
 	// GDBatchSize = n (n>1)   // mini-batch gradient descent
 	GDBatchSize int
+
+	// lbfgs和owlqn保存的历史步数，值为0时为5
+	LbfgsHistorySize int
+
+	// 并行计算使用的协程数，值为0时使用CPU的核数。lbfgs、owlqn、tron、svrg和正则化路径
+	// 用这些协程计算目标函数，hogwild为异步更新权重的协程数。
+	NumThreads int
+
+	// 自适应优化器（见adaptiveOptimizer）的超参数，值为0时使用括号中的默认值
+	// Momentum：momentum和nesterov的动量系数（0.9）
+	// RMSPropDecay：rmsprop二阶矩的衰减系数（0.9）
+	// AdamBeta1和AdamBeta2：adam一阶矩和二阶矩的衰减系数（0.9和0.999）
+	// AdaptiveEpsilon：adagrad、rmsprop和adam分母中防止除零的小量（1e-8）
+	Momentum        float64
+	RMSPropDecay    float64
+	AdamBeta1       float64
+	AdamBeta2       float64
+	AdaptiveEpsilon float64
+
+	// 提前终止（early stopping）：
+	// 当ValidationSet不为nil时，每次迭代后用ValidationScore在验证集上评价权重（分数越大越好），
+	// 连续EarlyStoppingSteps次迭代分数没有提高时停止优化，值为0时只在其它条件满足时停止。
+	// 优化结束后权重恢复为验证集上分数最高的那次迭代的权重。
+	// 用eval.Evaluator构造ValidationScore的方法见eval.NewValidationScore。
+	ValidationSet      data.Dataset        `json:"-"`
+	ValidationScore    ValidationScoreFunc `json:"-"`
+	EarlyStoppingSteps int
+
+	// 每次迭代后调用的进度回调函数，可以为nil
+	Progress ProgressFunc `json:"-"`
+
+	// 检查点：
+	// 当CheckpointPath不为空时，每CheckpointInterval次迭代（值为0时为每次迭代）将权重、
+	// 优化器的内部状态（比如lbfgs的历史）和迭代计数写入CheckpointPath文件。
+	// 当ResumeFromCheckpoint为true并且文件存在时，优化从检查点继续，结果和不中断时相同。
+	CheckpointPath       string
+	CheckpointInterval   int
+	ResumeFromCheckpoint bool
+}
+
+// lbfgs和owlqn保存的历史步数
+func lbfgsHistorySize(options OptimizerOptions) int {
+	if options.LbfgsHistorySize == 0 {
+		return 5
+	}
+	return options.LbfgsHistorySize
+}
+
+// 判定收敛前必须连续满足收敛条件的次数
+func convergingSteps(options OptimizerOptions) int {
+	if options.ConvergingSteps == 0 {
+		return 3
+	}
+	return options.ConvergingSteps
+}
+
+// 并行计算使用的协程数
+func numThreads(options OptimizerOptions) int {
+	if options.NumThreads == 0 {
+		return runtime.NumCPU()
+	}
+	return options.NumThreads
 }
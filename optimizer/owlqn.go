@@ -0,0 +1,291 @@
+package optimizer
+
+import (
+	"context"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/util"
+	"log"
+	"math"
+)
+
+// Orthant-Wise Limited-memory Quasi-Newton (OWL-QN)优化器
+//
+// 用于求解L1正则化的目标函数 f(w) = l(w) + c * |w|_1，其中l(w)为光滑的损失函数。
+// 算法见下面的论文
+//   Andrew, G. & Gao, J. (2007). "Scalable training of L1-regularized log-linear models".
+//   Proceedings of the 24th International Conference on Machine Learning: 33–40.
+//
+// 和ComputeRegularization中的次梯度方法不同，OWL-QN在每一步将权重限制在一个象限内，
+// 越过零点的权重被精确地置为零，因此能得到真正稀疏的模型。
+//
+// 当RegularizationScheme为1或3（弹性网络）时单独处理L1部分（各特征的c还要乘以正则化系数，
+// 见regularizationMultiplier），L2部分和其它正则化计入光滑部分；否则OWL-QN等价于L-BFGS。
+// 组套索在光滑部分中以次梯度处理，不能得到精确为零的权重。和lbfgs一样，
+// 优化器是协程不安全和迭代不安全的，请见lbfgsOptimizer的注释。
+type owlqnOptimizer struct {
+	// 拟牛顿方向的两个循环算法和lbfgs共用，历史s和y由光滑部分l(w)的偏导数计算
+	lbfgsOptimizer
+
+	// 最近一次调用GetDeltaX时计算的伪梯度
+	pseudoGradient *util.Matrix
+}
+
+// 开辟新的owlqnOptimizer指针
+func NewOwlqnOptimizer(options OptimizerOptions) Optimizer {
+	opt := new(owlqnOptimizer)
+	opt.options = options
+	opt.historySize = lbfgsHistorySize(options)
+	opt.k = 0
+	return opt
+}
+
+// 输入x_k和光滑部分的偏导数g_k，返回x需要更新的增量
+//
+// 增量为 d_k = - H_k * pg_k，其中pg_k为伪梯度（见computePseudoGradient）。
+// d_k中和-pg_k符号不同的分量被置为零，保证d_k是下降方向。L1正则化系数c为options中
+// 的L1部分，g_k应该是对应的未平均的光滑部分的偏导数。
+func (opt *owlqnOptimizer) GetDeltaX(x, g *util.Matrix) *util.Matrix {
+	l1, _, _ := regularizationFactors(opt.options)
+	return opt.deltaX(x, g, l1)
+}
+
+// 和GetDeltaX相同，但L1正则化系数为l1
+func (opt *owlqnOptimizer) deltaX(x, g *util.Matrix, l1 float64) *util.Matrix {
+	opt.pseudoGradient = opt.computePseudoGradient(x, g, l1)
+	opt.updateHistory(x, g)
+
+	var delta *util.Matrix
+	if opt.k == 1 {
+		delta = opt.pseudoGradient.Opposite()
+	} else {
+		delta = opt.twoLoopRecursion(opt.pseudoGradient).Opposite()
+	}
+
+	for iLabel := 0; iLabel < delta.NumLabels(); iLabel++ {
+		vec := delta.GetValues(iLabel)
+		for _, k := range vec.Keys() {
+			if vec.Get(k)*opt.pseudoGradient.Get(iLabel, k) >= 0 {
+				vec.Set(k, 0)
+			}
+		}
+	}
+	delta.RemoveZeros()
+	return delta
+}
+
+// 计算 f(w) = l(w) + c * |w|_1 的伪梯度，c为l1乘以特征的正则化系数
+//
+//   当w_i != 0时，pg_i = g_i + c * sign(w_i)
+//   当w_i == 0时，pg_i = g_i + c（如果g_i + c < 0），g_i - c（如果g_i - c > 0），否则为0
+func (opt *owlqnOptimizer) computePseudoGradient(x, g *util.Matrix, l1 float64) *util.Matrix {
+	pg := g.Populate()
+	for iLabel := 0; iLabel < g.NumLabels(); iLabel++ {
+		for _, k := range g.GetValues(iLabel).Keys() {
+			pg.Set(iLabel, k, opt.pseudoGradientElement(x.Get(iLabel, k), g.Get(iLabel, k),
+				l1*regularizationMultiplier(opt.options, k)))
+		}
+		if x.IsSparse() {
+			// 稀疏矩阵中g可能不包含x的某些元素
+			for _, k := range x.GetValues(iLabel).Keys() {
+				pg.Set(iLabel, k, opt.pseudoGradientElement(x.Get(iLabel, k), g.Get(iLabel, k),
+					l1*regularizationMultiplier(opt.options, k)))
+			}
+		}
+	}
+	return pg
+}
+
+func (opt *owlqnOptimizer) pseudoGradientElement(w, g, c float64) float64 {
+	if w > 0 {
+		return g + c
+	} else if w < 0 {
+		return g - c
+	} else if g+c < 0 {
+		return g + c
+	} else if g-c > 0 {
+		return g - c
+	}
+	return 0
+}
+
+// 将trial投影到weights所在的象限：和参考符号不一致的元素置为零，正则化系数为0的特征除外
+// 参考符号为sign(w_i)，当w_i为零时为sign(-pg_i)
+func (opt *owlqnOptimizer) projectToOrthant(trial, weights *util.Matrix) {
+	for iLabel := 0; iLabel < trial.NumLabels(); iLabel++ {
+		vec := trial.GetValues(iLabel)
+		for _, k := range vec.Keys() {
+			// 不做正则化的特征没有不可导点，不需要限制象限
+			if regularizationMultiplier(opt.options, k) == 0 {
+				continue
+			}
+			orthant := weights.Get(iLabel, k)
+			if orthant == 0 {
+				orthant = -opt.pseudoGradient.Get(iLabel, k)
+			}
+			if vec.Get(k)*orthant <= 0 {
+				vec.Set(k, 0)
+			}
+		}
+	}
+	trial.RemoveZeros()
+}
+
+// 计算 c * |w|_1，c为l1乘以特征的正则化系数
+func (opt *owlqnOptimizer) l1Loss(weights *util.Matrix, l1 float64) float64 {
+	loss := float64(0)
+	for iLabel := 0; iLabel < weights.NumLabels(); iLabel++ {
+		for _, k := range weights.GetValues(iLabel).Keys() {
+			loss += regularizationMultiplier(opt.options, k) * math.Abs(weights.Get(iLabel, k))
+		}
+	}
+	return l1 * loss
+}
+
+func (opt *owlqnOptimizer) OptimizeWeights(ctx context.Context,
+	weights *util.Matrix, derivative_func ComputeInstanceDerivativeFunc, set data.Dataset) OptimizationResult {
+
+	// 学习率计算器，学习率作为线搜索的初始试探步长
+	learningRate := NewLearningRate(opt.options)
+
+	// 光滑部分的目标函数，L1部分单独处理
+	smoothOptions := opt.options
+	l1, l2, _ := regularizationFactors(opt.options)
+	if l1 != 0 {
+		smoothOptions.RegularizationScheme = 0
+		if l2 != 0 {
+			smoothOptions.RegularizationScheme = 2
+			smoothOptions.RegularizationFactor = l2
+		}
+	}
+	objective := opt.newObjective(weights, derivative_func, set, smoothOptions)
+
+	// 目标函数按样本数平均，L1正则化系数也要除以样本数
+	l1 /= float64(objective.numInstances())
+
+	// 光滑部分的偏导数向量
+	derivative := weights.Populate()
+
+	// 线搜索中的试探点
+	trialWeights := weights.Populate()
+	trialDerivative := weights.Populate()
+	weightsStep := weights.Populate()
+
+	// 优化循环
+	step := 0
+	checker := newConvergenceChecker(opt.options)
+	es := newEarlyStopping(opt.options, weights)
+
+	// 从检查点恢复
+	state := &optimizationState{
+		weights:       weights,
+		optimizer:     opt,
+		learningRate:  learningRate,
+		checker:       checker,
+		earlyStopping: es,
+	}
+	if loadCheckpoint(opt.options, state) {
+		step = state.step
+	}
+
+	oldWeights := weights.Populate()
+	oldWeights.DeepCopy(weights)
+	weightsDelta := weights.Populate()
+
+	log.Print("开始OWL-QN优化")
+	loss := objective.Evaluate(weights, derivative) + opt.l1Loss(weights, l1)
+	canceled := false
+	for {
+		if opt.options.MaxIterations > 0 && step >= opt.options.MaxIterations {
+			break
+		}
+		if ctx.Err() != nil {
+			canceled = true
+			break
+		}
+		step++
+
+		// 计算搜索方向，如果不是下降方向则丢弃历史重新开始
+		delta := opt.deltaX(weights, derivative, l1)
+		dphi0 := util.MatrixDotProduct(opt.pseudoGradient, delta)
+		if !(dphi0 < 0) && opt.k > 1 {
+			opt.Clear()
+			delta = opt.deltaX(weights, derivative, l1)
+			dphi0 = util.MatrixDotProduct(opt.pseudoGradient, delta)
+		}
+		if !(dphi0 < 0) {
+			log.Printf("伪梯度为零，停止优化")
+			// 权重没有更新，这次迭代不计数
+			step--
+			break
+		}
+
+		// 初始试探步长，第一步（梯度递降）时根据方向的长度缩放
+		learning_rate := learningRate.ComputeLearningRate(delta)
+		if learning_rate == 0 {
+			learning_rate = 1
+		}
+		if opt.k == 1 {
+			learning_rate = math.Min(learning_rate, learning_rate/delta.Norm())
+		}
+
+		// 在象限内回溯线搜索，直到满足充分下降条件
+		//   f(w') <= f(w) + c1 * pg·(w' - w)
+		accepted := false
+		var newLoss float64
+		for i := 0; i < maxLineSearchSteps; i++ {
+			trialWeights.WeightedSum(weights, delta, 1, learning_rate)
+			opt.projectToOrthant(trialWeights, weights)
+			newLoss = objective.Evaluate(trialWeights, trialDerivative) +
+				opt.l1Loss(trialWeights, l1)
+			weightsStep.WeightedSum(trialWeights, weights, 1, -1)
+			if newLoss <= loss+wolfeC1*util.MatrixDotProduct(opt.pseudoGradient, weightsStep) {
+				accepted = true
+				break
+			}
+			learning_rate /= 2
+		}
+		if !accepted {
+			log.Printf("线搜索失败，停止优化")
+			// 权重没有更新，这次迭代不计数
+			step--
+			break
+		}
+
+		// 更新权重
+		weights.DeepCopy(trialWeights)
+		derivative.DeepCopy(trialDerivative)
+		loss = newLoss
+
+		weightsDelta.WeightedSum(weights, oldWeights, 1, -1)
+		oldWeights.DeepCopy(weights)
+		weightsNorm := weights.Norm()
+		weightsDeltaNorm := weightsDelta.Norm()
+		pseudoGradientNorm := opt.computePseudoGradient(weights, derivative, l1).Norm()
+		log.Printf("#%d f=%1.6g |g|=%1.3g |dw|/|w|=%f |w|=%f lr=%1.3g",
+			step, loss, pseudoGradientNorm, weightsDeltaNorm/weightsNorm, weightsNorm, learning_rate)
+
+		// 判断是否溢出
+		if math.IsNaN(weightsNorm) {
+			log.Fatal("优化失败：不收敛")
+		}
+
+		reportProgress(opt.options, step, loss, weightsDeltaNorm/weightsNorm, learning_rate, weights)
+
+		// 先在验证集上评价这次迭代的权重，收敛时最后的权重也参与比较
+		if es.update(step, weights) {
+			break
+		}
+		// 判断是否收敛，L1正则化时使用伪梯度的长度
+		if checker.converged(weightsDeltaNorm/weightsNorm, loss, pseudoGradientNorm) {
+			break
+		}
+
+		state.step = step
+		saveCheckpoint(opt.options, state)
+	}
+
+	result := OptimizationResult{Iterations: step, Loss: loss, Canceled: canceled}
+	es.finish(weights, &result)
+	weights.RemoveZeros()
+	return result
+}
@@ -0,0 +1,102 @@
+package optimizer
+
+import (
+	"context"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/util"
+	"math"
+	"testing"
+)
+
+// 二分类logistic回归的偏导数，weights只有一行
+func logisticDerivative(weights *util.Matrix, instance *data.Instance, instanceDerivative *util.Matrix) float64 {
+	features := instance.Features
+	score := util.VecDotProduct(features, weights.GetValues(0))
+	p := 1 / (1 + math.Exp(-score))
+	y := float64(instance.Output.Label)
+
+	vec := instanceDerivative.GetValues(0)
+	vec.Clear()
+	for _, k := range features.Keys() {
+		vec.Set(k, (p-y)*features.Get(k))
+	}
+	return math.Log(1+math.Exp(score)) - y*score
+}
+
+// 第1个特征决定标注，第2个特征是噪音
+func newLogisticTestDataset(sparse bool) data.Dataset {
+	set := data.NewInmemDataset()
+	values := [][]float64{
+		{1, 2, 0.3}, {1, 1.5, -0.2}, {1, 1, 0.1}, {1, -1, 0.2},
+		{1, -2, -0.1}, {1, -1.5, 0.3}, {1, 0.5, -0.3}, {1, -0.5, -0.2},
+	}
+	for i, v := range values {
+		instance := new(data.Instance)
+		if sparse {
+			instance.Features = util.NewSparseVector()
+		} else {
+			instance.Features = util.NewVector(3)
+		}
+		instance.Features.SetValues(v)
+		label := 0
+		if v[1] > 0 && i != 6 {
+			label = 1
+		}
+		instance.Output = &data.InstanceOutput{Label: label}
+		set.AddInstance(instance)
+	}
+	set.Finalize()
+	return set
+}
+
+func TestOwlqnOptimizer(t *testing.T) {
+	for _, sparse := range []bool{false, true} {
+		set := newLogisticTestDataset(sparse)
+		var weights *util.Matrix
+		if sparse {
+			weights = util.NewSparseMatrix(1)
+		} else {
+			weights = util.NewMatrix(1, 3)
+		}
+
+		opt := NewOptimizer(OptimizerOptions{
+			OptimizerName:         "owlqn",
+			RegularizationScheme:  1,
+			RegularizationFactor:  2,
+			ConvergingDeltaWeight: 1e-8,
+			ConvergingSteps:       3,
+			MaxIterations:         100,
+		})
+		opt.OptimizeWeights(context.Background(), weights, logisticDerivative, set)
+
+		// 噪音特征的权重被精确地置为零
+		util.Expect(t, "true", weights.Get(0, 1) > 0.5)
+		util.Expect(t, "0", weights.Get(0, 2))
+		if sparse {
+			for _, k := range weights.GetValues(0).Keys() {
+				util.Expect(t, "true", k != 2)
+			}
+		}
+	}
+}
+
+func TestOwlqnPseudoGradientScale(t *testing.T) {
+	opt := NewOwlqnOptimizer(OptimizerOptions{
+		RegularizationScheme: 1,
+		RegularizationFactor: 1,
+	}).(*owlqnOptimizer)
+
+	x := util.NewMatrix(1, 2)
+	g := util.NewMatrix(1, 2)
+	g.GetValues(0).SetValues([]float64{1.5, 0.5})
+
+	// 伪梯度使用传入的L1系数
+	pg := opt.computePseudoGradient(x, g, 0.25)
+	util.Expect(t, "1.25", pg.Get(0, 0))
+	util.Expect(t, "0.25", pg.Get(0, 1))
+
+	// GetDeltaX使用options中的L1系数，第一步沿负伪梯度方向
+	delta := opt.GetDeltaX(x, g)
+	util.Expect(t, "-0.5", delta.Get(0, 0))
+	util.Expect(t, "0", delta.Get(0, 1))
+}
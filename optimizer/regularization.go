@@ -2,31 +2,211 @@ package optimizer
 
 import (
 	"github.com/huichen/mlf/util"
+	"math"
 )
 
 // 根据正则化方法计算偏导数向量需要添加正则化项
 func ComputeRegularization(weights *util.Matrix, options OptimizerOptions) *util.Matrix {
-	reg := weights.Populate()
+	return ComputeRegularizationOnKeys(weights, weights, options)
+}
 
-	if options.RegularizationScheme == 1 {
-		// L-1正则化
+// 计算正则化项R(w)的值，ComputeRegularization返回的是它的偏导数
+//   L1正则化：R(w) = factor * sum_i m_k * |w_i|
+//   L2正则化：R(w) = factor / 2 * sum_i m_k * w_i^2
+//   弹性网络：R(w) = factor * sum_i m_k * (ratio * |w_i| + (1 - ratio) / 2 * w_i^2)
+//   组套索：  R(w) = factor * sum_k m_k * sqrt(sum_l w_lk^2)
+// 其中w_i为第k个特征某一行的权重，m_k为第k个特征的正则化系数（见regularizationMultiplier），
+// ratio为ElasticNetRatio。组套索中每个特征在所有行上的权重组成一组，一个特征的权重
+// 要么全部为零，要么全部不为零。
+func ComputeRegularizationLoss(weights *util.Matrix, options OptimizerOptions) float64 {
+	l1, l2, group := regularizationFactors(options)
+	loss := float64(0)
+
+	if l1 != 0 {
 		for iLabel := 0; iLabel < weights.NumLabels(); iLabel++ {
 			for _, k := range weights.GetValues(iLabel).Keys() {
-				if weights.Get(iLabel, k) > 0 {
-					reg.Set(iLabel, k, options.RegularizationFactor)
-				} else {
-					reg.Set(iLabel, k, -options.RegularizationFactor)
+				loss += l1 * regularizationMultiplier(options, k) * math.Abs(weights.Get(iLabel, k))
+			}
+		}
+	}
+	if l2 != 0 {
+		if hasRegularizationMultipliers(options) {
+			for iLabel := 0; iLabel < weights.NumLabels(); iLabel++ {
+				for _, k := range weights.GetValues(iLabel).Keys() {
+					w := weights.Get(iLabel, k)
+					loss += l2 * regularizationMultiplier(options, k) * w * w / 2
 				}
 			}
+		} else {
+			loss += l2 * weights.Norm() * weights.Norm() / 2
 		}
-	} else if options.RegularizationScheme == 2 {
-		// L-2正则化
-		for iLabel := 0; iLabel < weights.NumLabels(); iLabel++ {
-			for _, k := range weights.GetValues(iLabel).Keys() {
-				reg.Set(iLabel, k, options.RegularizationFactor*weights.Get(iLabel, k))
+	}
+	if group != 0 {
+		for _, k := range featureKeys(weights) {
+			loss += group * regularizationMultiplier(options, k) * groupNorm(weights, k)
+		}
+	}
+
+	return loss
+}
+
+// 返回正则化项中L1部分、L2部分和组套索部分的因子
+func regularizationFactors(options OptimizerOptions) (l1, l2, group float64) {
+	switch options.RegularizationScheme {
+	case 1:
+		l1 = options.RegularizationFactor
+	case 2:
+		l2 = options.RegularizationFactor
+	case 3:
+		l1 = options.RegularizationFactor * options.ElasticNetRatio
+		l2 = options.RegularizationFactor * (1 - options.ElasticNetRatio)
+	case 4:
+		group = options.RegularizationFactor
+	}
+	return
+}
+
+// 第k个特征的正则化系数
+//
+// ExcludeBiasFromRegularization为true时偏置特征（第0个特征）的系数为0，
+// 否则为RegularizationMultipliers中的值，没有指定时为1。
+func regularizationMultiplier(options OptimizerOptions, k int) float64 {
+	if k == 0 && options.ExcludeBiasFromRegularization {
+		return 0
+	}
+	if multiplier, ok := options.RegularizationMultipliers[k]; ok {
+		return multiplier
+	}
+	return 1
+}
+
+func hasRegularizationMultipliers(options OptimizerOptions) bool {
+	return options.ExcludeBiasFromRegularization || len(options.RegularizationMultipliers) > 0
+}
+
+// 第k个特征在所有行上的权重组成的向量的长度
+func groupNorm(weights *util.Matrix, k int) float64 {
+	result := float64(0)
+	for iLabel := 0; iLabel < weights.NumLabels(); iLabel++ {
+		w := weights.Get(iLabel, k)
+		result += w * w
+	}
+	return math.Sqrt(result)
+}
+
+// 矩阵各行中出现的特征的并集，按第一次出现的顺序排列
+func featureKeys(m *util.Matrix) []int {
+	if !m.IsSparse() {
+		return m.GetValues(0).Keys()
+	}
+	keys := []int{}
+	seen := make(map[int]bool)
+	for iLabel := 0; iLabel < m.NumLabels(); iLabel++ {
+		for _, k := range m.GetValues(iLabel).Keys() {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys
+}
+
+// 和ComputeRegularization相同，但只计算keys矩阵中出现的元素
+// 用于稀疏权重的惰性更新：只有当前批次中出现的特征才需要正则化
+func ComputeRegularizationOnKeys(weights, keys *util.Matrix, options OptimizerOptions) *util.Matrix {
+	reg := weights.Populate()
+	l1, l2, group := regularizationFactors(options)
+	if l1 == 0 && l2 == 0 && group == 0 {
+		return reg
+	}
+
+	for iLabel := 0; iLabel < weights.NumLabels(); iLabel++ {
+		for _, k := range keys.GetValues(iLabel).Keys() {
+			if value := regularizationDerivative(weights, iLabel, k, options, l1, l2, group); value != 0 {
+				reg.Set(iLabel, k, value)
 			}
 		}
 	}
 
 	return reg
 }
+
+// 计算正则化项对第iLabel行第k个权重的偏导数，l1、l2和group见regularizationFactors
+func regularizationDerivative(weights *util.Matrix, iLabel, k int, options OptimizerOptions,
+	l1, l2, group float64) float64 {
+	multiplier := regularizationMultiplier(options, k)
+	if multiplier == 0 {
+		return 0
+	}
+	w := weights.Get(iLabel, k)
+
+	// L1部分使用次梯度
+	value := l2 * multiplier * w
+	if l1 != 0 {
+		if w > 0 {
+			value += l1 * multiplier
+		} else {
+			value -= l1 * multiplier
+		}
+	}
+
+	// 组套索在整组权重为零处不可导，此时取次梯度0
+	if group != 0 {
+		if norm := groupNorm(weights, k); norm > 0 {
+			value += group * multiplier * w / norm
+		}
+	}
+	return value
+}
+
+// 对权重做正则化项的近端（proximal）更新，step为步长
+//
+// 近端更新求解 argmin_v { |v - w|^2 / (2 * step) + R(v) }，即先按偏导数更新权重（不含正则化项），
+// 再调用此函数。和在偏导数中加入次梯度相比，L1部分和组套索能把权重精确地置为零：
+//   L1、L2和弹性网络：w_i = sign(w_i) * max(0, |w_i| - step * l1 * m_k) / (1 + step * l2 * m_k)
+//   组套索：          w_lk = w_lk * max(0, 1 - step * factor * m_k / sqrt(sum_l w_lk^2))
+// keys不为nil时只更新keys中出现的特征，用于稀疏权重的惰性更新。
+func ApplyProximalRegularization(weights, keys *util.Matrix, options OptimizerOptions, step float64) {
+	l1, l2, group := regularizationFactors(options)
+	if l1 == 0 && l2 == 0 && group == 0 {
+		return
+	}
+	if keys == nil {
+		keys = weights
+	}
+
+	for _, k := range featureKeys(keys) {
+		multiplier := regularizationMultiplier(options, k)
+		if multiplier == 0 {
+			continue
+		}
+
+		if group != 0 {
+			norm := groupNorm(weights, k)
+			scale := float64(0)
+			if norm > 0 {
+				scale = math.Max(0, 1-step*group*multiplier/norm)
+			}
+			for iLabel := 0; iLabel < weights.NumLabels(); iLabel++ {
+				if weights.Get(iLabel, k) != 0 {
+					weights.Set(iLabel, k, weights.Get(iLabel, k)*scale)
+				}
+			}
+			continue
+		}
+
+		for iLabel := 0; iLabel < weights.NumLabels(); iLabel++ {
+			w := weights.Get(iLabel, k)
+			if w == 0 {
+				continue
+			}
+			shrunk := math.Max(0, math.Abs(w)-step*l1*multiplier) / (1 + step*l2*multiplier)
+			if w < 0 {
+				shrunk = -shrunk
+			}
+			weights.Set(iLabel, k, shrunk)
+		}
+	}
+	weights.RemoveZeros()
+}
@@ -0,0 +1,141 @@
+package optimizer
+
+import (
+	"context"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/util"
+	"log"
+	"math"
+)
+
+// 正则化路径的选项，见ComputeRegularizationPath
+type RegularizationPathOptions struct {
+	// 依次使用的正则化因子，应从大到小排列
+	// 为空时自动生成：从使所有权重都为零的最小因子factor_max开始，按等比数列递减到
+	// factor_max * MinFactorRatio，共NumFactors个。自动生成需要正则化包含L1部分或者为组套索。
+	Factors []float64
+
+	// 自动生成正则化因子时使用，值为0时分别使用100和1e-3
+	NumFactors     int
+	MinFactorRatio float64
+}
+
+// 正则化路径上的一个点
+type RegularizationPathPoint struct {
+	// 正则化因子
+	Factor float64
+
+	// 该因子下优化得到的权重和优化结果
+	Weights *util.Matrix
+	Result  OptimizationResult
+
+	// 非零权重的个数
+	NumNonZeros int
+}
+
+// 计算正则化路径
+//
+// 按从大到小的顺序依次使用各正则化因子优化，每次优化从上一个因子的解开始（warm start），
+// 因子较大时解很稀疏，优化很快，因此计算整条路径通常比单独优化每个因子快得多。
+//
+// options中的RegularizationFactor被忽略，其它选项（包括RegularizationScheme和
+// ElasticNetRatio）对所有因子相同。每个因子都用options新建一个优化器，如果优化器实现了
+// ScoreLossOptimizer则设置score_loss_func（不需要时可以为nil）。weights为路径的起点，
+// 返回时为最后一个因子的解。ctx被取消时返回已经完成的点。
+func ComputeRegularizationPath(ctx context.Context, options OptimizerOptions,
+	pathOptions RegularizationPathOptions, weights *util.Matrix,
+	derivative_func ComputeInstanceDerivativeFunc, score_loss_func ComputeInstanceScoreLossFunc,
+	set data.Dataset) []RegularizationPathPoint {
+	factors := pathOptions.Factors
+	if len(factors) == 0 {
+		factors = regularizationPathFactors(options, pathOptions, weights, derivative_func, set)
+	}
+
+	path := []RegularizationPathPoint{}
+	for _, factor := range factors {
+		if ctx.Err() != nil {
+			break
+		}
+		log.Printf("正则化路径：正则化因子=%g", factor)
+		factorOptions := options
+		factorOptions.RegularizationFactor = factor
+		opt := NewOptimizer(factorOptions)
+		if scoreLossOptimizer, ok := opt.(ScoreLossOptimizer); ok {
+			scoreLossOptimizer.SetScoreLossFunc(score_loss_func)
+		}
+		result := opt.OptimizeWeights(ctx, weights, derivative_func, set)
+		if result.Canceled {
+			break
+		}
+
+		point := RegularizationPathPoint{
+			Factor:      factor,
+			Weights:     weights.Populate(),
+			Result:      result,
+			NumNonZeros: countNonZeros(weights),
+		}
+		point.Weights.DeepCopy(weights)
+		path = append(path, point)
+	}
+	return path
+}
+
+// 自动生成正则化因子
+//
+// 在w=0处，L1部分的因子不小于 max_k |sum_i dloss_i/dw_lk| / m_k 时零是目标函数的极小值点，
+// 因此 factor_max = max_k |sum_i dloss_i/dw_lk| / (m_k * ratio)，ratio为L1部分所占的比例，
+// m_k为各特征的正则化系数。组套索时分子为第k个特征整组偏导数的长度，ratio为1。
+// 系数为0的特征被忽略，这时零点的偏导数和真实的起点不同，factor_max只是近似值。
+func regularizationPathFactors(options OptimizerOptions, pathOptions RegularizationPathOptions,
+	weights *util.Matrix, derivative_func ComputeInstanceDerivativeFunc, set data.Dataset) []float64 {
+	ratio := float64(0)
+	if options.RegularizationScheme == 1 || options.RegularizationScheme == 4 {
+		ratio = 1
+	} else if options.RegularizationScheme == 3 {
+		ratio = options.ElasticNetRatio
+	}
+	if ratio <= 0 {
+		log.Fatal("自动生成正则化因子需要包含L1部分的正则化或组套索，否则请指定Factors")
+	}
+	numFactors := pathOptions.NumFactors
+	if numFactors == 0 {
+		numFactors = 100
+	}
+	minFactorRatio := pathOptions.MinFactorRatio
+	if minFactorRatio == 0 {
+		minFactorRatio = 1e-3
+	}
+
+	// 计算零权重处损失的偏导数（目标函数的偏导数是样本平均）
+	smoothOptions := options
+	smoothOptions.RegularizationScheme = 0
+	zero := weights.Populate()
+	derivative := weights.Populate()
+	objective := newDatasetObjective(zero, derivative_func, set, smoothOptions, numThreads(options))
+	objective.Evaluate(zero, derivative)
+	maxDerivative := float64(0)
+	for _, k := range featureKeys(derivative) {
+		multiplier := regularizationMultiplier(options, k)
+		if multiplier == 0 {
+			continue
+		}
+		if options.RegularizationScheme == 4 {
+			maxDerivative = math.Max(maxDerivative, groupNorm(derivative, k)/multiplier)
+			continue
+		}
+		for iLabel := 0; iLabel < derivative.NumLabels(); iLabel++ {
+			maxDerivative = math.Max(maxDerivative, math.Abs(derivative.Get(iLabel, k))/multiplier)
+		}
+	}
+	maxFactor := maxDerivative * float64(set.NumInstances()) / ratio
+
+	factors := make([]float64, numFactors)
+	for i := range factors {
+		if numFactors == 1 {
+			factors[i] = maxFactor
+			break
+		}
+		factors[i] = maxFactor * math.Pow(minFactorRatio, float64(i)/float64(numFactors-1))
+	}
+	return factors
+}
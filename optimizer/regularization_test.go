@@ -0,0 +1,74 @@
+package optimizer
+
+import (
+	"context"
+	"github.com/huichen/mlf/util"
+	"math"
+	"testing"
+)
+
+func TestRegularizationMultipliers(t *testing.T) {
+	weights := util.NewMatrix(2, 3)
+	weights.GetValues(0).SetValues([]float64{1, -2, 3})
+	weights.GetValues(1).SetValues([]float64{4, 0, -1})
+	options := OptimizerOptions{
+		RegularizationScheme:          3,
+		RegularizationFactor:          2,
+		ElasticNetRatio:               0.25,
+		RegularizationMultipliers:     map[int]float64{2: 3},
+		ExcludeBiasFromRegularization: true,
+	}
+
+	// L1因子0.5，L2因子1.5，不包含第0个特征，第2个特征的系数为3
+	util.ExpectNear(t, 0.5*(2+0)+1.5*(4+0)/2+3*(0.5*(3+1)+1.5*(9+1)/2),
+		ComputeRegularizationLoss(weights, options), 1e-12)
+
+	reg := ComputeRegularization(weights, options)
+	util.Expect(t, "0", reg.Get(0, 0))
+	util.Expect(t, "0", reg.Get(1, 0))
+	util.ExpectNear(t, -0.5+1.5*-2, reg.Get(0, 1), 1e-12)
+	util.ExpectNear(t, 3*(0.5+1.5*3), reg.Get(0, 2), 1e-12)
+	util.ExpectNear(t, 3*(-0.5+1.5*-1), reg.Get(1, 2), 1e-12)
+}
+
+func TestGroupLasso(t *testing.T) {
+	weights := util.NewSparseMatrix(2)
+	weights.Set(0, 1, 3)
+	weights.Set(1, 1, -4)
+	weights.Set(0, 2, 0.1)
+	options := OptimizerOptions{RegularizationScheme: 4, RegularizationFactor: 2}
+
+	util.ExpectNear(t, 2*(5+0.1), ComputeRegularizationLoss(weights, options), 1e-12)
+	reg := ComputeRegularization(weights, options)
+	util.ExpectNear(t, 2*3/5.0, reg.Get(0, 1), 1e-12)
+	util.ExpectNear(t, 2*-4/5.0, reg.Get(1, 1), 1e-12)
+
+	// 近端更新整组收缩，长度小于步长的组整体置为零
+	ApplyProximalRegularization(weights, nil, options, 0.5)
+	util.ExpectNear(t, 3*0.8, weights.Get(0, 1), 1e-12)
+	util.ExpectNear(t, -4*0.8, weights.Get(1, 1), 1e-12)
+	util.Expect(t, "0", weights.Get(0, 2))
+	util.Expect(t, "1", len(weights.GetValues(0).Keys()))
+}
+
+func TestProximalGd(t *testing.T) {
+	set := newLogisticTestDataset(false)
+	weights := util.NewMatrix(1, 3)
+	NewOptimizer(OptimizerOptions{
+		OptimizerName:                 "gd",
+		LearningRate:                  1,
+		RegularizationScheme:          1,
+		RegularizationFactor:          2,
+		ExcludeBiasFromRegularization: true,
+		ProximalRegularization:        true,
+		MaxIterations:                 500,
+	}).OptimizeWeights(context.Background(), weights, logisticDerivative, set)
+
+	// 噪音特征的权重精确为零，偏置不受正则化影响时满足一阶条件
+	util.Expect(t, "0", weights.Get(0, 2))
+	util.Expect(t, "true", weights.Get(0, 1) > 0.5)
+	objective := newDatasetObjective(weights, logisticDerivative, set, OptimizerOptions{}, 1)
+	derivative := weights.Populate()
+	objective.Evaluate(weights, derivative)
+	util.Expect(t, "true", math.Abs(derivative.Get(0, 0)) < 1e-4)
+}
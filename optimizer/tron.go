@@ -0,0 +1,276 @@
+package optimizer
+
+import (
+	"context"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/util"
+	"log"
+	"math"
+)
+
+// 信赖域牛顿法（trust region Newton method，TRON）优化器
+//
+// 算法见下面的论文，LIBLINEAR的logistic回归使用了同样的方法
+//   Lin, C.-J., Weng, R. C., and Keerthi, S. S. (2008). "Trust Region Newton Method
+//   for Large-Scale Logistic Regression". Journal of Machine Learning Research 9: 627-650.
+//
+// 每次迭代用共轭梯度法在半径为radius的信赖域内近似求解牛顿方程 H * s = -g
+// （截断牛顿法），只需要海森矩阵和向量的乘积，不需要存储海森矩阵。然后比较目标函数
+// 的实际下降和二次模型预测的下降：下降足够时接受这一步，并根据二者的比例调整信赖域
+// 半径。
+//
+// 优化器需要海森矩阵和向量的乘积，使用前必须调用SetHessianVectorFunc。目标函数必须
+// 二阶可导，因此不支持包含L1部分的正则化（L1和弹性网络）和组套索。
+//
+// 和lbfgs一样，optimizer是协程不安全和迭代不安全的，重新开始优化前请调用Clear函数。
+type tronOptimizer struct {
+	options OptimizerOptions
+
+	hessianVectorFunc ComputeInstanceHessianVectorFunc
+
+	// 信赖域半径，以及已经完成的信赖域迭代数
+	radius float64
+	k      int
+
+	// 共轭梯度法的临时变量
+	s, r, d, hd *util.Matrix
+}
+
+// 信赖域迭代的参数，和LIBLINEAR相同
+const (
+	// 实际下降和预测下降的比例大于tronEta0时接受这一步，
+	// tronEta1和tronEta2用于决定缩小还是扩大信赖域
+	tronEta0 = 1e-4
+	tronEta1 = 0.25
+	tronEta2 = 0.75
+
+	// 信赖域半径的缩放系数
+	tronSigma1 = 0.25
+	tronSigma2 = 0.5
+	tronSigma3 = 4
+
+	// 共轭梯度法在残差 |r| <= tronCGTolerance * |g| 时停止
+	tronCGTolerance = 0.1
+)
+
+// 开辟新的tronOptimizer指针
+func NewTronOptimizer(options OptimizerOptions) Optimizer {
+	if l1, _, group := regularizationFactors(options); l1 != 0 || group != 0 {
+		log.Fatal("tron优化器不支持L1正则化和组套索，请使用owlqn或cd优化器")
+	}
+	opt := new(tronOptimizer)
+	opt.options = options
+	return opt
+}
+
+// 设置单个样本的海森矩阵和向量乘积的计算函数
+func (opt *tronOptimizer) SetHessianVectorFunc(hessian_vector_func ComputeInstanceHessianVectorFunc) {
+	opt.hessianVectorFunc = hessian_vector_func
+}
+
+// 清除结构体中保存的数据，以便重复使用结构体
+func (opt *tronOptimizer) Clear() {
+	opt.radius = 0
+	opt.k = 0
+}
+
+// 信赖域方法的步长需要用数据集计算海森矩阵和向量的乘积，只输入x和g时无法计算，
+// 这里返回负梯度方向
+func (opt *tronOptimizer) GetDeltaX(x, g *util.Matrix) *util.Matrix {
+	return g.Opposite()
+}
+
+func (opt *tronOptimizer) OptimizeWeights(ctx context.Context,
+	weights *util.Matrix, derivative_func ComputeInstanceDerivativeFunc, set data.Dataset) OptimizationResult {
+	if opt.hessianVectorFunc == nil {
+		log.Fatal("tron优化器需要海森矩阵和向量乘积的计算函数，请先调用SetHessianVectorFunc")
+	}
+
+	// 目标函数
+	objective := newDatasetObjective(weights, derivative_func, set, opt.options, numThreads(opt.options))
+	objective.hessianVectorFunc = opt.hessianVectorFunc
+
+	derivative := weights.Populate()
+	trialWeights := weights.Populate()
+	trialDerivative := weights.Populate()
+	opt.s = weights.Populate()
+	opt.r = weights.Populate()
+	opt.d = weights.Populate()
+	opt.hd = weights.Populate()
+
+	// 优化循环
+	step := 0
+	checker := newConvergenceChecker(opt.options)
+	es := newEarlyStopping(opt.options, weights)
+
+	// 从检查点恢复，tron不使用学习率，保存它只是为了和其它优化器使用相同的检查点格式
+	state := &optimizationState{
+		weights:       weights,
+		optimizer:     opt,
+		learningRate:  NewLearningRate(opt.options),
+		checker:       checker,
+		earlyStopping: es,
+	}
+	if loadCheckpoint(opt.options, state) {
+		step = state.step
+	}
+
+	log.Print("开始TRON优化")
+	loss := objective.Evaluate(weights, derivative)
+	if opt.k == 0 {
+		opt.radius = derivative.Norm()
+	}
+	canceled := false
+	for {
+		if opt.options.MaxIterations > 0 && step >= opt.options.MaxIterations {
+			break
+		}
+		if ctx.Err() != nil {
+			canceled = true
+			break
+		}
+		derivativeNorm := derivative.Norm()
+		if derivativeNorm == 0 {
+			log.Printf("偏导数为零，停止优化")
+			break
+		}
+		step++
+
+		// 在信赖域内求解牛顿方向，计算试探点
+		cgIterations := opt.trustRegionCG(objective, weights, derivative)
+		trialWeights.WeightedSum(weights, opt.s, 1, 1)
+		newLoss := objective.Evaluate(trialWeights, trialDerivative)
+
+		// 二次模型预测的下降 -(g's + s'Hs/2)，其中 Hs = -g - r
+		gs := util.MatrixDotProduct(derivative, opt.s)
+		predictedReduction := -0.5 * (gs - util.MatrixDotProduct(opt.s, opt.r))
+		actualReduction := loss - newLoss
+
+		// 更新信赖域半径
+		sNorm := opt.s.Norm()
+		if opt.k == 0 {
+			opt.radius = math.Min(opt.radius, sNorm)
+		}
+		alpha := float64(tronSigma3)
+		if newLoss-loss-gs > 0 {
+			alpha = math.Max(tronSigma1, -0.5*gs/(newLoss-loss-gs))
+		}
+		if actualReduction < tronEta0*predictedReduction {
+			opt.radius = math.Min(math.Max(alpha, tronSigma1)*sNorm, tronSigma2*opt.radius)
+		} else if actualReduction < tronEta1*predictedReduction {
+			opt.radius = math.Max(tronSigma1*opt.radius, math.Min(alpha*sNorm, tronSigma2*opt.radius))
+		} else if actualReduction < tronEta2*predictedReduction {
+			opt.radius = math.Max(tronSigma1*opt.radius, math.Min(alpha*sNorm, tronSigma3*opt.radius))
+		} else {
+			opt.radius = math.Max(opt.radius, math.Min(alpha*sNorm, tronSigma3*opt.radius))
+		}
+		opt.k++
+
+		// 下降足够时接受这一步
+		accepted := actualReduction > tronEta0*predictedReduction
+		weightsDeltaRatio := float64(0)
+		if accepted {
+			weights.DeepCopy(trialWeights)
+			derivative.DeepCopy(trialDerivative)
+			loss = newLoss
+			weightsDeltaRatio = sNorm / weights.Norm()
+		}
+		weightsNorm := weights.Norm()
+		derivativeNorm = derivative.Norm()
+		log.Printf("#%d f=%1.6g |g|=%1.3g |dw|/|w|=%f |w|=%f radius=%1.3g cg=%d",
+			step, loss, derivativeNorm, weightsDeltaRatio, weightsNorm, opt.radius, cgIterations)
+
+		// 判断是否溢出
+		if math.IsNaN(weightsNorm) || math.IsNaN(newLoss) {
+			log.Fatal("优化失败：不收敛")
+		}
+
+		reportProgress(opt.options, step, loss, weightsDeltaRatio, opt.radius, weights)
+
+		// 模型无法再预测出下降时停止
+		if predictedReduction <= 0 && actualReduction <= 0 {
+			log.Printf("预测下降不为正，停止优化")
+			break
+		}
+		if math.Abs(actualReduction) <= 1e-12*math.Abs(loss) &&
+			math.Abs(predictedReduction) <= 1e-12*math.Abs(loss) {
+			log.Printf("目标函数的变化过小，停止优化")
+			break
+		}
+
+		// 被拒绝的步不改变权重，不参与收敛判断和提前终止
+		if accepted {
+			if es.update(step, weights) {
+				break
+			}
+			if checker.converged(weightsDeltaRatio, loss, derivativeNorm) {
+				break
+			}
+		}
+
+		state.step = step
+		saveCheckpoint(opt.options, state)
+	}
+
+	result := OptimizationResult{Iterations: step, Loss: loss, Canceled: canceled}
+	es.finish(weights, &result)
+	return result
+}
+
+// 用共轭梯度法在信赖域 |s| <= radius 内近似求解 H * s = -g
+//
+// 结果写入opt.s，残差 r = -g - H * s 写入opt.r，返回共轭梯度法的迭代数。当迭代点
+// 超出信赖域或者遇到非正曲率的方向时，沿当前方向走到信赖域的边界并停止。
+func (opt *tronOptimizer) trustRegionCG(
+	objective *datasetObjective, weights, g *util.Matrix) int {
+	opt.s.Clear()
+	opt.r.DeepCopy(g)
+	opt.r.Scale(-1)
+	opt.d.DeepCopy(opt.r)
+	rTr := util.MatrixDotProduct(opt.r, opt.r)
+	tolerance := tronCGTolerance * g.Norm()
+
+	iterations := 0
+	for math.Sqrt(rTr) > tolerance {
+		iterations++
+		objective.HessianVector(weights, opt.d, opt.hd)
+		dHd := util.MatrixDotProduct(opt.d, opt.hd)
+
+		alpha := float64(0)
+		if dHd > 0 {
+			alpha = rTr / dHd
+			opt.s.Increment(opt.d, alpha)
+		}
+		if dHd <= 0 || opt.s.Norm() > opt.radius {
+			// 退回并沿d走到信赖域边界
+			opt.s.Increment(opt.d, -alpha)
+			alpha = opt.stepToBoundary()
+			opt.s.Increment(opt.d, alpha)
+			opt.r.Increment(opt.hd, -alpha)
+			break
+		}
+
+		opt.r.Increment(opt.hd, -alpha)
+		rnewTrnew := util.MatrixDotProduct(opt.r, opt.r)
+		beta := rnewTrnew / rTr
+		opt.d.Scale(beta)
+		opt.d.Increment(opt.r, 1)
+		rTr = rnewTrnew
+	}
+	return iterations
+}
+
+// 返回tau >= 0，使得 |s + tau * d| = radius
+func (opt *tronOptimizer) stepToBoundary() float64 {
+	std := util.MatrixDotProduct(opt.s, opt.d)
+	sts := util.MatrixDotProduct(opt.s, opt.s)
+	dtd := util.MatrixDotProduct(opt.d, opt.d)
+	dsq := opt.radius * opt.radius
+	rad := math.Sqrt(std*std + dtd*(dsq-sts))
+
+	// 两种写法在数值上等价，选择不会相减抵消的那一种
+	if std >= 0 {
+		return (dsq - sts) / (std + rad)
+	}
+	return (rad - std) / dtd
+}
@@ -0,0 +1,57 @@
+package optimizer
+
+import (
+	"context"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/util"
+	"math"
+	"testing"
+)
+
+// 二分类logistic回归的海森矩阵和向量乘积 p * (1 - p) * (x . v) * x
+func logisticHessianVector(weights *util.Matrix, instance *data.Instance, v, instanceHv *util.Matrix) {
+	features := instance.Features
+	p := 1 / (1 + math.Exp(-util.VecDotProduct(features, weights.GetValues(0))))
+	c := p * (1 - p) * util.VecDotProduct(features, v.GetValues(0))
+
+	vec := instanceHv.GetValues(0)
+	vec.Clear()
+	for _, k := range features.Keys() {
+		vec.Set(k, c*features.Get(k))
+	}
+}
+
+func TestTronOptimizer(t *testing.T) {
+	for _, sparse := range []bool{false, true} {
+		newWeights := func() *util.Matrix {
+			if sparse {
+				return util.NewSparseMatrix(1)
+			}
+			return util.NewMatrix(1, 3)
+		}
+		options := OptimizerOptions{
+			RegularizationScheme:   2,
+			RegularizationFactor:   0.1,
+			ConvergingGradientNorm: 1e-10,
+			MaxIterations:          100,
+		}
+
+		options.OptimizerName = "lbfgs"
+		expected := newWeights()
+		NewOptimizer(options).OptimizeWeights(context.Background(),
+			expected, logisticDerivative, newLogisticTestDataset(sparse))
+
+		options.OptimizerName = "tron"
+		opt := NewOptimizer(options)
+		opt.(HessianVectorOptimizer).SetHessianVectorFunc(logisticHessianVector)
+		weights := newWeights()
+		result := opt.OptimizeWeights(context.Background(),
+			weights, logisticDerivative, newLogisticTestDataset(sparse))
+
+		// 牛顿法只需要很少的迭代，并且和lbfgs收敛到同一个极小值点
+		util.Expect(t, "true", result.Iterations < 20)
+		for k := 0; k < 3; k++ {
+			util.ExpectNear(t, expected.Get(0, k), weights.Get(0, k), 1e-6)
+		}
+	}
+}
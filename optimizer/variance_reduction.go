@@ -0,0 +1,344 @@
+package optimizer
+
+import (
+	"context"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/util"
+	"log"
+	"math"
+)
+
+// 方差缩减（variance reduction）的随机优化器，包括SVRG和SAGA
+//
+// 算法见下面的论文
+//   Johnson, R. and Zhang, T. (2013). "Accelerating Stochastic Gradient Descent using
+//   Predictive Variance Reduction". Advances in Neural Information Processing Systems 26: 315-323.
+//   Defazio, A., Bach, F. and Lacoste-Julien, S. (2014). "SAGA: A Fast Incremental Gradient
+//   Method With Support for Non-Strongly Convex Composite Objectives". Advances in Neural
+//   Information Processing Systems 27: 1646-1654.
+//
+// 和stochastic GD一样每步只计算一个样本的偏导数，但用一个均值为零的修正项抵消随机偏导数的
+// 方差，因此可以使用常数学习率，并在有限数据集上线性收敛。对第i个样本，权重的更新为
+//   w = w - lr * (g_i(w) - g_i' + avg + R'(w) / N)
+// 其中g_i为样本损失的偏导数，R'(w)为正则化项的偏导数：
+//   svrg：g_i'为g_i在快照w~处的值，avg为所有样本在w~处的平均偏导数。每次迭代开始时
+//         快照为当前权重，并用一次遍历计算avg
+//   saga：g_i'为上一次处理第i个样本时保存的偏导数，avg为所有保存的偏导数的平均值，
+//         每步之后更新。需要为每个样本保存偏导数，内存为样本特征的总数 x 标注数
+// 每次迭代为一次遍历，学习率为常数LearningRate（CharacteristicTime被忽略），
+// 通常取 1 / (3 * L)，L为单个样本损失偏导数的Lipschitz常数。
+//
+// avg和正则化项对所有元素都不为零，为了避免每步更新所有权重，更新是惰性的：一个特征只在
+// 它出现在样本中以及每次遍历结束时更新，期间跳过的步数用闭式解一次补上（期间avg不变）。
+// 这要求样本损失的偏导数只在样本出现的特征上不为零（线性模型都满足这一点），并且正则化项
+// 是光滑的，因此只支持不使用正则化或者L2正则化。
+//
+// 优化器保存了各样本的偏导数（saga），因此是协程不安全和迭代不安全的。
+type varianceReducedOptimizer struct {
+	options OptimizerOptions
+
+	// 优化方法，"svrg"或者"saga"
+	method string
+
+	// saga为每个样本保存的偏导数（稀疏矩阵，只包含样本出现的特征）以及它们的平均值，
+	// 第一次优化时用初始权重计算
+	memory  []*util.Matrix
+	average *util.Matrix
+}
+
+// 开辟新的varianceReducedOptimizer指针，OptimizerName必须为"svrg"或者"saga"
+func NewVarianceReducedOptimizer(options OptimizerOptions) Optimizer {
+	opt := new(varianceReducedOptimizer)
+	opt.options = options
+	opt.method = options.OptimizerName
+	if opt.method != "svrg" && opt.method != "saga" {
+		log.Fatal("不支持的方差缩减优化方法", opt.method)
+	}
+	if l1, _, group := regularizationFactors(options); l1 != 0 || group != 0 {
+		log.Fatal(opt.method, "优化器只支持L2正则化，L1正则化和组套索请使用owlqn或cd优化器")
+	}
+	if options.ProximalRegularization {
+		log.Fatal(opt.method, "优化器不支持ProximalRegularization")
+	}
+	if options.LearningRate <= 0 {
+		log.Fatal(opt.method, "优化器需要指定正的LearningRate")
+	}
+	return opt
+}
+
+// 清除结构体中保存的数据，以便重复使用结构体
+func (opt *varianceReducedOptimizer) Clear() {
+	opt.memory = nil
+	opt.average = nil
+}
+
+// 输入x_k和g_k，返回x需要更新的增量 d_k = - g_k
+func (opt *varianceReducedOptimizer) GetDeltaX(x, g *util.Matrix) *util.Matrix {
+	return g.Opposite()
+}
+
+func (opt *varianceReducedOptimizer) OptimizeWeights(ctx context.Context,
+	weights *util.Matrix, derivative_func ComputeInstanceDerivativeFunc, set data.Dataset) OptimizationResult {
+	numInstances := float64(set.NumInstances())
+	learning_rate := opt.options.LearningRate
+	_, l2, _ := regularizationFactors(opt.options)
+
+	// 不含正则化项的平均偏导数，svrg用它计算快照处的avg
+	smoothOptions := opt.options
+	smoothOptions.RegularizationScheme = 0
+	objective := newDatasetObjective(weights, derivative_func, set, smoothOptions, numThreads(opt.options))
+
+	// 优化循环
+	step := 0
+	checker := newConvergenceChecker(opt.options)
+	es := newEarlyStopping(opt.options, weights)
+
+	// 从检查点恢复
+	state := &optimizationState{
+		weights:       weights,
+		optimizer:     opt,
+		learningRate:  NewLearningRate(opt.options),
+		checker:       checker,
+		earlyStopping: es,
+	}
+	if loadCheckpoint(opt.options, state) {
+		step = state.step
+	}
+
+	instanceDerivative := weights.Populate()
+	snapshot := weights.Populate()
+	snapshotDerivative := weights.Populate()
+	derivative := weights.Populate()
+	if opt.method == "svrg" || opt.average == nil {
+		opt.average = weights.Populate()
+	}
+	if opt.method == "saga" {
+		if opt.memory == nil {
+			opt.initMemory(weights, derivative_func, set)
+		} else if len(opt.memory) != set.NumInstances() {
+			log.Fatal("saga保存的偏导数个数和数据集的样本数不一致")
+		}
+	}
+
+	// 各特征上一次更新时的步数，见catchUp
+	lastStep := make(map[int]int)
+	t := 0
+
+	// 补上特征k从上一次更新到第t步之间跳过的更新。跳过的每一步为
+	//   w = w - lr * (avg + l2 * m_k * w / N) = a * w - lr * avg，其中 a = 1 - lr * l2 * m_k / N
+	// n步后 w = a^n * w - lr * avg * (1 - a^n) / (1 - a)
+	catchUp := func(k int) {
+		n := t - lastStep[k]
+		lastStep[k] = t
+		if n == 0 {
+			return
+		}
+		a := 1 - learning_rate*l2*regularizationMultiplier(opt.options, k)/numInstances
+		an := math.Pow(a, float64(n))
+		for iLabel := 0; iLabel < weights.NumLabels(); iLabel++ {
+			w := weights.Get(iLabel, k)
+			avg := opt.average.Get(iLabel, k)
+			if w == 0 && avg == 0 {
+				continue
+			}
+			if a == 1 {
+				weights.Set(iLabel, k, w-learning_rate*avg*float64(n))
+			} else {
+				weights.Set(iLabel, k, an*w-learning_rate*avg*(1-an)/(1-a))
+			}
+		}
+	}
+
+	oldWeights := weights.Populate()
+	oldWeights.DeepCopy(weights)
+	weightsDelta := weights.Populate()
+
+	log.Printf("开始%s优化", opt.method)
+	loss := float64(0)
+	if opt.method == "svrg" {
+		objective.Evaluate(weights, opt.average)
+	}
+	canceled := false
+	iterator := set.CreateIterator()
+	for {
+		if opt.options.MaxIterations > 0 && step >= opt.options.MaxIterations {
+			break
+		}
+		if ctx.Err() != nil {
+			canceled = true
+			break
+		}
+		step++
+
+		if opt.method == "svrg" {
+			snapshot.DeepCopy(weights)
+		}
+
+		// 遍历所有样本，惰性地更新样本中出现的特征
+		epochLoss := float64(0)
+		t = 0
+		for k := range lastStep {
+			delete(lastStep, k)
+		}
+		iterator.Start()
+		for iInstance := 0; !iterator.End(); iInstance++ {
+			if ctx.Err() != nil {
+				canceled = true
+				break
+			}
+			instance := iterator.GetInstance()
+			keys := instance.Features.Keys()
+			for _, k := range keys {
+				catchUp(k)
+			}
+
+			epochLoss += derivative_func(weights, instance, instanceDerivative)
+			var previous *util.Matrix
+			if opt.method == "svrg" {
+				derivative_func(snapshot, instance, snapshotDerivative)
+				previous = snapshotDerivative
+			} else {
+				previous = opt.memory[iInstance]
+			}
+
+			for iLabel := 0; iLabel < weights.NumLabels(); iLabel++ {
+				for _, k := range keys {
+					g := instanceDerivative.Get(iLabel, k)
+					diff := g - previous.Get(iLabel, k)
+					w := weights.Get(iLabel, k)
+					avg := opt.average.Get(iLabel, k)
+					value := diff + avg + l2*regularizationMultiplier(opt.options, k)*w/numInstances
+					if value != 0 {
+						weights.Set(iLabel, k, w-learning_rate*value)
+					}
+					if opt.method == "saga" {
+						if diff != 0 {
+							opt.average.Set(iLabel, k, avg+diff/numInstances)
+						}
+						if g != 0 || previous.Get(iLabel, k) != 0 {
+							previous.Set(iLabel, k, g)
+						}
+					}
+				}
+			}
+
+			// 这一步已经更新了样本中的特征
+			t++
+			for _, k := range keys {
+				lastStep[k] = t
+			}
+			iterator.Next()
+		}
+		if canceled {
+			// 丢弃未完成的遍历，saga保存的偏导数已经改变，因此也被丢弃
+			step--
+			weights.DeepCopy(oldWeights)
+			if opt.method == "saga" {
+				opt.Clear()
+			}
+			break
+		}
+
+		// 补上所有特征跳过的更新
+		for _, k := range featureKeys(weights) {
+			catchUp(k)
+		}
+		for _, k := range featureKeys(opt.average) {
+			catchUp(k)
+		}
+
+		// 目标函数和偏导数：svrg在新的权重处精确计算（同时作为下次迭代的avg），
+		// saga为遍历中各样本损失的平均值和保存的偏导数的平均值
+		smoothLoss := epochLoss / numInstances
+		if opt.method == "svrg" {
+			smoothLoss = objective.Evaluate(weights, opt.average)
+		}
+		loss = smoothLoss + ComputeRegularizationLoss(weights, opt.options)/numInstances
+		derivative.WeightedSum(opt.average, ComputeRegularization(weights, opt.options), 1, 1/numInstances)
+		derivativeNorm := derivative.Norm()
+
+		weightsDelta.WeightedSum(weights, oldWeights, 1, -1)
+		oldWeights.DeepCopy(weights)
+		weightsNorm := weights.Norm()
+		weightsDeltaNorm := weightsDelta.Norm()
+		log.Printf("#%d f=%1.6g |g|=%1.3g |dw|/|w|=%f |w|=%f lr=%1.3g",
+			step, loss, derivativeNorm, weightsDeltaNorm/weightsNorm, weightsNorm, learning_rate)
+
+		// 判断是否溢出
+		if math.IsNaN(weightsNorm) {
+			log.Fatal("优化失败：不收敛")
+		}
+
+		reportProgress(opt.options, step, loss, weightsDeltaNorm/weightsNorm, learning_rate, weights)
+
+		// 先在验证集上评价这次迭代的权重，收敛时最后的权重也参与比较
+		if es.update(step, weights) {
+			break
+		}
+		// 判断是否收敛
+		if checker.converged(weightsDeltaNorm/weightsNorm, loss, derivativeNorm) {
+			break
+		}
+
+		state.step = step
+		saveCheckpoint(opt.options, state)
+	}
+
+	result := OptimizationResult{Iterations: step, Loss: loss, Canceled: canceled}
+	es.finish(weights, &result)
+	return result
+}
+
+// 用weights计算并保存每个样本的偏导数（只保存样本中出现的特征）以及它们的平均值
+func (opt *varianceReducedOptimizer) initMemory(
+	weights *util.Matrix, derivative_func ComputeInstanceDerivativeFunc, set data.Dataset) {
+	numInstances := float64(set.NumInstances())
+	instanceDerivative := weights.Populate()
+	opt.memory = make([]*util.Matrix, 0, set.NumInstances())
+	opt.average.Clear()
+
+	iterator := set.CreateIterator()
+	for iterator.Start(); !iterator.End(); iterator.Next() {
+		instance := iterator.GetInstance()
+		derivative_func(weights, instance, instanceDerivative)
+		memory := util.NewSparseMatrix(weights.NumLabels())
+		for iLabel := 0; iLabel < weights.NumLabels(); iLabel++ {
+			for _, k := range instance.Features.Keys() {
+				if g := instanceDerivative.Get(iLabel, k); g != 0 {
+					memory.Set(iLabel, k, g)
+					opt.average.Set(iLabel, k, opt.average.Get(iLabel, k)+g/numInstances)
+				}
+			}
+		}
+		opt.memory = append(opt.memory, memory)
+	}
+}
+
+// 保存saga的各样本偏导数和平均值，svrg没有需要保存的状态（avg由权重重新计算）
+func (opt *varianceReducedOptimizer) encodeState(e *util.BinaryEncoder) {
+	e.WriteInt(len(opt.memory))
+	for _, memory := range opt.memory {
+		memory.EncodeBinary(e)
+	}
+	if len(opt.memory) > 0 {
+		opt.average.EncodeBinary(e)
+	}
+}
+
+func (opt *varianceReducedOptimizer) decodeState(d *util.BinaryDecoder) {
+	opt.Clear()
+	numInstances := d.ReadLength()
+	if numInstances == 0 || d.Err() != nil {
+		return
+	}
+	opt.memory = make([]*util.Matrix, numInstances)
+	for i := 0; i < numInstances && d.Err() == nil; i++ {
+		opt.memory[i] = new(util.Matrix)
+		opt.memory[i].DecodeBinary(d)
+	}
+	opt.average = new(util.Matrix)
+	opt.average.DecodeBinary(d)
+	if d.Err() != nil {
+		opt.Clear()
+	}
+}
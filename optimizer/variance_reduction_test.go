@@ -0,0 +1,75 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+	"github.com/huichen/mlf/util"
+	"os"
+	"testing"
+)
+
+func TestVarianceReducedOptimizer(t *testing.T) {
+	for _, name := range []string{"svrg", "saga"} {
+		for _, sparse := range []bool{false, true} {
+			set := newLogisticTestDataset(sparse)
+			newWeights := func() *util.Matrix {
+				if sparse {
+					return util.NewSparseMatrix(1)
+				}
+				return util.NewMatrix(1, 3)
+			}
+			options := OptimizerOptions{
+				OptimizerName:                 "lbfgs",
+				RegularizationScheme:          2,
+				RegularizationFactor:          0.5,
+				ExcludeBiasFromRegularization: true,
+			}
+
+			// 和lbfgs的解比较，常数学习率下应收敛到同一个点
+			expected := newWeights()
+			NewOptimizer(options).OptimizeWeights(context.Background(), expected, logisticDerivative, set)
+
+			options.OptimizerName = name
+			options.LearningRate = 0.2
+			options.MaxIterations = 100
+			weights := newWeights()
+			result := NewOptimizer(options).OptimizeWeights(context.Background(), weights, logisticDerivative, set)
+			util.Expect(t, "100", result.Iterations)
+			for k := 0; k < 3; k++ {
+				util.ExpectNear(t, expected.Get(0, k), weights.Get(0, k), 1e-4)
+			}
+		}
+	}
+}
+
+func TestSagaCheckpointResume(t *testing.T) {
+	options := OptimizerOptions{
+		OptimizerName:        "saga",
+		LearningRate:         0.2,
+		RegularizationScheme: 2,
+		RegularizationFactor: 0.1,
+		MaxIterations:        6,
+	}
+	expected := util.NewSparseMatrix(1)
+	NewOptimizer(options).OptimizeWeights(context.Background(),
+		expected, logisticDerivative, newLogisticTestDataset(true))
+
+	// 保存的偏导数写入检查点，继续优化的结果和不中断时相同
+	options.CheckpointPath = "test_checkpoint.mlf"
+	options.MaxIterations = 3
+	weights := util.NewSparseMatrix(1)
+	NewOptimizer(options).OptimizeWeights(context.Background(),
+		weights, logisticDerivative, newLogisticTestDataset(true))
+
+	options.MaxIterations = 6
+	options.ResumeFromCheckpoint = true
+	weights = util.NewSparseMatrix(1)
+	result := NewOptimizer(options).OptimizeWeights(context.Background(),
+		weights, logisticDerivative, newLogisticTestDataset(true))
+	os.Remove("test_checkpoint.mlf")
+
+	util.Expect(t, "6", result.Iterations)
+	for k := 0; k < 3; k++ {
+		util.Expect(t, fmt.Sprint(expected.Get(0, k)), weights.Get(0, k))
+	}
+}
@@ -0,0 +1,81 @@
+package rbm
+
+import (
+	"github.com/huichen/mlf/optimizer"
+	"github.com/huichen/mlf/util"
+	"log"
+	"os"
+)
+
+// 检查点文件的类型，见util.ModelFileMagic
+const checkpointFileType = "rbm_checkpoint"
+
+// 写入检查点，调用时必须持有读锁
+func (rbm *RBM) saveCheckpoint(iteration int, delta float64, oldWeights *util.Matrix,
+	learningRate *optimizer.LearningRate) {
+	if rbm.options.CheckpointPath == "" {
+		return
+	}
+	interval := rbm.options.CheckpointInterval
+	if interval <= 0 {
+		interval = 1
+	}
+	if iteration%interval != 0 {
+		return
+	}
+
+	// 先写入临时文件再改名，避免写入时退出破坏已有的检查点
+	tmpPath := rbm.options.CheckpointPath + ".tmp"
+	w, err := util.CreateModelFile(tmpPath, checkpointFileType, nil)
+	if err != nil {
+		log.Fatal("无法写入", tmpPath, "文件，错误", err)
+	}
+	w.WriteInt(iteration)
+	w.WriteFloat64(delta)
+	rbm.lock.weights.EncodeBinary(w.BinaryEncoder)
+	oldWeights.EncodeBinary(w.BinaryEncoder)
+	learningRate.EncodeBinary(w.BinaryEncoder)
+	if err := w.Close(); err != nil {
+		log.Fatal("无法写入", tmpPath, "文件，错误", err)
+	}
+	if err := os.Rename(tmpPath, rbm.options.CheckpointPath); err != nil {
+		log.Fatal("无法写入", rbm.options.CheckpointPath, "文件，错误", err)
+	}
+}
+
+// 如果ResumeFromCheckpoint为true并且检查点文件存在，从中恢复权重、迭代计数和学习率的状态并返回true
+func (rbm *RBM) loadCheckpoint(iteration *int, delta *float64, oldWeights *util.Matrix,
+	learningRate *optimizer.LearningRate) bool {
+	path := rbm.options.CheckpointPath
+	if !rbm.options.ResumeFromCheckpoint || path == "" {
+		return false
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false
+	}
+
+	r, err := util.OpenModelFile(path)
+	if err != nil {
+		log.Fatal("无法打开", path, "文件，错误", err)
+	}
+	defer r.Close()
+	if r.ModelType != checkpointFileType {
+		log.Fatal(path, "文件不是RBM检查点")
+	}
+
+	*iteration = r.ReadInt()
+	*delta = r.ReadFloat64()
+	weights := new(util.Matrix)
+	weights.DecodeBinary(r.BinaryDecoder)
+	oldWeights.DecodeBinary(r.BinaryDecoder)
+	learningRate.DecodeBinary(r.BinaryDecoder)
+	if r.Err() != nil {
+		log.Fatal("无法解析", path, "文件，错误", r.Err())
+	}
+
+	rbm.lock.Lock()
+	rbm.lock.weights = weights
+	rbm.lock.Unlock()
+	log.Printf("从检查点%s恢复，已完成%d次迭代", path, *iteration)
+	return true
+}
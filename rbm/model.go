@@ -1,62 +1,112 @@
 package rbm
 
 import (
-	"encoding/json"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/supervised"
 	"github.com/huichen/mlf/util"
 	"log"
-	"os"
 )
 
+func init() {
+	supervised.RegisterModel("rbm", func(d *util.BinaryDecoder) supervised.Model {
+		machine := &RBM{}
+		machine.decodeBinary(d)
+		return machine
+	})
+}
+
+// 旧的JSON格式模型文件的结构
 type RBMModel struct {
 	Weights *util.Matrix
 	Options RBMOptions
 }
 
+func (rbm *RBM) GetModelType() string {
+	return "rbm"
+}
+
 func (rbm *RBM) Write(path string) {
 	rbm.lock.RLock()
 	defer rbm.lock.RUnlock()
 
-	model := RBMModel{
-		Weights: rbm.lock.weights,
-		Options: rbm.options,
-	}
+	supervised.WriteModelFile(path, rbm, rbm.encodeBinary)
+}
 
-	response, errMarshal := json.MarshalIndent(model, "", "\t")
-	if errMarshal != nil {
-		log.Fatal("无法转化模型为JSON格式")
-	}
-	f, err := os.Create(path)
-	defer f.Close()
-	if err != nil {
-		log.Fatal("无法写入", path, "文件")
+// 预测样本的输出
+//
+// RBM是非监督式模型，输出的LabelDistribution为各隐藏单元（不含bias项）被激活的概率，
+// Label为激活概率最大的隐藏单元的序号（从0开始）。
+func (rbm *RBM) Predict(instance *data.Instance) data.InstanceOutput {
+	output := data.InstanceOutput{}
+	hidden := rbm.SampleHidden(instance.Features, 0, false)
+
+	output.LabelDistribution = util.NewVector(rbm.options.NumHiddenUnits)
+	for i := 0; i < rbm.options.NumHiddenUnits; i++ {
+		prob := hidden.Get(i + 1)
+		output.LabelDistribution.Set(i, prob)
+		if prob > output.LabelDistribution.Get(output.Label) {
+			output.Label = i
+		}
 	}
-	f.Write(response)
+	return output
 }
 
 func LoadRBM(path string) *RBM {
-	// 最大文件长度10M
-	data := make([]byte, 1024*1024*10)
-
-	f, err := os.Open(path)
-	defer f.Close()
+	r, err := util.OpenModelFile(path)
 	if err != nil {
-		log.Fatal("无法打开", path, "文件")
+		log.Fatal("无法打开", path, "文件，错误", err)
 	}
+	defer r.Close()
 
-	count, errRead := f.Read(data)
-	if errRead != nil {
-		log.Fatal("无法读入", path, "文件")
+	machine := &RBM{}
+	if r.IsJSON {
+		m := new(RBMModel)
+		err = r.DecodeJSON(m)
+		machine.lock.weights = m.Weights
+		machine.options = m.Options
+	} else {
+		if r.ModelType != "" && r.ModelType != machine.GetModelType() {
+			log.Fatal(path, "文件不是RBM模型，模型类型为", r.ModelType)
+		}
+		machine.decodeBinary(r.BinaryDecoder)
+		err = r.Err()
 	}
-
-	m := new(RBMModel)
-	errUnmarshal := json.Unmarshal(data[:count], m)
-	if errUnmarshal != nil {
-		log.Fatal("无法解析", path, "文件，错误", errUnmarshal)
+	if err != nil {
+		log.Fatal("无法解析", path, "文件，错误", err)
 	}
 
-	machine := &RBM{}
-	machine.lock.weights = m.Weights
-	machine.options = m.Options
-
 	return machine
 }
+
+func (rbm *RBM) encodeBinary(e *util.BinaryEncoder) {
+	rbm.options.encodeBinary(e)
+	rbm.lock.weights.EncodeBinary(e)
+}
+
+func (rbm *RBM) decodeBinary(d *util.BinaryDecoder) {
+	rbm.options.decodeBinary(d)
+	rbm.lock.weights = new(util.Matrix)
+	rbm.lock.weights.DecodeBinary(d)
+}
+
+func (options *RBMOptions) encodeBinary(e *util.BinaryEncoder) {
+	e.WriteInt(options.NumHiddenUnits)
+	e.WriteInt(options.NumCD)
+	e.WriteBool(options.UseBinaryHiddenUnits)
+	e.WriteInt(options.Worker)
+	e.WriteFloat64(options.LearningRate)
+	e.WriteInt(options.BatchSize)
+	e.WriteFloat64(options.Delta)
+	e.WriteInt(options.MaxIter)
+}
+
+func (options *RBMOptions) decodeBinary(d *util.BinaryDecoder) {
+	options.NumHiddenUnits = d.ReadInt()
+	options.NumCD = d.ReadInt()
+	options.UseBinaryHiddenUnits = d.ReadBool()
+	options.Worker = d.ReadInt()
+	options.LearningRate = d.ReadFloat64()
+	options.BatchSize = d.ReadInt()
+	options.Delta = d.ReadFloat64()
+	options.MaxIter = d.ReadInt()
+}
@@ -1,5 +1,9 @@
 package rbm
 
+import (
+	"github.com/huichen/mlf/optimizer"
+)
+
 type RBMOptions struct {
 	NumHiddenUnits       int
 	NumCD                int
@@ -10,4 +14,18 @@ type RBMOptions struct {
 	BatchSize    int
 	Delta        float64
 	MaxIter      int
+
+	// 学习率调度，每批样本算一步，plateau调度使用每次遍历的重建误差，
+	// 见optimizer.LearningRateSchedule
+	LearningRateSchedule optimizer.LearningRateSchedule
+
+	// 每次遍历数据后调用的进度回调函数，可以为nil
+	Progress optimizer.ProgressFunc `json:"-"`
+
+	// 检查点：当CheckpointPath不为空时，每CheckpointInterval次遍历（值为0时为每次遍历）
+	// 将权重和迭代计数写入CheckpointPath文件。当ResumeFromCheckpoint为true并且文件存在时，
+	// 训练从检查点继续。注意训练中使用了随机数，因此继续训练的结果和不中断时不完全相同。
+	CheckpointPath       string
+	CheckpointInterval   int
+	ResumeFromCheckpoint bool
 }
@@ -1,7 +1,9 @@
 package rbm
 
 import (
+	"context"
 	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/optimizer"
 	"github.com/huichen/mlf/util"
 	"log"
 	"math"
@@ -28,7 +30,12 @@ func (rbm *RBM) GetOptions() RBMOptions {
 	return rbm.options
 }
 
-func (rbm *RBM) Train(set data.Dataset) {
+// 在数据集上训练RBM
+//
+// 每次遍历数据后调用options.Progress（如果不为nil）汇报进度，其中Loss为可见单元的平均
+// 重建误差。ctx被取消时训练立即结束，权重恢复为最近一次完整遍历后的值。
+// 检查点的使用见RBMOptions.CheckpointPath。
+func (rbm *RBM) Train(ctx context.Context, set data.Dataset) {
 	featureDimension := set.GetOptions().FeatureDimension
 	visibleDim := featureDimension
 	hiddenDim := rbm.options.NumHiddenUnits + 1
@@ -47,37 +54,71 @@ func (rbm *RBM) Train(set data.Dataset) {
 	}
 	rbm.lock.Unlock()
 
-	// 启动工作协程
+	iteration := 0
+	delta := 1.0
+	learningRate := optimizer.NewLearningRate(optimizer.OptimizerOptions{
+		LearningRate:         rbm.options.LearningRate,
+		LearningRateSchedule: rbm.options.LearningRateSchedule,
+	})
+
+	// 从检查点恢复
+	if rbm.loadCheckpoint(&iteration, &delta, oldWeights, learningRate) {
+		if rbm.lock.weights.NumLabels() != hiddenDim || rbm.lock.weights.NumValues() != visibleDim {
+			log.Fatal("检查点中的权重维度和数据不一致")
+		}
+	}
+
+	// 最近一次完整遍历后的权重，训练被取消时恢复
+	completedWeights := util.NewMatrix(hiddenDim, visibleDim)
+	completedWeights.DeepCopy(rbm.lock.weights)
+
+	// 启动工作协程，训练结束时退出
+	workerCtx, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
 	ch := make(chan *data.Instance, rbm.options.Worker)
-	out := make(chan *util.Matrix, rbm.options.Worker)
+	out := make(chan derivativeOutput, rbm.options.Worker)
 	for iWorker := 0; iWorker < rbm.options.Worker; iWorker++ {
-		go rbm.derivativeWorker(ch, out, visibleDim, hiddenDim)
+		go rbm.derivativeWorker(workerCtx, ch, out, visibleDim, hiddenDim)
 	}
 
-	iteration := 0
-	delta := 1.0
 	for (rbm.options.MaxIter == 0 || iteration < rbm.options.MaxIter) &&
 		(rbm.options.Delta == 0 || delta > rbm.options.Delta) {
+		if ctx.Err() != nil {
+			return
+		}
 		iteration++
 
-		go rbm.feeder(set, ch)
+		go rbm.feeder(workerCtx, set, ch)
 		iBatch := 0
 		batchDerivative.Clear()
 		numInstances := set.NumInstances()
+		reconstructionError := 0.0
 		for it := 0; it < numInstances; it++ {
 			// 乱序读入
-			derivative := <-out
-			batchDerivative.Increment(derivative, rbm.options.LearningRate)
+			var output derivativeOutput
+			select {
+			case output = <-out:
+			case <-ctx.Done():
+				// 丢弃未完成的遍历
+				rbm.lock.Lock()
+				rbm.lock.weights.DeepCopy(completedWeights)
+				rbm.lock.Unlock()
+				return
+			}
+			batchDerivative.Increment(output.derivative, 1.0)
+			reconstructionError += output.reconstructionError
 			iBatch++
 
 			if iBatch == rbm.options.BatchSize || it == numInstances-1 {
 				rbm.lock.Lock()
-				rbm.lock.weights.Increment(batchDerivative, 1.0)
+				rbm.lock.weights.Increment(batchDerivative, learningRate.Next())
 				rbm.lock.Unlock()
 				iBatch = 0
 				batchDerivative.Clear()
 			}
 		}
+		reconstructionError /= float64(numInstances)
+		learningRate.ObserveLoss(reconstructionError)
 
 		// 统计delta和|weight|
 		rbm.lock.RLock()
@@ -86,10 +127,23 @@ func (rbm *RBM) Train(set data.Dataset) {
 		batchDerivative.Increment(oldWeights, -1.0)
 		derivativeNorm := batchDerivative.Norm()
 		delta = derivativeNorm / weightsNorm
-		log.Printf("iter = %d, delta = %f, |weight| = %f",
-			iteration, delta, weightsNorm)
+		log.Printf("iter = %d, delta = %f, |weight| = %f, error = %f",
+			iteration, delta, weightsNorm, reconstructionError)
 		oldWeights.DeepCopy(rbm.lock.weights)
+		completedWeights.DeepCopy(rbm.lock.weights)
+		rbm.saveCheckpoint(iteration, delta, oldWeights, learningRate)
 		rbm.lock.RUnlock()
+
+		// 只有本协程修改权重，因此回调函数中可以不加锁地读取权重
+		if rbm.options.Progress != nil {
+			rbm.options.Progress(optimizer.Progress{
+				Iteration:        iteration,
+				Loss:             reconstructionError,
+				DeltaWeightRatio: delta,
+				LearningRate:     learningRate.Current(),
+				Weights:          rbm.lock.weights,
+			})
+		}
 	}
 }
 
@@ -114,7 +168,13 @@ func (rbm *RBM) bernoulli(p float64) float64 {
 	}
 }
 
-func (rbm *RBM) derivativeWorker(ch chan *data.Instance, out chan *util.Matrix,
+// 工作协程输出的单个样本的偏导数和重建误差
+type derivativeOutput struct {
+	derivative          *util.Matrix
+	reconstructionError float64
+}
+
+func (rbm *RBM) derivativeWorker(ctx context.Context, ch chan *data.Instance, out chan derivativeOutput,
 	visibleDim int, hiddenDim int) {
 	// 可见单元
 	visibleUnits := util.NewVector(visibleDim)
@@ -127,7 +187,12 @@ func (rbm *RBM) derivativeWorker(ch chan *data.Instance, out chan *util.Matrix,
 	hiddenUnitsBinary.Set(0, 1.0)
 
 	for {
-		instance := <-ch
+		var instance *data.Instance
+		select {
+		case instance = <-ch:
+		case <-ctx.Done():
+			return
+		}
 		derivative := util.NewMatrix(hiddenDim, visibleDim)
 
 		// 设置 visible units 的初始值
@@ -174,6 +239,13 @@ func (rbm *RBM) derivativeWorker(ch chan *data.Instance, out chan *util.Matrix,
 
 		rbm.lock.RUnlock()
 
+		// 计算重建误差
+		reconstructionError := 0.0
+		for j := 1; j < visibleDim; j++ {
+			diff := visibleUnits.Get(j) - instance.Features.Get(j)
+			reconstructionError += diff * diff
+		}
+
 		// 计算 negative statistics
 		for i := 0; i < hiddenDim; i++ {
 			for j := 0; j < visibleDim; j++ {
@@ -182,16 +254,24 @@ func (rbm *RBM) derivativeWorker(ch chan *data.Instance, out chan *util.Matrix,
 			}
 		}
 
-		out <- derivative
+		select {
+		case out <- derivativeOutput{derivative, reconstructionError}:
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
-func (rbm *RBM) feeder(set data.Dataset, ch chan *data.Instance) {
+func (rbm *RBM) feeder(ctx context.Context, set data.Dataset, ch chan *data.Instance) {
 	iter := set.CreateIterator()
 	iter.Start()
 	for it := 0; it < set.NumInstances(); it++ {
 		instance := iter.GetInstance()
-		ch <- instance
+		select {
+		case ch <- instance:
+		case <-ctx.Done():
+			return
+		}
 		iter.Next()
 	}
 }
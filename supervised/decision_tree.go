@@ -0,0 +1,251 @@
+package supervised
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/dictionary"
+	"github.com/huichen/mlf/util"
+	"math"
+	"strings"
+)
+
+// 决策树的节点
+//
+// 内部节点按第Feature个特征分裂：特征值小于等于Threshold的样本进入Left，大于的进入
+// Right，特征值缺失（NaN）的样本进入MissingLeft指定的一侧。叶子节点的Left和Right为nil。
+// 稀疏特征中没有出现的特征值为0，不是缺失值。
+type DecisionTreeNode struct {
+	Feature     int
+	Threshold   float64
+	MissingLeft bool
+	Left, Right *DecisionTreeNode
+
+	// 节点上的训练样本数（有放回抽样时重复的样本计算多次）和不纯度
+	NumInstances int
+	Impurity     float64
+
+	// 节点上的预测：回归树为样本目标值的平均值，分类树为各分类样本的比例
+	Value        float64
+	Distribution []float64
+}
+
+// 是否是叶子节点
+func (node *DecisionTreeNode) IsLeaf() bool {
+	return node.Left == nil
+}
+
+// 样本所在的子节点
+func (node *DecisionTreeNode) child(features *util.Vector) *DecisionTreeNode {
+	value := features.Get(node.Feature)
+	if math.IsNaN(value) {
+		if node.MissingLeft {
+			return node.Left
+		}
+		return node.Right
+	}
+	if value <= node.Threshold {
+		return node.Left
+	}
+	return node.Right
+}
+
+// 特征为features的样本在子树中所在的叶子节点
+func (node *DecisionTreeNode) Leaf(features *util.Vector) *DecisionTreeNode {
+	for !node.IsLeaf() {
+		node = node.child(features)
+	}
+	return node
+}
+
+// 叶子节点数
+func (node *DecisionTreeNode) NumLeaves() int {
+	if node.IsLeaf() {
+		return 1
+	}
+	return node.Left.NumLeaves() + node.Right.NumLeaves()
+}
+
+// 树的深度，只有根节点时为0
+func (node *DecisionTreeNode) Depth() int {
+	if node.IsLeaf() {
+		return 0
+	}
+	left, right := node.Left.Depth(), node.Right.Depth()
+	if left > right {
+		return left + 1
+	}
+	return right + 1
+}
+
+func (node *DecisionTreeNode) encodeBinary(e *util.BinaryEncoder) {
+	e.WriteBool(node.IsLeaf())
+	e.WriteInt(node.NumInstances)
+	e.WriteFloat64(node.Impurity)
+	e.WriteFloat64(node.Value)
+	e.WriteInt(len(node.Distribution))
+	for _, p := range node.Distribution {
+		e.WriteFloat64(p)
+	}
+	if !node.IsLeaf() {
+		e.WriteInt(node.Feature)
+		e.WriteFloat64(node.Threshold)
+		e.WriteBool(node.MissingLeft)
+		node.Left.encodeBinary(e)
+		node.Right.encodeBinary(e)
+	}
+}
+
+func decodeDecisionTreeNode(d *util.BinaryDecoder) *DecisionTreeNode {
+	node := new(DecisionTreeNode)
+	isLeaf := d.ReadBool()
+	node.NumInstances = d.ReadInt()
+	node.Impurity = d.ReadFloat64()
+	node.Value = d.ReadFloat64()
+	numLabels := d.ReadLength()
+	if d.Err() != nil {
+		return node
+	}
+	if numLabels > 0 {
+		node.Distribution = make([]float64, numLabels)
+		for i := range node.Distribution {
+			node.Distribution[i] = d.ReadFloat64()
+		}
+	}
+	if !isLeaf && d.Err() == nil {
+		node.Feature = d.ReadInt()
+		node.Threshold = d.ReadFloat64()
+		node.MissingLeft = d.ReadBool()
+		node.Left = decodeDecisionTreeNode(d)
+		node.Right = decodeDecisionTreeNode(d)
+	}
+	return node
+}
+
+// 决策树模型（CART）
+//
+// NumLabels为0时为回归树，Predict的结果写入InstanceOutput.Value；否则为分类树，
+// Predict返回叶子节点上比例最高的分类，并将各分类的比例写入LabelDistribution。
+// 树的结构可以从Root开始直接访问，String函数输出可读的文本。
+type DecisionTreeModel struct {
+	NumLabels        int
+	FeatureDimension int
+
+	FeatureDictionary *dictionary.Dictionary
+	LabelDictionary   *dictionary.Dictionary
+
+	Root *DecisionTreeNode
+
+	// 模型的元数据，见MetadataModel
+	Metadata map[string]string
+}
+
+func init() {
+	RegisterModel("decision_tree", func(d *util.BinaryDecoder) Model {
+		model := new(DecisionTreeModel)
+		model.DecodeBinary(d)
+		return model
+	})
+}
+
+func (model *DecisionTreeModel) GetModelType() string {
+	return "decision_tree"
+}
+
+func (model *DecisionTreeModel) GetMetadata() map[string]string {
+	return model.Metadata
+}
+
+func (model *DecisionTreeModel) SetMetadata(metadata map[string]string) {
+	model.Metadata = metadata
+}
+
+func (model *DecisionTreeModel) Write(path string) {
+	WriteModelFile(path, model, model.EncodeBinary)
+}
+
+// 将模型以二进制格式写入编码器
+func (model *DecisionTreeModel) EncodeBinary(e *util.BinaryEncoder) {
+	e.WriteInt(model.NumLabels)
+	e.WriteInt(model.FeatureDimension)
+	encodeDictionary(e, model.FeatureDictionary)
+	encodeDictionary(e, model.LabelDictionary)
+	model.Root.encodeBinary(e)
+}
+
+// 从解码器中读入二进制格式的模型
+func (model *DecisionTreeModel) DecodeBinary(d *util.BinaryDecoder) {
+	model.NumLabels = d.ReadInt()
+	model.FeatureDimension = d.ReadInt()
+	model.FeatureDictionary = decodeDictionary(d)
+	model.LabelDictionary = decodeDictionary(d)
+	model.Root = decodeDecisionTreeNode(d)
+}
+
+// 样本所在的叶子节点，使用NamedFeatures而模型没有特征词典时返回nil
+func (model *DecisionTreeModel) Leaf(instance *data.Instance) *DecisionTreeNode {
+	// 当使用NamedFeatures时转化为Features
+	if !convertNamedFeatures(instance, model.FeatureDictionary) {
+		return nil
+	}
+
+	return model.Root.Leaf(instance.Features)
+}
+
+// 预测样本的输出，回归树的结果写入InstanceOutput.Value
+func (model *DecisionTreeModel) Predict(instance *data.Instance) data.InstanceOutput {
+	output := data.InstanceOutput{}
+	leaf := model.Leaf(instance)
+	if leaf == nil {
+		return output
+	}
+
+	if model.NumLabels == 0 {
+		output.Value = leaf.Value
+		return output
+	}
+	output.LabelDistribution = util.NewVector(model.NumLabels)
+	output.LabelDistribution.SetValues(leaf.Distribution)
+	for iLabel, p := range leaf.Distribution {
+		if p > leaf.Distribution[output.Label] {
+			output.Label = iLabel
+		}
+	}
+	if model.LabelDictionary != nil {
+		output.LabelString = model.LabelDictionary.GetNameFromId(output.Label)
+	}
+	return output
+}
+
+// 输出树的结构，每行一个节点，子节点缩进两格
+func (model *DecisionTreeModel) String() string {
+	var buffer bytes.Buffer
+	model.writeNode(&buffer, model.Root, 0)
+	return buffer.String()
+}
+
+func (model *DecisionTreeModel) writeNode(buffer *bytes.Buffer, node *DecisionTreeNode, depth int) {
+	buffer.WriteString(strings.Repeat("  ", depth))
+	if node.IsLeaf() {
+		if model.NumLabels == 0 {
+			fmt.Fprintf(buffer, "leaf value=%g", node.Value)
+		} else {
+			fmt.Fprintf(buffer, "leaf distribution=%v", node.Distribution)
+		}
+		fmt.Fprintf(buffer, " n=%d\n", node.NumInstances)
+		return
+	}
+
+	feature := fmt.Sprint(node.Feature)
+	if model.FeatureDictionary != nil {
+		feature = model.FeatureDictionary.GetNameFromId(node.Feature)
+	}
+	missing := "right"
+	if node.MissingLeft {
+		missing = "left"
+	}
+	fmt.Fprintf(buffer, "%s <= %g (missing %s) n=%d impurity=%g\n",
+		feature, node.Threshold, missing, node.NumInstances, node.Impurity)
+	model.writeNode(buffer, node.Left, depth+1)
+	model.writeNode(buffer, node.Right, depth+1)
+}
@@ -0,0 +1,201 @@
+package supervised
+
+import (
+	"context"
+	"fmt"
+	"github.com/huichen/mlf/contrib"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/dictionary"
+	"github.com/huichen/mlf/util"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+// 异或：x1和x2同号时为分类1，线性模型无法区分；x3是噪声
+func newXORTestDataset(sparse bool) data.Dataset {
+	r := rand.New(rand.NewSource(1))
+	set := data.NewInmemDataset()
+	for i := 0; i < 400; i++ {
+		x1, x2, x3 := r.Float64()*2-1, r.Float64()*2-1, r.Float64()
+		label := 0
+		if x1*x2 > 0 {
+			label = 1
+		}
+		instance := &data.Instance{Output: &data.InstanceOutput{Label: label}}
+		if sparse {
+			instance.Features = util.NewSparseVector()
+			instance.Features.Set(0, 1)
+			instance.Features.Set(1, x1)
+			instance.Features.Set(2, x2)
+			instance.Features.Set(3, x3)
+		} else {
+			instance.Features = util.NewVector(4)
+			instance.Features.SetValues([]float64{1, x1, x2, x3})
+		}
+		set.AddInstance(instance)
+	}
+	set.Finalize()
+	return set
+}
+
+func TestDecisionTreeClassifier(t *testing.T) {
+	for _, sparse := range []bool{false, true} {
+		for _, criterion := range []string{"gini", "entropy"} {
+			trainer := NewDecisionTreeTrainer(TrainerOptions{
+				Options: DecisionTreeOptions{Criterion: criterion},
+			})
+			model := trainer.Train(context.Background(), newXORTestDataset(sparse)).(*DecisionTreeModel)
+			util.Expect(t, fmt.Sprint(model.Root.NumLeaves()), model.Metadata["num_leaves"])
+			t.Logf("%s深度 = %d，叶子数 = %d", criterion, model.Root.Depth(), model.Root.NumLeaves())
+
+			// 不限制深度时叶子都是纯的
+			for _, x := range [][]float64{{0.5, 0.5}, {-0.5, 0.5}, {0.5, -0.5}, {-0.5, -0.5}} {
+				instance := &data.Instance{Features: util.NewVector(4)}
+				instance.Features.SetValues([]float64{1, x[0], x[1], 0.5})
+				output := model.Predict(instance)
+				label := 0
+				if x[0]*x[1] > 0 {
+					label = 1
+				}
+				util.Expect(t, fmt.Sprint(label), output.Label)
+				util.Expect(t, "1", output.LabelDistribution.Get(label))
+			}
+		}
+	}
+}
+
+func TestDecisionTreeLibSVM(t *testing.T) {
+	set := contrib.LoadLibSVMDataset("../testdata/a1a", true)
+	testSet := contrib.LoadLibSVMDataset("../testdata/a1a.t", true)
+	trainer := NewDecisionTreeTrainer(TrainerOptions{
+		Options: DecisionTreeOptions{MaxDepth: 6, MinSamplesLeaf: 5},
+	})
+	model := trainer.Train(context.Background(), set).(*DecisionTreeModel)
+	accuracy := svmAccuracy(model, testSet)
+	t.Logf("测试集准确度 = %.2f %%", accuracy*100)
+	util.Expect(t, "true", accuracy > 0.8)
+}
+
+func TestDecisionTreeRegressor(t *testing.T) {
+	// y在x1 = 0.3和x1 = 0.7处跳变，x2是噪声
+	r := rand.New(rand.NewSource(1))
+	set := data.NewInmemDataset()
+	for i := 0; i < 300; i++ {
+		x1, x2 := r.Float64(), r.Float64()
+		y := 1.0
+		if x1 > 0.7 {
+			y = 5
+		} else if x1 > 0.3 {
+			y = 3
+		}
+		instance := &data.Instance{
+			Features: util.NewVector(3),
+			Output:   &data.InstanceOutput{Value: y + 0.01*r.NormFloat64()},
+		}
+		instance.Features.SetValues([]float64{1, x1, x2})
+		set.AddInstance(instance)
+	}
+	set.Finalize()
+
+	// 限制叶子样本数后只剩下三段
+	trainer := NewDecisionTreeTrainer(TrainerOptions{
+		Options: DecisionTreeOptions{Criterion: "mse", MinSamplesLeaf: 20, CostComplexityAlpha: 0.01},
+	})
+	model := trainer.Train(context.Background(), set).(*DecisionTreeModel)
+	util.Expect(t, "3", model.Root.NumLeaves())
+	util.Expect(t, "1", model.Root.Feature)
+	for _, x := range [][]float64{{0.1, 1}, {0.5, 3}, {0.9, 5}} {
+		instance := &data.Instance{Features: util.NewVector(3)}
+		instance.Features.SetValues([]float64{1, x[0], 0.5})
+		util.ExpectNear(t, x[1], model.Predict(instance).Value, 0.01)
+	}
+}
+
+func TestDecisionTreePruning(t *testing.T) {
+	set := contrib.LoadLibSVMDataset("../testdata/a1a", true)
+	testSet := contrib.LoadLibSVMDataset("../testdata/a1a.t", true)
+	full := NewDecisionTreeTrainer(TrainerOptions{}).Train(context.Background(), set).(*DecisionTreeModel)
+	fullAccuracy := svmAccuracy(full, testSet)
+
+	// 剪枝后叶子数随alpha单调减少，alpha足够大时只剩根节点
+	leaves := full.Root.NumLeaves()
+	for _, alpha := range []float64{0.0005, 0.002, 1} {
+		trainer := NewDecisionTreeTrainer(TrainerOptions{
+			Options: DecisionTreeOptions{CostComplexityAlpha: alpha},
+		})
+		model := trainer.Train(context.Background(), set).(*DecisionTreeModel)
+		t.Logf("alpha = %g，叶子数 = %d，测试集准确度 = %.2f %%", alpha, model.Root.NumLeaves(),
+			svmAccuracy(model, testSet)*100)
+		util.Expect(t, "true", model.Root.NumLeaves() < leaves)
+		leaves = model.Root.NumLeaves()
+		if alpha < 1 {
+			// 剪枝减少过拟合
+			util.Expect(t, "true", svmAccuracy(model, testSet) > fullAccuracy)
+		}
+	}
+	util.Expect(t, "1", leaves)
+}
+
+func TestDecisionTreeMissingValues(t *testing.T) {
+	// x1缺失的样本都属于分类1
+	r := rand.New(rand.NewSource(1))
+	set := data.NewInmemDataset()
+	for i := 0; i < 200; i++ {
+		x1 := r.Float64()
+		label := 0
+		if x1 > 0.5 || i%4 == 0 {
+			label = 1
+		}
+		if i%4 == 0 {
+			x1 = math.NaN()
+		}
+		instance := &data.Instance{
+			Features: util.NewVector(2),
+			Output:   &data.InstanceOutput{Label: label},
+		}
+		instance.Features.SetValues([]float64{1, x1})
+		set.AddInstance(instance)
+	}
+	set.Finalize()
+
+	model := NewDecisionTreeTrainer(TrainerOptions{}).Train(context.Background(), set).(*DecisionTreeModel)
+	util.Expect(t, "2", model.Root.NumLeaves())
+	util.Expect(t, "false", model.Root.MissingLeft)
+	instance := &data.Instance{Features: util.NewVector(2)}
+	instance.Features.SetValues([]float64{1, math.NaN()})
+	util.Expect(t, "1", model.Predict(instance).Label)
+}
+
+func TestDecisionTreeModel(t *testing.T) {
+	set := newXORTestDataset(true)
+	trainer := NewDecisionTreeTrainer(TrainerOptions{Options: DecisionTreeOptions{MaxDepth: 3}})
+	model := trainer.Train(context.Background(), set).(*DecisionTreeModel)
+	model.Write("test_tree.mlf")
+	loaded := LoadModel("test_tree.mlf").(*DecisionTreeModel)
+	os.Remove("test_tree.mlf")
+
+	util.Expect(t, model.String(), loaded.String())
+	util.Expect(t, "true", strings.HasPrefix(loaded.String(), "1 <= "))
+	util.Expect(t, "3", loaded.Metadata["depth"])
+	iterator := set.CreateIterator()
+	for iterator.Start(); !iterator.End(); iterator.Next() {
+		instance := iterator.GetInstance()
+		util.Expect(t, fmt.Sprint(model.Predict(instance).Label), loaded.Predict(instance).Label)
+	}
+}
+
+func TestConvertNamedFeatures(t *testing.T) {
+	// 词典中没有的特征被忽略，不会写入ID为-1的特征
+	dict := dictionary.NewDictionary(1)
+	dict.GetIdFromName("a")
+	instance := &data.Instance{NamedFeatures: map[string]float64{"a": 2, "unknown": 3}}
+	util.Expect(t, "true", convertNamedFeatures(instance, dict))
+	util.Expect(t, "[0 1]", instance.Features.Keys())
+	util.Expect(t, "2", instance.Features.Get(1))
+
+	util.Expect(t, "false", convertNamedFeatures(&data.Instance{NamedFeatures: map[string]float64{"a": 1}}, nil))
+	util.Expect(t, "true", convertNamedFeatures(&data.Instance{Features: util.NewSparseVector()}, nil))
+}
@@ -0,0 +1,430 @@
+package supervised
+
+import (
+	"context"
+	"github.com/huichen/mlf/data"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+// 决策树的选项，通过TrainerOptions.Options传入，为nil时使用默认值
+type DecisionTreeOptions struct {
+	// 分裂准则，同时决定是分类树还是回归树：
+	//   "gini"（默认）：分类树，不纯度为 1 - sum_k p_k^2
+	//   "entropy"：分类树，不纯度为 -sum_k p_k * log2(p_k)
+	//   "mse"：回归树，不纯度为目标值（InstanceOutput.Value）的方差
+	Criterion string
+
+	// 树的最大深度，值为0时不限制
+	MaxDepth int
+
+	// 叶子节点上最少的样本数，值为0时为1
+	MinSamplesLeaf int
+
+	// 每次分裂时随机选择的候选特征数，值为0时使用所有特征
+	MaxFeatures int
+
+	// 选择候选特征使用的随机数种子
+	Seed int64
+
+	// 代价复杂度剪枝（minimal cost-complexity pruning）的参数alpha，值为0时不剪枝。
+	// 剪掉所有有效alpha不超过该值的子树，alpha越大树越小
+	CostComplexityAlpha float64
+}
+
+// CART决策树训练器
+//
+// 每个节点在所有特征的所有阈值中选择使子节点样本数加权的不纯度之和最小的分裂，
+// 见 Breiman, L. et al. (1984). "Classification and Regression Trees"。
+// 特征值为NaN的样本视为缺失，分裂时分别尝试把缺失样本分到左右两侧，选择不纯度更低
+// 的一侧；训练时没有缺失样本的节点把缺失样本分到样本较多的一侧。
+// TrainerOptions.Optimizer不起作用。
+type DecisionTreeTrainer struct {
+	options DecisionTreeOptions
+}
+
+// 创建一个决策树训练器
+func NewDecisionTreeTrainer(options TrainerOptions) Trainer {
+	trainer := new(DecisionTreeTrainer)
+	if options.Options != nil {
+		treeOptions, ok := options.Options.(DecisionTreeOptions)
+		if !ok {
+			log.Fatal("决策树训练器的Options必须为DecisionTreeOptions")
+		}
+		trainer.options = treeOptions
+	}
+	trainer.options.setDefaults()
+	return trainer
+}
+
+func (o *DecisionTreeOptions) setDefaults() {
+	switch o.Criterion {
+	case "":
+		o.Criterion = "gini"
+	case "gini", "entropy", "mse":
+	default:
+		log.Fatal("不支持的决策树分裂准则", o.Criterion)
+	}
+	if o.MinSamplesLeaf == 0 {
+		o.MinSamplesLeaf = 1
+	}
+}
+
+func (trainer *DecisionTreeTrainer) Train(ctx context.Context, set data.Dataset) Model {
+	if !set.GetOptions().IsSupervisedLearning {
+		log.Fatal("训练数据不是监督式学习数据")
+	}
+
+	builder := newDecisionTreeBuilder(trainer.options, set)
+	model := new(DecisionTreeModel)
+	if trainer.options.Criterion != "mse" {
+		model.NumLabels = set.GetOptions().NumLabels
+		model.LabelDictionary = set.GetLabelDictionary()
+	}
+	model.FeatureDimension = set.GetOptions().FeatureDimension
+	model.FeatureDictionary = set.GetFeatureDictionary()
+	model.Root = builder.build(ctx)
+	model.Metadata = map[string]string{
+		"depth":      strconv.Itoa(model.Root.Depth()),
+		"num_leaves": strconv.Itoa(model.Root.NumLeaves()),
+	}
+	if ctx.Err() != nil {
+		model.Metadata["canceled"] = "true"
+	}
+	return model
+}
+
+// 读入数据集中的所有样本，分类树的分类数为数据集的分类数
+func newDecisionTreeBuilder(options DecisionTreeOptions, set data.Dataset) *decisionTreeBuilder {
+	builder := &decisionTreeBuilder{
+		options:          options,
+		featureDimension: set.GetOptions().FeatureDimension,
+		sparse:           set.GetOptions().FeatureIsSparse,
+	}
+	if options.Criterion != "mse" {
+		builder.numLabels = set.GetOptions().NumLabels
+	}
+	iterator := set.CreateIterator()
+	iterator.Start()
+	for !iterator.End() {
+		instance := iterator.GetInstance()
+		builder.instances = append(builder.instances, instance)
+		if builder.sparse {
+			// 稀疏数据集的FeatureDimension可能为0，使用最大的特征ID
+			for _, k := range instance.Features.Keys() {
+				if k >= builder.featureDimension {
+					builder.featureDimension = k + 1
+				}
+			}
+		}
+		if builder.numLabels == 0 {
+			builder.targets = append(builder.targets, instance.Output.Value)
+		} else {
+			builder.labels = append(builder.labels, instance.Output.Label)
+		}
+		iterator.Next()
+	}
+	builder.rand = rand.New(rand.NewSource(options.Seed))
+	return builder
+}
+
+// 从样本建立一棵CART树
+//
+// 分类树（numLabels > 0）使用labels，回归树使用targets，两者都和instances一一对应；
+// counts为每个样本的重复次数（有放回抽样），为nil时都为1。
+type decisionTreeBuilder struct {
+	options          DecisionTreeOptions
+	numLabels        int
+	featureDimension int
+	sparse           bool
+
+	instances []*data.Instance
+	labels    []int
+	targets   []float64
+	counts    []int
+
+	ctx  context.Context
+	rand *rand.Rand
+}
+
+// 一组样本的统计量，可以增量地加减样本
+type decisionTreeStats struct {
+	n           int
+	sum         float64
+	sumSquares  float64
+	labelCounts []int
+}
+
+type decisionTreeSplit struct {
+	feature     int
+	threshold   float64
+	missingLeft bool
+	cost        float64
+}
+
+func (b *decisionTreeBuilder) build(ctx context.Context) *DecisionTreeNode {
+	b.ctx = ctx
+	indices := []int{}
+	for i := range b.instances {
+		if b.count(i) > 0 {
+			indices = append(indices, i)
+		}
+	}
+	root := b.buildNode(indices, 0)
+	if b.options.CostComplexityAlpha > 0 {
+		pruneDecisionTree(root, b.options.CostComplexityAlpha)
+	}
+	return root
+}
+
+func (b *decisionTreeBuilder) count(i int) int {
+	if b.counts == nil {
+		return 1
+	}
+	return b.counts[i]
+}
+
+func (b *decisionTreeBuilder) newStats() decisionTreeStats {
+	stats := decisionTreeStats{}
+	if b.numLabels > 0 {
+		stats.labelCounts = make([]int, b.numLabels)
+	}
+	return stats
+}
+
+// 将第i个样本乘以sign（+1或-1）加入统计量
+func (b *decisionTreeBuilder) add(stats *decisionTreeStats, i int, sign int) {
+	count := sign * b.count(i)
+	stats.n += count
+	if b.numLabels > 0 {
+		stats.labelCounts[b.labels[i]] += count
+	} else {
+		stats.sum += float64(count) * b.targets[i]
+		stats.sumSquares += float64(count) * b.targets[i] * b.targets[i]
+	}
+}
+
+// stats和extra（可以为nil）合并后的不纯度
+func (b *decisionTreeBuilder) impurity(stats, extra *decisionTreeStats) float64 {
+	n, sum, sumSquares := stats.n, stats.sum, stats.sumSquares
+	if extra != nil {
+		n, sum, sumSquares = n+extra.n, sum+extra.sum, sumSquares+extra.sumSquares
+	}
+	if n == 0 {
+		return 0
+	}
+
+	if b.numLabels == 0 {
+		mean := sum / float64(n)
+		return math.Max(0, sumSquares/float64(n)-mean*mean)
+	}
+	impurity := 0.0
+	if b.options.Criterion == "gini" {
+		impurity = 1
+	}
+	for iLabel, count := range stats.labelCounts {
+		if extra != nil {
+			count += extra.labelCounts[iLabel]
+		}
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(n)
+		if b.options.Criterion == "gini" {
+			impurity -= p * p
+		} else {
+			impurity -= p * math.Log2(p)
+		}
+	}
+	return math.Max(0, impurity)
+}
+
+func (b *decisionTreeBuilder) buildNode(indices []int, depth int) *DecisionTreeNode {
+	stats := b.newStats()
+	for _, i := range indices {
+		b.add(&stats, i, 1)
+	}
+	node := &DecisionTreeNode{
+		NumInstances: stats.n,
+		Impurity:     b.impurity(&stats, nil),
+	}
+	if stats.n > 0 {
+		node.Value = stats.sum / float64(stats.n)
+	}
+	if b.numLabels > 0 {
+		node.Distribution = make([]float64, b.numLabels)
+		for iLabel, count := range stats.labelCounts {
+			if stats.n > 0 {
+				node.Distribution[iLabel] = float64(count) / float64(stats.n)
+			}
+		}
+	}
+
+	if (b.options.MaxDepth > 0 && depth >= b.options.MaxDepth) ||
+		stats.n < 2*b.options.MinSamplesLeaf || node.Impurity <= 1e-12 || b.ctx.Err() != nil {
+		return node
+	}
+	split, ok := b.findSplit(indices, &stats, node.Impurity*float64(stats.n))
+	if !ok {
+		return node
+	}
+
+	node.Feature = split.feature
+	node.Threshold = split.threshold
+	node.MissingLeft = split.missingLeft
+	left, right := []int{}, []int{}
+	for _, i := range indices {
+		value := b.instances[i].Features.Get(split.feature)
+		goLeft := value <= split.threshold
+		if math.IsNaN(value) {
+			goLeft = split.missingLeft
+		}
+		if goLeft {
+			left = append(left, i)
+		} else {
+			right = append(right, i)
+		}
+	}
+	node.Left = b.buildNode(left, depth+1)
+	node.Right = b.buildNode(right, depth+1)
+	return node
+}
+
+// 备选的分裂特征：稠密特征为所有特征，稀疏特征为节点样本中出现过的特征。
+// 设置了MaxFeatures时顺序是随机的
+func (b *decisionTreeBuilder) candidateFeatures(indices []int) []int {
+	features := []int{}
+	if !b.sparse {
+		for k := 0; k < b.featureDimension; k++ {
+			features = append(features, k)
+		}
+	} else {
+		seen := map[int]bool{}
+		for _, i := range indices {
+			for _, k := range b.instances[i].Features.Keys() {
+				if !seen[k] {
+					seen[k] = true
+					features = append(features, k)
+				}
+			}
+		}
+		sort.Ints(features)
+	}
+	if b.options.MaxFeatures > 0 {
+		b.rand.Shuffle(len(features), func(i, j int) { features[i], features[j] = features[j], features[i] })
+	}
+	return features
+}
+
+type decisionTreeValue struct {
+	value float64
+	index int
+}
+
+// 寻找使子节点加权不纯度之和最小且小于parentCost的分裂
+func (b *decisionTreeBuilder) findSplit(indices []int, total *decisionTreeStats,
+	parentCost float64) (best decisionTreeSplit, found bool) {
+	best.cost = parentCost - 1e-12*math.Max(1, parentCost)
+	minLeaf := b.options.MinSamplesLeaf
+
+	// 设置了MaxFeatures时只考虑前MaxFeatures个在节点上不是常数的特征
+	numVisited := 0
+	for _, feature := range b.candidateFeatures(indices) {
+		if b.options.MaxFeatures > 0 && numVisited >= b.options.MaxFeatures {
+			break
+		}
+		values := []decisionTreeValue{}
+		missing := b.newStats()
+		for _, i := range indices {
+			value := b.instances[i].Features.Get(feature)
+			if math.IsNaN(value) {
+				b.add(&missing, i, 1)
+			} else {
+				values = append(values, decisionTreeValue{value, i})
+			}
+		}
+		sort.Slice(values, func(i, j int) bool { return values[i].value < values[j].value })
+		if len(values) < 2 || values[0].value == values[len(values)-1].value {
+			continue
+		}
+		numVisited++
+
+		// 左侧从空开始，右侧从所有非缺失样本开始，逐个把样本从右侧移到左侧
+		left, right := b.newStats(), b.newStats()
+		for _, v := range values {
+			b.add(&right, v.index, 1)
+		}
+		for j := 0; j < len(values)-1; j++ {
+			b.add(&left, values[j].index, 1)
+			b.add(&right, values[j].index, -1)
+			if values[j].value == values[j+1].value {
+				continue
+			}
+			threshold := (values[j].value + values[j+1].value) / 2
+			if threshold == values[j+1].value {
+				threshold = values[j].value
+			}
+
+			// 缺失样本分到左侧
+			if left.n+missing.n >= minLeaf && right.n >= minLeaf {
+				cost := b.impurity(&left, &missing)*float64(left.n+missing.n) +
+					b.impurity(&right, nil)*float64(right.n)
+				if cost < best.cost {
+					best = decisionTreeSplit{feature, threshold, missing.n > 0 || left.n >= right.n, cost}
+					found = true
+				}
+			}
+			// 缺失样本分到右侧
+			if missing.n > 0 && left.n >= minLeaf && right.n+missing.n >= minLeaf {
+				cost := b.impurity(&left, nil)*float64(left.n) +
+					b.impurity(&right, &missing)*float64(right.n+missing.n)
+				if cost < best.cost {
+					best = decisionTreeSplit{feature, threshold, false, cost}
+					found = true
+				}
+			}
+		}
+	}
+	return
+}
+
+// 最小代价复杂度剪枝：反复剪掉有效alpha最小的子树，直到最小的有效alpha大于alpha。
+// 子树T_t的有效alpha为 (R(t) - R(T_t)) / (|T_t| - 1)，其中R为样本数加权的不纯度之和
+// 除以总样本数，|T_t|为子树的叶子数
+func pruneDecisionTree(root *DecisionTreeNode, alpha float64) {
+	total := float64(root.NumInstances)
+	if total == 0 {
+		return
+	}
+	for !root.IsLeaf() {
+		_, _, weakest, weakestAlpha := weakestLink(root, total)
+		if weakestAlpha > alpha {
+			return
+		}
+		weakest.Left, weakest.Right = nil, nil
+	}
+}
+
+// 返回子树的风险R(T_t)、叶子数，以及子树中有效alpha最小的内部节点和它的有效alpha
+func weakestLink(node *DecisionTreeNode, total float64) (
+	risk float64, leaves int, weakest *DecisionTreeNode, weakestAlpha float64) {
+	nodeRisk := node.Impurity * float64(node.NumInstances) / total
+	if node.IsLeaf() {
+		return nodeRisk, 1, nil, math.Inf(1)
+	}
+
+	leftRisk, leftLeaves, leftWeakest, leftAlpha := weakestLink(node.Left, total)
+	rightRisk, rightLeaves, rightWeakest, rightAlpha := weakestLink(node.Right, total)
+	risk, leaves = leftRisk+rightRisk, leftLeaves+rightLeaves
+	weakest, weakestAlpha = node, (nodeRisk-risk)/float64(leaves-1)
+	if leftAlpha < weakestAlpha {
+		weakest, weakestAlpha = leftWeakest, leftAlpha
+	}
+	if rightAlpha < weakestAlpha {
+		weakest, weakestAlpha = rightWeakest, rightAlpha
+	}
+	return
+}
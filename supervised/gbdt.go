@@ -0,0 +1,268 @@
+package supervised
+
+import (
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/dictionary"
+	"github.com/huichen/mlf/util"
+	"math"
+)
+
+// 梯度提升决策树（GBDT）模型
+//
+// 样本在第k个输出上的分数为 BaseScores[k] + sum_r Trees[r][k]中叶子节点的Value，
+// 叶子节点的Value已经乘以学习率。Loss为
+//   "logistic"：二分类，只有一个输出，第1个分类的概率为sigmoid(分数)
+//   "softmax"：多分类，每个分类一个输出，概率为各分数的softmax
+//   "squared"：回归，只有一个输出，结果写入InstanceOutput.Value
+// 树的节点中Impurity为节点的二阶目标函数值 -G^2 / (2 * (H + lambda))除以NumInstances，
+// 其中G和H为节点上样本的一阶和二阶导数之和。
+type GBDTModel struct {
+	Loss             string
+	NumLabels        int
+	FeatureDimension int
+
+	FeatureDictionary *dictionary.Dictionary
+	LabelDictionary   *dictionary.Dictionary
+
+	// 每个输出的初始分数
+	BaseScores []float64
+
+	// 每轮提升的树，每个输出一棵
+	Trees [][]*DecisionTreeNode
+
+	// 模型的元数据，见MetadataModel
+	Metadata map[string]string
+
+	// 每个叶子节点在所有树的叶子中的序号，以及每棵树第一个叶子的序号，见LeafFeatures
+	leafIndices map[*DecisionTreeNode]int
+	treeOffsets []int
+}
+
+func init() {
+	RegisterModel("gbdt", func(d *util.BinaryDecoder) Model {
+		model := new(GBDTModel)
+		model.DecodeBinary(d)
+		return model
+	})
+}
+
+func (model *GBDTModel) GetModelType() string {
+	return "gbdt"
+}
+
+func (model *GBDTModel) GetMetadata() map[string]string {
+	return model.Metadata
+}
+
+func (model *GBDTModel) SetMetadata(metadata map[string]string) {
+	model.Metadata = metadata
+}
+
+func (model *GBDTModel) Write(path string) {
+	WriteModelFile(path, model, model.EncodeBinary)
+}
+
+// 将模型以二进制格式写入编码器
+func (model *GBDTModel) EncodeBinary(e *util.BinaryEncoder) {
+	e.WriteString(model.Loss)
+	e.WriteInt(model.NumLabels)
+	e.WriteInt(model.FeatureDimension)
+	encodeDictionary(e, model.FeatureDictionary)
+	encodeDictionary(e, model.LabelDictionary)
+	e.WriteInt(len(model.BaseScores))
+	for _, score := range model.BaseScores {
+		e.WriteFloat64(score)
+	}
+	e.WriteInt(len(model.Trees))
+	for _, trees := range model.Trees {
+		for _, tree := range trees {
+			tree.encodeBinary(e)
+		}
+	}
+}
+
+// 从解码器中读入二进制格式的模型
+func (model *GBDTModel) DecodeBinary(d *util.BinaryDecoder) {
+	model.Loss = d.ReadString()
+	model.NumLabels = d.ReadInt()
+	model.FeatureDimension = d.ReadInt()
+	model.FeatureDictionary = decodeDictionary(d)
+	model.LabelDictionary = decodeDictionary(d)
+	numOutputs := d.ReadLength()
+	if d.Err() != nil {
+		return
+	}
+	model.BaseScores = make([]float64, numOutputs)
+	for i := range model.BaseScores {
+		model.BaseScores[i] = d.ReadFloat64()
+	}
+	numRounds := d.ReadLength()
+	model.Trees = nil
+	for r := 0; r < numRounds && d.Err() == nil; r++ {
+		trees := make([]*DecisionTreeNode, numOutputs)
+		for k := range trees {
+			trees[k] = decodeDecisionTreeNode(d)
+		}
+		model.Trees = append(model.Trees, trees)
+	}
+	model.indexLeaves()
+}
+
+// 给所有叶子节点编号，训练和载入模型后调用
+func (model *GBDTModel) indexLeaves() {
+	model.leafIndices = map[*DecisionTreeNode]int{}
+	model.treeOffsets = nil
+	for _, trees := range model.Trees {
+		for _, tree := range trees {
+			model.treeOffsets = append(model.treeOffsets, len(model.leafIndices))
+			indexLeaves(tree, model.leafIndices)
+		}
+	}
+}
+
+func indexLeaves(node *DecisionTreeNode, leafIndices map[*DecisionTreeNode]int) {
+	if node.IsLeaf() {
+		leafIndices[node] = len(leafIndices)
+		return
+	}
+	indexLeaves(node.Left, leafIndices)
+	indexLeaves(node.Right, leafIndices)
+}
+
+// 样本在各输出上的分数
+func (model *GBDTModel) scores(features *util.Vector) []float64 {
+	scores := make([]float64, len(model.BaseScores))
+	copy(scores, model.BaseScores)
+	for _, trees := range model.Trees {
+		for k, tree := range trees {
+			scores[k] += tree.Leaf(features).Value
+		}
+	}
+	return scores
+}
+
+func (model *GBDTModel) Predict(instance *data.Instance) data.InstanceOutput {
+	output := data.InstanceOutput{}
+	if !convertNamedFeatures(instance, model.FeatureDictionary) {
+		return output
+	}
+	scores := model.scores(instance.Features)
+
+	switch model.Loss {
+	case "squared":
+		output.Value = scores[0]
+		return output
+	case "logistic":
+		p := 1 / (1 + math.Exp(-scores[0]))
+		output.LabelDistribution = util.NewVector(2)
+		output.LabelDistribution.SetValues([]float64{1 - p, p})
+		if p > 0.5 {
+			output.Label = 1
+		}
+	default:
+		probabilities := softmax(scores)
+		output.LabelDistribution = util.NewVector(model.NumLabels)
+		output.LabelDistribution.SetValues(probabilities)
+		for iLabel, p := range probabilities {
+			if p > probabilities[output.Label] {
+				output.Label = iLabel
+			}
+		}
+	}
+	if model.LabelDictionary != nil {
+		output.LabelString = model.LabelDictionary.GetNameFromId(output.Label)
+	}
+	return output
+}
+
+func softmax(scores []float64) []float64 {
+	max := math.Inf(-1)
+	for _, score := range scores {
+		max = math.Max(max, score)
+	}
+	probabilities := make([]float64, len(scores))
+	sum := 0.0
+	for k, score := range scores {
+		probabilities[k] = math.Exp(score - max)
+		sum += probabilities[k]
+	}
+	for k := range probabilities {
+		probabilities[k] /= sum
+	}
+	return probabilities
+}
+
+// 样本在每棵树（按Trees中的顺序）中所在叶子节点的序号，叶子按从左到右编号
+func (model *GBDTModel) LeafIndices(instance *data.Instance) []int {
+	if !convertNamedFeatures(instance, model.FeatureDictionary) {
+		return nil
+	}
+	indices := []int{}
+	for _, trees := range model.Trees {
+		for _, tree := range trees {
+			leaf := tree.Leaf(instance.Features)
+			indices = append(indices, model.leafIndices[leaf]-model.treeOffsets[len(indices)])
+		}
+	}
+	return indices
+}
+
+// 所有树的叶子节点总数，即LeafFeatures的维度减一
+func (model *GBDTModel) NumLeaves() int {
+	return len(model.leafIndices)
+}
+
+// 将样本转化为叶子节点特征：稀疏向量，第0个特征为1，样本在每棵树中所在的叶子对应的
+// 特征（1 + 叶子在所有树的叶子中的序号）为1，其它为0。可以作为线性模型的输入（GBDT+LR）
+func (model *GBDTModel) LeafFeatures(instance *data.Instance) *util.Vector {
+	features := util.NewSparseVector()
+	features.Set(0, 1)
+	if !convertNamedFeatures(instance, model.FeatureDictionary) {
+		return features
+	}
+	for _, trees := range model.Trees {
+		for _, tree := range trees {
+			features.Set(1+model.leafIndices[tree.Leaf(instance.Features)], 1)
+		}
+	}
+	return features
+}
+
+// 将数据集中的样本转化为叶子节点特征（见LeafFeatures），样本的输出不变，
+// 得到的数据集可以直接用于训练其它模型，例如NewMaxEntClassifierTrainer
+func (model *GBDTModel) TransformDataset(set data.Dataset) data.Dataset {
+	transformed := data.NewInmemDataset()
+	iterator := set.CreateIterator()
+	iterator.Start()
+	for !iterator.End() {
+		instance := iterator.GetInstance()
+		leafInstance := &data.Instance{Features: model.LeafFeatures(instance)}
+		if instance.Output != nil {
+			output := *instance.Output
+			leafInstance.Output = &output
+		}
+		transformed.AddInstance(leafInstance)
+		iterator.Next()
+	}
+	transformed.Finalize()
+	return transformed
+}
+
+// 基于增益的特征重要性：以该特征分裂的节点上目标函数减少之和，归一化使各特征之和为1。
+// 返回值的键为特征词典中的特征名，没有特征词典时为特征的整数ID。
+func (model *GBDTModel) FeatureImportances() map[string]float64 {
+	importances := map[int]float64{}
+	for _, trees := range model.Trees {
+		for _, tree := range trees {
+			addImpurityDecrease(tree, importances)
+		}
+	}
+	total := 0.0
+	for _, decrease := range importances {
+		total += decrease
+	}
+	for feature := range importances {
+		importances[feature] /= total
+	}
+	return namedFeatureImportances(importances, model.FeatureDictionary)
+}
@@ -0,0 +1,202 @@
+package supervised
+
+import (
+	"context"
+	"fmt"
+	"github.com/huichen/mlf/contrib"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/optimizer"
+	"github.com/huichen/mlf/util"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestBinCuts(t *testing.T) {
+	// 不同的值不超过桶数时每个值一个桶，0也是一个值
+	cuts := binCuts([]float64{3, 1, 3, math.NaN()}, 2, 10)
+	util.Expect(t, "[0.5 2]", cuts)
+
+	// 超过桶数时每个桶的样本数大致相同
+	values := []float64{}
+	for i := 1; i <= 100; i++ {
+		values = append(values, float64(i))
+	}
+	cuts = binCuts(values, 0, 4)
+	util.Expect(t, "[25.5 50.5 75.5]", cuts)
+}
+
+func TestGBDTLogistic(t *testing.T) {
+	set := contrib.LoadLibSVMDataset("../testdata/a1a", true)
+	testSet := contrib.LoadLibSVMDataset("../testdata/a1a.t", true)
+	trainer := NewGBDTTrainer(TrainerOptions{
+		Options: GBDTOptions{NumRounds: 100, MaxDepth: 3, Subsample: 0.8, ColSample: 0.8, Seed: 1},
+	})
+	model := trainer.Train(context.Background(), set).(*GBDTModel)
+	util.Expect(t, "logistic", model.Loss)
+	util.Expect(t, "100", len(model.Trees))
+
+	accuracy := svmAccuracy(model, testSet)
+	t.Logf("测试集准确度 = %.2f %%", accuracy*100)
+	util.Expect(t, "true", accuracy > 0.83)
+
+	total := 0.0
+	for _, importance := range model.FeatureImportances() {
+		total += importance
+	}
+	util.ExpectNear(t, 1, total, 1e-9)
+}
+
+func TestGBDTSoftmax(t *testing.T) {
+	// 三个分类的高斯分布，使用稀疏特征，并有缺失值
+	r := rand.New(rand.NewSource(1))
+	set := data.NewInmemDataset()
+	centers := [][]float64{{0, 3}, {3, 0}, {-3, -3}}
+	for i := 0; i < 300; i++ {
+		label := i % 3
+		instance := &data.Instance{
+			Features: util.NewSparseVector(),
+			Output:   &data.InstanceOutput{Label: label},
+		}
+		instance.Features.Set(0, 1)
+		instance.Features.Set(1, centers[label][0]+r.NormFloat64())
+		instance.Features.Set(2, centers[label][1]+r.NormFloat64())
+		if i%10 == 0 {
+			instance.Features.Set(1, math.NaN())
+		}
+		set.AddInstance(instance)
+	}
+	set.Finalize()
+
+	trainer := NewGBDTTrainer(TrainerOptions{Options: GBDTOptions{NumRounds: 30, MaxDepth: 3}})
+	model := trainer.Train(context.Background(), set).(*GBDTModel)
+	util.Expect(t, "softmax", model.Loss)
+	util.Expect(t, "3", len(model.Trees[0]))
+
+	correct := 0
+	iterator := set.CreateIterator()
+	for iterator.Start(); !iterator.End(); iterator.Next() {
+		instance := iterator.GetInstance()
+		output := model.Predict(instance)
+		util.ExpectNear(t, 1, output.LabelDistribution.Get(0)+output.LabelDistribution.Get(1)+
+			output.LabelDistribution.Get(2), 1e-9)
+		if output.Label == instance.Output.Label {
+			correct++
+		}
+	}
+	t.Logf("训练集准确度 = %.2f %%", float64(correct)/3)
+	util.Expect(t, "true", correct >= 290)
+}
+
+// y = sin(2 * pi * x1)加上噪声，x2是噪声
+func newSinTestDataset(n int, seed int64) data.Dataset {
+	r := rand.New(rand.NewSource(seed))
+	set := data.NewInmemDataset()
+	for i := 0; i < n; i++ {
+		x1, x2 := r.Float64(), r.Float64()
+		instance := &data.Instance{
+			Features: util.NewVector(3),
+			Output:   &data.InstanceOutput{Value: math.Sin(2*math.Pi*x1) + 0.1*r.NormFloat64()},
+		}
+		instance.Features.SetValues([]float64{1, x1, x2})
+		set.AddInstance(instance)
+	}
+	set.Finalize()
+	return set
+}
+
+func TestGBDTSquaredEarlyStopping(t *testing.T) {
+	set := newSinTestDataset(500, 1)
+	validationSet := newSinTestDataset(500, 2)
+	trainer := NewGBDTTrainer(TrainerOptions{
+		Options: GBDTOptions{
+			Loss:                "squared",
+			NumRounds:           1000,
+			LearningRate:        0.3,
+			ValidationSet:       validationSet,
+			EarlyStoppingRounds: 10,
+		},
+	})
+	model := trainer.Train(context.Background(), set).(*GBDTModel)
+
+	// 过拟合后提前终止，模型只保留最佳的轮数
+	iterations, _ := strconv.Atoi(model.Metadata["iterations"])
+	bestIteration, _ := strconv.Atoi(model.Metadata["best_iteration"])
+	validationLoss, _ := strconv.ParseFloat(model.Metadata["validation_loss"], 64)
+	t.Logf("迭代%d轮，最佳迭代#%d，验证集均方误差 = %.4f", iterations, bestIteration, validationLoss)
+	util.Expect(t, "true", iterations < 1000)
+	util.Expect(t, strconv.Itoa(iterations-10), bestIteration)
+	util.Expect(t, strconv.Itoa(bestIteration), len(model.Trees))
+	util.Expect(t, "true", validationLoss < 0.02)
+
+	instance := &data.Instance{Features: util.NewVector(3)}
+	instance.Features.SetValues([]float64{1, 0.25, 0.5})
+	util.ExpectNear(t, 1, model.Predict(instance).Value, 0.15)
+}
+
+func TestGBDTLeafFeatures(t *testing.T) {
+	// GBDT的叶子节点特征作为最大熵分类器的输入
+	set := contrib.LoadLibSVMDataset("../testdata/a1a", true)
+	testSet := contrib.LoadLibSVMDataset("../testdata/a1a.t", true)
+	gbdt := NewGBDTTrainer(TrainerOptions{Options: GBDTOptions{NumRounds: 20, MaxDepth: 3}})
+	model := gbdt.Train(context.Background(), set).(*GBDTModel)
+	util.Expect(t, "true", model.NumLeaves() <= 20*8)
+
+	instance := testSet.CreateIterator().GetInstance()
+	indices := model.LeafIndices(instance)
+	features := model.LeafFeatures(instance)
+	util.Expect(t, "20", len(indices))
+	util.Expect(t, "21", len(features.Keys()))
+
+	maxent := NewMaxEntClassifierTrainer(TrainerOptions{
+		Optimizer: optimizer.OptimizerOptions{
+			OptimizerName:         "lbfgs",
+			RegularizationScheme:  2,
+			RegularizationFactor:  1,
+			ConvergingDeltaWeight: 1e-4,
+			ConvergingSteps:       3,
+		},
+	})
+	stacked := maxent.Train(context.Background(), model.TransformDataset(set))
+	accuracy := svmAccuracy(stacked, model.TransformDataset(testSet))
+	t.Logf("GBDT+MaxEnt测试集准确度 = %.2f %%", accuracy*100)
+	util.Expect(t, "true", accuracy > 0.82)
+}
+
+func TestGBDTModel(t *testing.T) {
+	set := newXORTestDataset(true)
+	trainer := NewGBDTTrainer(TrainerOptions{Options: GBDTOptions{NumRounds: 10, MaxDepth: 2}})
+	model := trainer.Train(context.Background(), set).(*GBDTModel)
+	model.Write("test_gbdt.mlf")
+	loaded := LoadModel("test_gbdt.mlf").(*GBDTModel)
+	os.Remove("test_gbdt.mlf")
+
+	util.Expect(t, "10", len(loaded.Trees))
+	util.Expect(t, strconv.Itoa(model.NumLeaves()), loaded.NumLeaves())
+	iterator := set.CreateIterator()
+	for iterator.Start(); !iterator.End(); iterator.Next() {
+		instance := iterator.GetInstance()
+		util.Expect(t, strconv.Itoa(model.Predict(instance).Label), loaded.Predict(instance).Label)
+		util.Expect(t, fmt.Sprint(model.LeafIndices(instance)), loaded.LeafIndices(instance))
+	}
+}
+
+func TestGBDTProgress(t *testing.T) {
+	// 每轮汇报一次训练集上的平均损失
+	set := newXORTestDataset(false)
+	losses := []float64{}
+	trainer := NewGBDTTrainer(TrainerOptions{
+		Optimizer: optimizer.OptimizerOptions{
+			Progress: func(progress optimizer.Progress) {
+				util.Expect(t, strconv.Itoa(len(losses)+1), progress.Iteration)
+				losses = append(losses, progress.Loss)
+			},
+		},
+		Options: GBDTOptions{NumRounds: 10, MaxDepth: 2},
+	})
+	trainer.Train(context.Background(), set)
+	util.Expect(t, "10", len(losses))
+	util.Expect(t, "true", losses[9] < losses[0])
+}
@@ -0,0 +1,638 @@
+package supervised
+
+import (
+	"context"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/optimizer"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+// 梯度提升决策树的选项，通过TrainerOptions.Options传入，为nil时使用默认值
+type GBDTOptions struct {
+	// 损失函数：
+	//   "logistic"：二分类的对数损失
+	//   "softmax"：多分类的对数损失，每轮为每个分类训练一棵树
+	//   "squared"：回归的平方损失，使用InstanceOutput.Value
+	// 值为空时二分类使用"logistic"，多分类使用"softmax"
+	Loss string
+
+	// 提升的轮数，值为0时为100
+	NumRounds int
+
+	// 学习率（shrinkage），每棵树叶子节点的值乘以学习率，值为0时为0.1
+	LearningRate float64
+
+	// 树的最大深度，值为0时为6
+	MaxDepth int
+
+	// 叶子节点上最少的样本数，值为0时为1
+	MinSamplesLeaf int
+
+	// 叶子节点值的L2正则化系数lambda，值为0时为1
+	Lambda float64
+
+	// 每个特征的直方图最多有多少个桶，值为0时为255
+	MaxBins int
+
+	// 每轮不放回抽样的样本比例和每棵树随机选择的特征比例，值为0时为1（不抽样）
+	Subsample float64
+	ColSample float64
+
+	// 抽样使用的随机数种子
+	Seed int64
+
+	// 提前终止（early stopping）：
+	// 当ValidationSet不为nil时，每轮后计算验证集上的平均损失，连续EarlyStoppingRounds轮
+	// 没有降低时停止训练，值为0时训练NumRounds轮。训练结束后模型只保留到验证集上损失
+	// 最低的那一轮。验证集的样本有LabelString时用训练集的标注词典转化为整数标注。
+	ValidationSet       data.Dataset
+	EarlyStoppingRounds int
+}
+
+// 梯度提升决策树训练器
+//
+// 每轮用损失函数对当前分数的一阶导数g和二阶导数h训练一棵回归树，分裂增益为
+//   G_L^2 / (H_L + lambda) + G_R^2 / (H_R + lambda) - G^2 / (H + lambda)
+// 叶子节点的值为 -G / (H + lambda)，见
+//   Chen, T. and Guestrin, C. (2016). "XGBoost: A Scalable Tree Boosting System".
+//   Proceedings of the 22nd ACM SIGKDD: 785-794.
+// 分裂点在训练前按分位数划分的直方图桶之间选择，每层所有节点的直方图一次遍历数据得到；
+// 稀疏特征只遍历非零元素，值为0的桶由节点的总和减去其它桶得到。特征值为NaN的样本视为
+// 缺失，和DecisionTreeTrainer一样分别尝试分到左右两侧。TrainerOptions.Optimizer中只有
+// Progress起作用，每轮汇报训练集上的平均损失。
+type GBDTTrainer struct {
+	options  GBDTOptions
+	progress optimizer.ProgressFunc
+}
+
+// 创建一个梯度提升决策树训练器
+func NewGBDTTrainer(options TrainerOptions) Trainer {
+	trainer := new(GBDTTrainer)
+	if options.Options != nil {
+		gbdtOptions, ok := options.Options.(GBDTOptions)
+		if !ok {
+			log.Fatal("GBDT训练器的Options必须为GBDTOptions")
+		}
+		trainer.options = gbdtOptions
+	}
+	trainer.progress = options.Optimizer.Progress
+	o := &trainer.options
+	switch o.Loss {
+	case "", "logistic", "softmax", "squared":
+	default:
+		log.Fatal("不支持的GBDT损失函数", o.Loss)
+	}
+	if o.NumRounds == 0 {
+		o.NumRounds = 100
+	}
+	if o.LearningRate == 0 {
+		o.LearningRate = 0.1
+	}
+	if o.MaxDepth == 0 {
+		o.MaxDepth = 6
+	}
+	if o.MinSamplesLeaf == 0 {
+		o.MinSamplesLeaf = 1
+	}
+	if o.Lambda == 0 {
+		o.Lambda = 1
+	}
+	if o.MaxBins == 0 {
+		o.MaxBins = 255
+	}
+	if o.Subsample == 0 {
+		o.Subsample = 1
+	}
+	if o.ColSample == 0 {
+		o.ColSample = 1
+	}
+	return trainer
+}
+
+// 一个特征的直方图桶
+//
+// 第b个桶包含 cuts[b-1] < x <= cuts[b] 的特征值，最后一个桶没有上界，缺失值在第
+// len(cuts) + 1个桶中。entries为特征值不为0的样本和它们所在的桶，值为0的样本在zeroBin中。
+type gbdtFeature struct {
+	id      int
+	cuts    []float64
+	zeroBin int
+	entries []gbdtEntry
+}
+
+type gbdtEntry struct {
+	instance int
+	bin      int
+}
+
+func (feature *gbdtFeature) numBins() int {
+	return len(feature.cuts) + 1
+}
+
+// 一组样本的导数之和和样本数
+type gbdtStats struct {
+	g, h float64
+	n    int
+}
+
+func (s *gbdtStats) add(that gbdtStats) {
+	s.g += that.g
+	s.h += that.h
+	s.n += that.n
+}
+
+func (s gbdtStats) minus(that gbdtStats) gbdtStats {
+	return gbdtStats{s.g - that.g, s.h - that.h, s.n - that.n}
+}
+
+// 正在生长的节点
+type gbdtNode struct {
+	node  *DecisionTreeNode
+	stats gbdtStats
+
+	// 找到的最佳分裂
+	gain        float64
+	left, right gbdtStats
+}
+
+type gbdtSample struct {
+	label  int
+	target float64
+}
+
+func (trainer *GBDTTrainer) Train(ctx context.Context, set data.Dataset) Model {
+	if !set.GetOptions().IsSupervisedLearning {
+		log.Fatal("训练数据不是监督式学习数据")
+	}
+	options := trainer.options
+
+	model := new(GBDTModel)
+	model.Loss = options.Loss
+	model.FeatureDimension = set.GetOptions().FeatureDimension
+	model.FeatureDictionary = set.GetFeatureDictionary()
+	if model.Loss == "" {
+		if set.GetOptions().NumLabels > 2 {
+			model.Loss = "softmax"
+		} else {
+			model.Loss = "logistic"
+		}
+	}
+	if model.Loss != "squared" {
+		model.NumLabels = set.GetOptions().NumLabels
+		model.LabelDictionary = set.GetLabelDictionary()
+	}
+	if model.Loss == "logistic" && model.NumLabels > 2 {
+		log.Fatal("logistic损失只支持二分类")
+	}
+
+	instances := []*data.Instance{}
+	samples := []gbdtSample{}
+	iterator := set.CreateIterator()
+	iterator.Start()
+	for !iterator.End() {
+		instance := iterator.GetInstance()
+		instances = append(instances, instance)
+		samples = append(samples, gbdtSample{instance.Output.Label, instance.Output.Value})
+		iterator.Next()
+	}
+	features := buildGBDTFeatures(instances, options.MaxBins)
+
+	model.BaseScores = baseScores(model, samples)
+	numOutputs := len(model.BaseScores)
+	scores := make([][]float64, len(instances))
+	for i := range scores {
+		scores[i] = make([]float64, numOutputs)
+		copy(scores[i], model.BaseScores)
+	}
+
+	// 验证集
+	validation := newGBDTValidation(model, options.ValidationSet)
+	bestRound, bestLoss, roundsWithoutImprovement := 0, 0.0, 0
+
+	r := rand.New(rand.NewSource(options.Seed))
+	gradients := make([]gbdtStats, len(instances))
+	for round := 1; round <= options.NumRounds; round++ {
+		if ctx.Err() != nil {
+			break
+		}
+
+		// 行抽样和列抽样
+		sampled := make([]bool, len(instances))
+		for i := range sampled {
+			sampled[i] = options.Subsample >= 1 || r.Float64() < options.Subsample
+		}
+
+		trees := make([]*DecisionTreeNode, numOutputs)
+		for k := 0; k < numOutputs; k++ {
+			computeGradients(model.Loss, k, samples, scores, gradients)
+			trees[k] = trainer.buildTree(gradients, sampled, instances, sampleFeatures(features, options.ColSample, r))
+		}
+		for i, instance := range instances {
+			for k, tree := range trees {
+				scores[i][k] += tree.Leaf(instance.Features).Value
+			}
+		}
+		model.Trees = append(model.Trees, trees)
+		if trainer.progress != nil {
+			loss := 0.0
+			for i, sample := range samples {
+				loss += gbdtLoss(model.Loss, scores[i], sample)
+			}
+			trainer.progress(optimizer.Progress{
+				Iteration:    round,
+				Loss:         loss / float64(len(samples)),
+				LearningRate: options.LearningRate,
+			})
+		}
+
+		// 提前终止
+		if validation == nil {
+			continue
+		}
+		loss := validation.update(trees)
+		if bestRound == 0 || loss < bestLoss {
+			bestRound, bestLoss, roundsWithoutImprovement = round, loss, 0
+		} else {
+			roundsWithoutImprovement++
+		}
+		log.Printf("#%d 验证集损失=%f 最佳迭代=#%d", round, loss, bestRound)
+		if options.EarlyStoppingRounds > 0 && roundsWithoutImprovement >= options.EarlyStoppingRounds {
+			log.Printf("验证集损失连续%d轮没有降低，提前终止", roundsWithoutImprovement)
+			break
+		}
+	}
+
+	model.Metadata = map[string]string{"iterations": strconv.Itoa(len(model.Trees))}
+	if bestRound > 0 {
+		model.Trees = model.Trees[:bestRound]
+		model.Metadata["best_iteration"] = strconv.Itoa(bestRound)
+		model.Metadata["validation_loss"] = strconv.FormatFloat(bestLoss, 'g', -1, 64)
+	}
+	if ctx.Err() != nil {
+		model.Metadata["canceled"] = "true"
+	}
+	model.indexLeaves()
+	return model
+}
+
+// 初始分数：logistic为第1个分类比例的对数几率，softmax为各分类比例的对数，squared为平均值
+func baseScores(model *GBDTModel, samples []gbdtSample) []float64 {
+	if model.Loss == "squared" {
+		mean := 0.0
+		for _, sample := range samples {
+			mean += sample.target / float64(len(samples))
+		}
+		return []float64{mean}
+	}
+
+	counts := make([]float64, model.NumLabels)
+	for _, sample := range samples {
+		counts[sample.label]++
+	}
+	for iLabel := range counts {
+		// 平滑，防止没有样本的分类得到无穷大的分数
+		counts[iLabel] = (counts[iLabel] + 1) / float64(len(samples)+model.NumLabels)
+	}
+	if model.Loss == "logistic" {
+		return []float64{math.Log(counts[1] / counts[0])}
+	}
+	scores := make([]float64, model.NumLabels)
+	for iLabel, p := range counts {
+		scores[iLabel] = math.Log(p)
+	}
+	return scores
+}
+
+// 计算第k个输出上每个样本的一阶和二阶导数
+func computeGradients(loss string, k int, samples []gbdtSample, scores [][]float64, gradients []gbdtStats) {
+	for i, sample := range samples {
+		switch loss {
+		case "squared":
+			gradients[i] = gbdtStats{scores[i][0] - sample.target, 1, 1}
+		case "logistic":
+			p := 1 / (1 + math.Exp(-scores[i][0]))
+			gradients[i] = gbdtStats{p - float64(sample.label), math.Max(p*(1-p), 1e-16), 1}
+		default:
+			p := softmax(scores[i])[k]
+			y := 0.0
+			if sample.label == k {
+				y = 1
+			}
+			gradients[i] = gbdtStats{p - y, math.Max(p*(1-p), 1e-16), 1}
+		}
+	}
+}
+
+// 为每个特征划分直方图桶，只有一个桶的特征被丢弃
+func buildGBDTFeatures(instances []*data.Instance, maxBins int) []*gbdtFeature {
+	// 每个特征非零的值
+	values := map[int][]float64{}
+	for _, instance := range instances {
+		for _, k := range instance.Features.Keys() {
+			if value := instance.Features.Get(k); value != 0 {
+				values[k] = append(values[k], value)
+			}
+		}
+	}
+
+	ids := []int{}
+	for k := range values {
+		ids = append(ids, k)
+	}
+	sort.Ints(ids)
+	features := []*gbdtFeature{}
+	featureIndex := map[int]int{}
+	for _, k := range ids {
+		cuts := binCuts(values[k], len(instances)-len(values[k]), maxBins)
+		if len(cuts) == 0 {
+			continue
+		}
+		feature := &gbdtFeature{id: k, cuts: cuts, zeroBin: sort.SearchFloat64s(cuts, 0)}
+		featureIndex[k] = len(features)
+		features = append(features, feature)
+	}
+
+	for i, instance := range instances {
+		for _, k := range instance.Features.Keys() {
+			index, ok := featureIndex[k]
+			value := instance.Features.Get(k)
+			if !ok || value == 0 {
+				continue
+			}
+			feature := features[index]
+			bin := feature.numBins()
+			if !math.IsNaN(value) {
+				bin = sort.SearchFloat64s(feature.cuts, value)
+			}
+			feature.entries = append(feature.entries, gbdtEntry{i, bin})
+		}
+	}
+	return features
+}
+
+// 按分位数划分桶，返回桶之间的分界点。values为非零的特征值，numZeros为值为0的样本数
+func binCuts(values []float64, numZeros int, maxBins int) []float64 {
+	// 不同的特征值和它们出现的次数
+	sorted := []float64{}
+	for _, value := range values {
+		if !math.IsNaN(value) {
+			sorted = append(sorted, value)
+		}
+	}
+	if numZeros > 0 {
+		sorted = append(sorted, 0)
+	}
+	sort.Float64s(sorted)
+	distinct, counts := []float64{}, []int{}
+	for _, value := range sorted {
+		if len(distinct) > 0 && distinct[len(distinct)-1] == value {
+			counts[len(counts)-1]++
+		} else {
+			distinct = append(distinct, value)
+			counts = append(counts, 1)
+		}
+	}
+	if numZeros > 0 {
+		counts[sort.SearchFloat64s(distinct, 0)] += numZeros - 1
+	}
+
+	// 每个桶包含大约相同数目的样本，分界点取相邻两个特征值的中点
+	total := len(sorted)
+	if numZeros > 0 {
+		total += numZeros - 1
+	}
+	cuts := []float64{}
+	cumulative := 0
+	for i := 0; i < len(distinct)-1; i++ {
+		cumulative += counts[i]
+		if len(distinct) <= maxBins || cumulative*maxBins >= (len(cuts)+1)*total {
+			cut := (distinct[i] + distinct[i+1]) / 2
+			if cut == distinct[i+1] {
+				cut = distinct[i]
+			}
+			cuts = append(cuts, cut)
+		}
+	}
+	return cuts
+}
+
+// 随机选择ratio比例的特征
+func sampleFeatures(features []*gbdtFeature, ratio float64, r *rand.Rand) []*gbdtFeature {
+	if ratio >= 1 {
+		return features
+	}
+	n := int(math.Ceil(ratio * float64(len(features))))
+	sampled := make([]*gbdtFeature, len(features))
+	copy(sampled, features)
+	r.Shuffle(len(sampled), func(i, j int) { sampled[i], sampled[j] = sampled[j], sampled[i] })
+	return sampled[:n]
+}
+
+// 逐层生长一棵回归树，每层遍历一次所有特征的非零元素得到该层所有节点的直方图
+func (trainer *GBDTTrainer) buildTree(gradients []gbdtStats, sampled []bool,
+	instances []*data.Instance, features []*gbdtFeature) *DecisionTreeNode {
+	options := trainer.options
+
+	// position[i]为样本i所在的当前层节点，-1表示样本不参与训练
+	position := make([]int, len(instances))
+	root := &gbdtNode{node: new(DecisionTreeNode)}
+	for i := range instances {
+		if sampled[i] {
+			root.stats.add(gradients[i])
+		} else {
+			position[i] = -1
+		}
+	}
+	level := []*gbdtNode{root}
+
+	for depth := 0; len(level) > 0; depth++ {
+		for _, node := range level {
+			trainer.setNodeValue(node.node, node.stats)
+		}
+		if depth >= options.MaxDepth {
+			break
+		}
+		trainer.findSplits(level, position, gradients, features)
+
+		// 分裂节点，得到下一层
+		next := []*gbdtNode{}
+		children := make([][2]int, len(level))
+		for slot, node := range level {
+			children[slot] = [2]int{-1, -1}
+			if node.gain <= 0 {
+				continue
+			}
+			left := &gbdtNode{node: new(DecisionTreeNode), stats: node.left}
+			right := &gbdtNode{node: new(DecisionTreeNode), stats: node.right}
+			node.node.Left, node.node.Right = left.node, right.node
+			children[slot] = [2]int{len(next), len(next) + 1}
+			next = append(next, left, right)
+		}
+		for i, slot := range position {
+			if slot < 0 {
+				continue
+			}
+			node := level[slot].node
+			if node.IsLeaf() {
+				position[i] = -1
+			} else if node.child(instances[i].Features) == node.Left {
+				position[i] = children[slot][0]
+			} else {
+				position[i] = children[slot][1]
+			}
+		}
+		level = next
+	}
+	return root.node
+}
+
+// 叶子节点的值乘以学习率，Impurity见GBDTModel
+func (trainer *GBDTTrainer) setNodeValue(node *DecisionTreeNode, stats gbdtStats) {
+	node.NumInstances = stats.n
+	node.Value = -stats.g / (stats.h + trainer.options.Lambda) * trainer.options.LearningRate
+	if stats.n > 0 {
+		node.Impurity = -trainer.score(stats) / 2 / float64(stats.n)
+	}
+}
+
+func (trainer *GBDTTrainer) score(stats gbdtStats) float64 {
+	return stats.g * stats.g / (stats.h + trainer.options.Lambda)
+}
+
+// 为当前层的每个节点寻找增益最大的分裂，写入节点的gain、left、right和node中
+func (trainer *GBDTTrainer) findSplits(level []*gbdtNode, position []int,
+	gradients []gbdtStats, features []*gbdtFeature) {
+	minLeaf := trainer.options.MinSamplesLeaf
+	for _, node := range level {
+		node.gain = 0
+	}
+
+	histograms := make([][]gbdtStats, len(level))
+	for _, feature := range features {
+		numBins := feature.numBins()
+		for slot := range level {
+			if cap(histograms[slot]) < numBins+1 {
+				histograms[slot] = make([]gbdtStats, numBins+1)
+			}
+			histograms[slot] = histograms[slot][:numBins+1]
+			for b := range histograms[slot] {
+				histograms[slot][b] = gbdtStats{}
+			}
+		}
+		for _, entry := range feature.entries {
+			if slot := position[entry.instance]; slot >= 0 {
+				histograms[slot][entry.bin].add(gradients[entry.instance])
+			}
+		}
+
+		for slot, node := range level {
+			if node.stats.n < 2*minLeaf {
+				continue
+			}
+			histogram := histograms[slot]
+
+			// 值为0的桶为总和减去其它桶
+			zero := node.stats
+			for _, stats := range histogram {
+				zero = zero.minus(stats)
+			}
+			histogram[feature.zeroBin].add(zero)
+			missing := histogram[numBins]
+			nonMissing := node.stats.minus(missing)
+			parentScore := trainer.score(node.stats)
+
+			left := gbdtStats{}
+			for b := 0; b < numBins-1; b++ {
+				left.add(histogram[b])
+				right := nonMissing.minus(left)
+				for _, missingLeft := range []bool{true, false} {
+					l, r := left, right
+					if missingLeft {
+						l.add(missing)
+					} else if missing.n > 0 {
+						r.add(missing)
+					} else {
+						continue
+					}
+					if l.n < minLeaf || r.n < minLeaf {
+						continue
+					}
+					gain := trainer.score(l) + trainer.score(r) - parentScore
+					if gain > node.gain+1e-12 {
+						node.gain, node.left, node.right = gain, l, r
+						node.node.Feature = feature.id
+						node.node.Threshold = feature.cuts[b]
+						node.node.MissingLeft = missingLeft && (missing.n > 0 || left.n >= right.n)
+					}
+				}
+			}
+		}
+	}
+}
+
+// 验证集上的分数，每轮增量更新
+type gbdtValidation struct {
+	model     *GBDTModel
+	instances []*data.Instance
+	samples   []gbdtSample
+	scores    [][]float64
+}
+
+func newGBDTValidation(model *GBDTModel, set data.Dataset) *gbdtValidation {
+	if set == nil {
+		return nil
+	}
+	validation := &gbdtValidation{model: model}
+	iterator := set.CreateIterator()
+	iterator.Start()
+	for !iterator.End() {
+		instance := iterator.GetInstance()
+		label := instance.Output.Label
+		if instance.Output.LabelString != "" && model.LabelDictionary != nil {
+			label = model.LabelDictionary.TranslateIdFromName(instance.Output.LabelString)
+		}
+		if model.Loss != "squared" && (label < 0 || label >= model.NumLabels) {
+			log.Fatal("验证集样本的标注不在训练集中：", instance.Output.LabelString)
+		}
+		validation.instances = append(validation.instances, instance)
+		validation.samples = append(validation.samples, gbdtSample{label, instance.Output.Value})
+		validation.scores = append(validation.scores, append([]float64{}, model.BaseScores...))
+		iterator.Next()
+	}
+	return validation
+}
+
+// 加入一轮的树，返回验证集上的平均损失
+func (validation *gbdtValidation) update(trees []*DecisionTreeNode) float64 {
+	loss := 0.0
+	for i, instance := range validation.instances {
+		if convertNamedFeatures(instance, validation.model.FeatureDictionary) {
+			for k, tree := range trees {
+				validation.scores[i][k] += tree.Leaf(instance.Features).Value
+			}
+		}
+		loss += gbdtLoss(validation.model.Loss, validation.scores[i], validation.samples[i])
+	}
+	return loss / float64(len(validation.instances))
+}
+
+// 分数为scores的样本的损失
+func gbdtLoss(loss string, scores []float64, sample gbdtSample) float64 {
+	switch loss {
+	case "squared":
+		return (scores[0] - sample.target) * (scores[0] - sample.target)
+	case "logistic":
+		// log(1 + exp(-y * s))，y = +1或-1
+		margin := scores[0]
+		if sample.label == 0 {
+			margin = -margin
+		}
+		return math.Max(-margin, 0) + math.Log1p(math.Exp(-math.Abs(margin)))
+	default:
+		return -math.Log(math.Max(softmax(scores)[sample.label], 1e-300))
+	}
+}
@@ -0,0 +1,73 @@
+package supervised
+
+import (
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/dictionary"
+	"github.com/huichen/mlf/util"
+)
+
+// 线性回归模型，预测值为 w . x
+type LinearRegressionModel struct {
+	FeatureDimension  int
+	FeatureDictionary *dictionary.Dictionary
+
+	// 只有一行的权重矩阵
+	Weights *util.Matrix
+
+	// 模型的元数据，见MetadataModel
+	Metadata map[string]string
+}
+
+func init() {
+	RegisterModel("linear_regression", func(d *util.BinaryDecoder) Model {
+		model := new(LinearRegressionModel)
+		model.DecodeBinary(d)
+		return model
+	})
+}
+
+func (model *LinearRegressionModel) GetModelType() string {
+	return "linear_regression"
+}
+
+func (model *LinearRegressionModel) GetMetadata() map[string]string {
+	return model.Metadata
+}
+
+func (model *LinearRegressionModel) SetMetadata(metadata map[string]string) {
+	model.Metadata = metadata
+}
+
+func (model *LinearRegressionModel) Write(path string) {
+	WriteModelFile(path, model, model.EncodeBinary)
+}
+
+// 将模型以二进制格式写入编码器
+func (model *LinearRegressionModel) EncodeBinary(e *util.BinaryEncoder) {
+	e.WriteInt(model.FeatureDimension)
+	encodeDictionary(e, model.FeatureDictionary)
+	model.Weights.EncodeBinary(e)
+}
+
+// 从解码器中读入二进制格式的模型
+func (model *LinearRegressionModel) DecodeBinary(d *util.BinaryDecoder) {
+	model.FeatureDimension = d.ReadInt()
+	model.FeatureDictionary = decodeDictionary(d)
+	model.Weights = new(util.Matrix)
+	model.Weights.DecodeBinary(d)
+}
+
+// 预测样本的输出，结果写入InstanceOutput.Value
+func (model *LinearRegressionModel) Predict(instance *data.Instance) data.InstanceOutput {
+	output := data.InstanceOutput{}
+
+	// 当使用NamedFeatures时转化为Features
+	if !convertNamedFeatures(instance, model.FeatureDictionary) {
+		return output
+	}
+
+	for _, k := range model.Weights.GetValues(0).Keys() {
+		output.Value += model.Weights.Get(0, k) * instance.Features.Get(k)
+	}
+	return output
+}
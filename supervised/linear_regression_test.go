@@ -0,0 +1,122 @@
+package supervised
+
+import (
+	"context"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/optimizer"
+	"github.com/huichen/mlf/util"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// y = 1 + 2 * x1 - 3 * x2 加上噪声，x3和y无关，最后一个样本是离群点
+func newRegressionTestDataset(outlier bool) data.Dataset {
+	r := rand.New(rand.NewSource(1))
+	set := data.NewInmemDataset()
+	for i := 0; i < 200; i++ {
+		x1, x2, x3 := r.Float64(), r.Float64(), r.Float64()
+		instance := &data.Instance{
+			Features: util.NewVector(4),
+			Output:   &data.InstanceOutput{Value: 1 + 2*x1 - 3*x2 + 0.01*r.NormFloat64()},
+		}
+		instance.Features.SetValues([]float64{1, x1, x2, x3})
+		if outlier && i == 0 {
+			instance.Output.Value = 1000
+		}
+		set.AddInstance(instance)
+	}
+	set.Finalize()
+	return set
+}
+
+func TestLinearRegressionGradientCheck(t *testing.T) {
+	weights := util.NewMatrix(1, 3)
+	weights.GetValues(0).SetValues([]float64{0.5, -1, 2})
+	instance := &data.Instance{Features: util.NewVector(3), Output: &data.InstanceOutput{}}
+	instance.Features.SetValues([]float64{1, 0.3, -0.7})
+
+	// 残差分别为-2.1（Huber的线性部分）和-0.1（二次部分）
+	for _, value := range []float64{0, -2} {
+		instance.Output.Value = value
+		for _, loss := range []string{"squared", "huber", "absolute"} {
+			options := LinearRegressionOptions{Loss: loss}
+			result := optimizer.CheckGradient(options.ComputeInstanceDerivative, weights, instance,
+				optimizer.GradientCheckOptions{})
+			util.Expect(t, "true", result.MaxRelativeError < 1e-6)
+		}
+	}
+}
+
+func TestLinearRegressionTrainer(t *testing.T) {
+	set := newRegressionTestDataset(false)
+	for _, name := range []string{"lbfgs", "tron", "cd"} {
+		trainer := NewLinearRegressionTrainer(TrainerOptions{
+			Optimizer: optimizer.OptimizerOptions{
+				OptimizerName:         name,
+				RegularizationScheme:  2,
+				RegularizationFactor:  1e-6,
+				ConvergingDeltaWeight: 1e-8,
+				MaxIterations:         200,
+			},
+		})
+		model := trainer.Train(context.Background(), set).(*LinearRegressionModel)
+		util.ExpectNear(t, 1, model.Weights.Get(0, 0), 0.01)
+		util.ExpectNear(t, 2, model.Weights.Get(0, 1), 0.01)
+		util.ExpectNear(t, -3, model.Weights.Get(0, 2), 0.01)
+		util.ExpectNear(t, 0, model.Weights.Get(0, 3), 0.01)
+	}
+
+	// L1正则化得到精确为零的无关特征权重
+	trainer := NewLinearRegressionTrainer(TrainerOptions{
+		Optimizer: optimizer.OptimizerOptions{
+			OptimizerName:                 "cd",
+			RegularizationScheme:          1,
+			RegularizationFactor:          1,
+			ExcludeBiasFromRegularization: true,
+			ConvergingDeltaWeight:         1e-6,
+			MaxIterations:                 100,
+		},
+	})
+	model := trainer.Train(context.Background(), set).(*LinearRegressionModel)
+	util.Expect(t, "0", model.Weights.Get(0, 3))
+	util.ExpectNear(t, -3, model.Weights.Get(0, 2), 0.1)
+}
+
+func TestLinearRegressionHuber(t *testing.T) {
+	// 离群点使最小二乘的结果偏离很多，Huber损失不受影响
+	set := newRegressionTestDataset(true)
+	options := TrainerOptions{
+		Optimizer: optimizer.OptimizerOptions{
+			OptimizerName:         "lbfgs",
+			ConvergingDeltaWeight: 1e-8,
+			MaxIterations:         500,
+		},
+	}
+	squared := NewLinearRegressionTrainer(options).Train(context.Background(), set).(*LinearRegressionModel)
+	options.Options = LinearRegressionOptions{Loss: "huber", HuberDelta: 0.1}
+	huber := NewLinearRegressionTrainer(options).Train(context.Background(), set).(*LinearRegressionModel)
+
+	util.Expect(t, "true", math.Abs(squared.Weights.Get(0, 1)-2) > 1)
+	util.ExpectNear(t, 1, huber.Weights.Get(0, 0), 0.02)
+	util.ExpectNear(t, 2, huber.Weights.Get(0, 1), 0.02)
+	util.ExpectNear(t, -3, huber.Weights.Get(0, 2), 0.02)
+}
+
+func TestLinearRegressionModel(t *testing.T) {
+	model := new(LinearRegressionModel)
+	model.FeatureDimension = 3
+	model.Weights = util.NewMatrix(1, 3)
+	model.Weights.GetValues(0).SetValues([]float64{1, 2, -3})
+	model.Metadata = map[string]string{"iterations": "5"}
+	model.Write("test_regression.mlf")
+	loaded := LoadModel("test_regression.mlf").(*LinearRegressionModel)
+	os.Remove("test_regression.mlf")
+
+	util.Expect(t, "3", loaded.FeatureDimension)
+	util.Expect(t, "5", loaded.Metadata["iterations"])
+	instance := &data.Instance{Features: util.NewVector(3)}
+	instance.Features.SetValues([]float64{1, 0.5, 1})
+	util.ExpectNear(t, -1, loaded.Predict(instance).Value, 1e-12)
+}
@@ -0,0 +1,166 @@
+package supervised
+
+import (
+	"context"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/optimizer"
+	"github.com/huichen/mlf/util"
+	"log"
+	"math"
+)
+
+// 线性回归的选项，通过TrainerOptions.Options传入，为nil时使用默认值
+//
+// 模型为 y = w . x，记残差 r = w . x - y，各损失函数为
+//   "squared"（默认）：r^2 / 2，即最小二乘
+//   "huber"：|r| <= HuberDelta时为r^2 / 2，否则为 HuberDelta * (|r| - HuberDelta / 2)，
+//            对离群点不敏感
+//   "absolute"：|r|，在r = 0处不可导（使用次梯度），适合gd、hogwild等一阶优化器或者cd，
+//            不能使用tron
+// 正则化（L1、L2、弹性网络等）由TrainerOptions.Optimizer指定，见optimizer.OptimizerOptions。
+type LinearRegressionOptions struct {
+	// 损失函数，见上
+	Loss string
+
+	// Huber损失的阈值，值为0时为1
+	HuberDelta float64
+}
+
+// 线性回归训练器
+type LinearRegressionTrainer struct {
+	options    TrainerOptions
+	regression LinearRegressionOptions
+}
+
+// 创建一个线性回归训练器
+func NewLinearRegressionTrainer(options TrainerOptions) Trainer {
+	trainer := new(LinearRegressionTrainer)
+	trainer.options = options
+	if options.Options != nil {
+		regression, ok := options.Options.(LinearRegressionOptions)
+		if !ok {
+			log.Fatal("线性回归训练器的Options必须为LinearRegressionOptions")
+		}
+		trainer.regression = regression
+	}
+	switch trainer.regression.Loss {
+	case "":
+		trainer.regression.Loss = "squared"
+	case "squared", "huber", "absolute":
+	default:
+		log.Fatal("不支持的回归损失函数", trainer.regression.Loss)
+	}
+	if trainer.regression.HuberDelta == 0 {
+		trainer.regression.HuberDelta = 1
+	}
+	return trainer
+}
+
+func (trainer *LinearRegressionTrainer) Train(ctx context.Context, set data.Dataset) Model {
+	if !set.GetOptions().IsSupervisedLearning {
+		log.Fatal("训练数据不是监督式学习数据")
+	}
+
+	// 建立新的优化器
+	regression := trainer.regression
+	opt := optimizer.NewOptimizer(trainer.options.Optimizer)
+	if hvOptimizer, ok := opt.(optimizer.HessianVectorOptimizer); ok && regression.Loss != "absolute" {
+		hvOptimizer.SetHessianVectorFunc(regression.ComputeInstanceHessianVector)
+	}
+	if scoreLossOptimizer, ok := opt.(optimizer.ScoreLossOptimizer); ok {
+		scoreLossOptimizer.SetScoreLossFunc(regression.ComputeInstanceScoreLoss)
+	}
+
+	// 权重矩阵只有一行
+	featureDimension := set.GetOptions().FeatureDimension
+	var weights *util.Matrix
+	if set.GetOptions().FeatureIsSparse {
+		weights = util.NewSparseMatrix(1)
+	} else {
+		weights = util.NewMatrix(1, featureDimension)
+	}
+
+	result := opt.OptimizeWeights(ctx, weights, regression.ComputeInstanceDerivative, set)
+
+	model := new(LinearRegressionModel)
+	model.Weights = weights
+	model.FeatureDimension = featureDimension
+	model.FeatureDictionary = set.GetFeatureDictionary()
+	model.Metadata = result.Metadata()
+	return model
+}
+
+// 残差为r时的损失，以及损失对r的一阶和二阶导数
+func (o LinearRegressionOptions) residualLoss(r float64) (loss, derivative, curvature float64) {
+	switch o.Loss {
+	case "huber":
+		delta := o.HuberDelta
+		if delta == 0 {
+			delta = 1
+		}
+		if math.Abs(r) <= delta {
+			return r * r / 2, r, 1
+		}
+		return delta * (math.Abs(r) - delta/2), delta * sign(r), 0
+	case "absolute":
+		return math.Abs(r), sign(r), 0
+	}
+	return r * r / 2, r, 1
+}
+
+func sign(x float64) float64 {
+	if x > 0 {
+		return 1
+	} else if x < 0 {
+		return -1
+	}
+	return 0
+}
+
+// 计算线性回归单个样本的偏导数，返回样本的损失
+func (o LinearRegressionOptions) ComputeInstanceDerivative(
+	weights *util.Matrix, instance *data.Instance, instanceDerivative *util.Matrix) float64 {
+	features := instance.Features
+	r := util.VecDotProduct(features, weights.GetValues(0)) - instance.Output.Value
+	loss, derivative, _ := o.residualLoss(r)
+
+	// 偏导数为 derivative * x
+	vec := instanceDerivative.GetValues(0)
+	if vec.IsSparse() {
+		for _, k := range features.Keys() {
+			vec.Set(k, derivative)
+		}
+	} else {
+		vec.SetAll(derivative)
+	}
+	vec.Multiply(1, 0, features)
+	return loss
+}
+
+// 计算线性回归单个样本的海森矩阵和向量v的乘积 curvature * (x . v) * x，用于tron优化器
+func (o LinearRegressionOptions) ComputeInstanceHessianVector(
+	weights *util.Matrix, instance *data.Instance, v, instanceHv *util.Matrix) {
+	features := instance.Features
+	r := util.VecDotProduct(features, weights.GetValues(0)) - instance.Output.Value
+	_, _, curvature := o.residualLoss(r)
+	coefficient := curvature * util.VecDotProduct(features, v.GetValues(0))
+
+	vec := instanceHv.GetValues(0)
+	if vec.IsSparse() {
+		for _, k := range features.Keys() {
+			vec.Set(k, coefficient)
+		}
+	} else {
+		vec.SetAll(coefficient)
+	}
+	vec.Multiply(1, 0, features)
+}
+
+// 用得分 scores[0] = w . x 计算线性回归单个样本的损失，用于cd优化器
+func (o LinearRegressionOptions) ComputeInstanceScoreLoss(
+	scores []float64, instance *data.Instance, derivative, curvature []float64) float64 {
+	loss, d, c := o.residualLoss(scores[0] - instance.Output.Value)
+	derivative[0] = d
+	curvature[0] = c
+	return loss
+}
@@ -0,0 +1,94 @@
+package supervised
+
+import (
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/dictionary"
+	"github.com/huichen/mlf/util"
+)
+
+// 线性SVM模型
+//
+// 每个分类一行权重，第j个分类的决策值为 w_j . x，预测决策值最大的分类。二分类时
+// 第0个分类的权重为第1个分类的相反数。
+type LinearSVMModel struct {
+	NumLabels        int
+	FeatureDimension int
+
+	FeatureDictionary *dictionary.Dictionary
+	LabelDictionary   *dictionary.Dictionary
+
+	Weights *util.Matrix
+
+	// 模型的元数据，见MetadataModel
+	Metadata map[string]string
+}
+
+func init() {
+	RegisterModel("linear_svm", func(d *util.BinaryDecoder) Model {
+		model := new(LinearSVMModel)
+		model.DecodeBinary(d)
+		return model
+	})
+}
+
+func (model *LinearSVMModel) GetModelType() string {
+	return "linear_svm"
+}
+
+func (model *LinearSVMModel) GetMetadata() map[string]string {
+	return model.Metadata
+}
+
+func (model *LinearSVMModel) SetMetadata(metadata map[string]string) {
+	model.Metadata = metadata
+}
+
+func (model *LinearSVMModel) Write(path string) {
+	WriteModelFile(path, model, model.EncodeBinary)
+}
+
+// 将模型以二进制格式写入编码器
+func (model *LinearSVMModel) EncodeBinary(e *util.BinaryEncoder) {
+	e.WriteInt(model.NumLabels)
+	e.WriteInt(model.FeatureDimension)
+	encodeDictionary(e, model.FeatureDictionary)
+	encodeDictionary(e, model.LabelDictionary)
+	model.Weights.EncodeBinary(e)
+}
+
+// 从解码器中读入二进制格式的模型
+func (model *LinearSVMModel) DecodeBinary(d *util.BinaryDecoder) {
+	model.NumLabels = d.ReadInt()
+	model.FeatureDimension = d.ReadInt()
+	model.FeatureDictionary = decodeDictionary(d)
+	model.LabelDictionary = decodeDictionary(d)
+	model.Weights = new(util.Matrix)
+	model.Weights.DecodeBinary(d)
+}
+
+// 预测样本的分类，各分类的决策值写入InstanceOutput.DecisionValues
+func (model *LinearSVMModel) Predict(instance *data.Instance) data.InstanceOutput {
+	output := data.InstanceOutput{}
+
+	// 当使用NamedFeatures时转化为Features
+	if !convertNamedFeatures(instance, model.FeatureDictionary) {
+		return output
+	}
+
+	output.DecisionValues = util.NewVector(model.NumLabels)
+	for iLabel := 0; iLabel < model.NumLabels; iLabel++ {
+		sum := float64(0)
+		for _, k := range model.Weights.GetValues(iLabel).Keys() {
+			sum += model.Weights.Get(iLabel, k) * instance.Features.Get(k)
+		}
+		output.DecisionValues.Set(iLabel, sum)
+		if sum > output.DecisionValues.Get(output.Label) {
+			output.Label = iLabel
+		}
+	}
+
+	if model.LabelDictionary != nil {
+		output.LabelString = model.LabelDictionary.GetNameFromId(output.Label)
+	}
+	return output
+}
@@ -0,0 +1,163 @@
+package supervised
+
+import (
+	"context"
+	"github.com/huichen/mlf/contrib"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/optimizer"
+	"github.com/huichen/mlf/util"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// 用LabelString比较，训练集和测试集的标注词典可能不同
+func svmAccuracy(model Model, set data.Dataset) float64 {
+	correct := 0
+	iterator := set.CreateIterator()
+	iterator.Start()
+	for !iterator.End() {
+		instance := iterator.GetInstance()
+		if model.Predict(instance).LabelString == instance.Output.LabelString {
+			correct++
+		}
+		iterator.Next()
+	}
+	return float64(correct) / float64(set.NumInstances())
+}
+
+func TestLinearSVMBinary(t *testing.T) {
+	set := contrib.LoadLibSVMDataset("../testdata/a1a", false)
+	testSet := contrib.LoadLibSVMDataset("../testdata/a1a.t", false)
+	for _, loss := range []string{"hinge", "squared_hinge"} {
+		trainer := NewLinearSVMTrainer(TrainerOptions{Options: LinearSVMOptions{Loss: loss, C: 0.1}})
+		model := trainer.Train(context.Background(), set).(*LinearSVMModel)
+
+		accuracy := svmAccuracy(model, testSet)
+		t.Logf("%s测试集准确度 = %.2f %%", loss, accuracy*100)
+		util.Expect(t, "true", accuracy > 0.83)
+
+		// 两个分类的决策值互为相反数
+		output := model.Predict(testSet.CreateIterator().GetInstance())
+		util.ExpectNear(t, 0, output.DecisionValues.Get(0)+output.DecisionValues.Get(1), 1e-12)
+	}
+}
+
+// max(0, 1 - y * w . x)^2，w为第1行，y = +1或-1
+func squaredHingeDerivative(weights *util.Matrix, instance *data.Instance, instanceDerivative *util.Matrix) float64 {
+	y := float64(2*instance.Output.Label - 1)
+	margin := 1 - y*util.VecDotProduct(instance.Features, weights.GetValues(0))
+	instanceDerivative.Clear()
+	if margin <= 0 {
+		return 0
+	}
+	instanceDerivative.GetValues(0).Increment(instance.Features, -2*y*margin)
+	return margin * margin
+}
+
+func TestLinearSVMDualMatchesPrimal(t *testing.T) {
+	// 对偶坐标下降的解和用lbfgs直接优化原问题的解相同，原问题的正则化因子为1/C
+	set := contrib.LoadLibSVMDataset("../testdata/a1a", false)
+	c := 0.5
+	trainer := NewLinearSVMTrainer(TrainerOptions{Options: LinearSVMOptions{C: c, Epsilon: 1e-6}})
+	model := trainer.Train(context.Background(), set).(*LinearSVMModel)
+
+	weights := util.NewMatrix(1, set.GetOptions().FeatureDimension)
+	optimizer.NewOptimizer(optimizer.OptimizerOptions{
+		OptimizerName:          "lbfgs",
+		RegularizationScheme:   2,
+		RegularizationFactor:   1 / c,
+		ConvergingGradientNorm: 1e-10,
+		ConvergingDeltaWeight:  1e-12,
+		MaxIterations:          1000,
+	}).OptimizeWeights(context.Background(), weights, squaredHingeDerivative, set)
+
+	for k := 0; k < set.GetOptions().FeatureDimension; k++ {
+		util.ExpectNear(t, weights.Get(0, k), model.Weights.Get(1, k), 1e-3)
+	}
+}
+
+func TestLinearSVMMulticlass(t *testing.T) {
+	// 三个分类的高斯分布，使用稀疏特征
+	r := rand.New(rand.NewSource(1))
+	set := data.NewInmemDataset()
+	centers := [][]float64{{0, 3}, {3, 0}, {-3, -3}}
+	for i := 0; i < 300; i++ {
+		label := i % 3
+		instance := &data.Instance{
+			Features: util.NewSparseVector(),
+			Output:   &data.InstanceOutput{Label: label},
+		}
+		instance.Features.Set(0, 1)
+		instance.Features.Set(1, centers[label][0]+r.NormFloat64())
+		instance.Features.Set(2, centers[label][1]+r.NormFloat64())
+		set.AddInstance(instance)
+	}
+	set.Finalize()
+
+	for _, multiclass := range []string{"ovr", "crammer_singer"} {
+		trainer := NewLinearSVMTrainer(TrainerOptions{
+			Options: LinearSVMOptions{Loss: "hinge", Multiclass: multiclass, Epsilon: 1e-3},
+		})
+		model := trainer.Train(context.Background(), set).(*LinearSVMModel)
+		util.Expect(t, "3", model.Weights.NumLabels())
+
+		correct := 0
+		iterator := set.CreateIterator()
+		iterator.Start()
+		for !iterator.End() {
+			instance := iterator.GetInstance()
+			output := model.Predict(instance)
+			if output.Label == instance.Output.Label {
+				correct++
+			}
+			// 预测的是决策值最大的分类
+			for iLabel := 0; iLabel < 3; iLabel++ {
+				util.Expect(t, "true",
+					output.DecisionValues.Get(iLabel) <= output.DecisionValues.Get(output.Label))
+			}
+			iterator.Next()
+		}
+		t.Logf("%s训练集准确度 = %.2f %%", multiclass, float64(correct)/3)
+		util.Expect(t, "true", correct >= 290)
+	}
+}
+
+func TestLinearSVMModel(t *testing.T) {
+	model := new(LinearSVMModel)
+	model.NumLabels = 2
+	model.Weights = util.NewSparseMatrix(2)
+	model.Weights.Set(0, 3, -1.5)
+	model.Weights.Set(1, 3, 1.5)
+	model.Write("test_svm.mlf")
+	loaded := LoadModel("test_svm.mlf").(*LinearSVMModel)
+	os.Remove("test_svm.mlf")
+
+	instance := &data.Instance{Features: util.NewSparseVector()}
+	instance.Features.Set(3, 2)
+	output := loaded.Predict(instance)
+	util.Expect(t, "1", output.Label)
+	util.Expect(t, "true", math.Abs(output.DecisionValues.Get(1)-3) < 1e-12)
+}
+
+func TestLinearSVMProgress(t *testing.T) {
+	// 每遍历一次数据集汇报一次，Iteration在各分类之间累计
+	set := newXORTestDataset(false)
+	for _, multiclass := range []string{"ovr", "crammer_singer"} {
+		iterations := 0
+		trainer := NewLinearSVMTrainer(TrainerOptions{
+			Optimizer: optimizer.OptimizerOptions{
+				Progress: func(progress optimizer.Progress) {
+					iterations++
+					util.Expect(t, strconv.Itoa(iterations), progress.Iteration)
+					util.Expect(t, "true", progress.Loss > 0)
+				},
+			},
+			Options: LinearSVMOptions{Loss: "hinge", Multiclass: multiclass, MaxIterations: 5},
+		})
+		model := trainer.Train(context.Background(), set).(*LinearSVMModel)
+		util.Expect(t, model.Metadata["iterations"], iterations)
+	}
+}
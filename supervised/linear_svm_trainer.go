@@ -0,0 +1,364 @@
+package supervised
+
+import (
+	"context"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/optimizer"
+	"github.com/huichen/mlf/util"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// 线性SVM的选项，通过TrainerOptions.Options传入，为nil时使用默认值
+//
+// 二分类时优化的原问题为
+//   min_w  w . w / 2 + C * sum_i loss(y_i * w . x_i)
+// 其中 y_i = +1或-1，loss为
+//   "hinge"：max(0, 1 - z)，即L1-loss SVM
+//   "squared_hinge"（默认）：max(0, 1 - z)^2，即L2-loss SVM
+// 偏置为第0个特征（常数1）的权重，和其它权重一样被正则化。
+type LinearSVMOptions struct {
+	// 损失函数，见上
+	Loss string
+
+	// 多分类方法：
+	//   "ovr"（默认）：one-vs-rest，为每个分类训练一个区分该分类和其它分类的二分类SVM
+	//   "crammer_singer"：同时优化所有分类的权重，损失为
+	//     max_j(w_j . x_i + [j != y_i]) - w_{y_i} . x_i，只支持hinge损失
+	// 二分类时两者都只训练一个二分类SVM
+	Multiclass string
+
+	// 损失项的系数，值为0时为1
+	C float64
+
+	// 对偶问题的收敛阈值（最大的投影梯度之差），值为0时为0.1
+	Epsilon float64
+
+	// 最多遍历数据集多少次，值为0时为1000
+	MaxIterations int
+
+	// 打乱样本顺序使用的随机数种子
+	Seed int64
+}
+
+// 线性SVM训练器
+//
+// 使用对偶坐标下降法（dual coordinate descent）求解，算法见
+//   Hsieh, C.-J. et al. (2008). "A Dual Coordinate Descent Method for Large-scale Linear
+//   SVM". Proceedings of the 25th ICML: 408-415.
+// Crammer-Singer多分类使用逐样本的对偶方法，见
+//   Keerthi, S. S. et al. (2008). "A Sequential Dual Method for Large Scale Multi-class
+//   Linear SVMs". Proceedings of the 14th ACM SIGKDD: 408-416.
+// 和LIBLINEAR的-s 1、-s 3、-s 4相同（不使用shrinking）。TrainerOptions.Optimizer中只有
+// Progress起作用，每遍历一次数据集汇报一次原问题的目标函数值（one-vs-rest为当前训练的
+// 二分类SVM的目标函数值），Iteration为所有分类累计的遍历次数。
+type LinearSVMTrainer struct {
+	options  LinearSVMOptions
+	progress optimizer.ProgressFunc
+}
+
+// 创建一个线性SVM训练器
+func NewLinearSVMTrainer(options TrainerOptions) Trainer {
+	trainer := new(LinearSVMTrainer)
+	if options.Options != nil {
+		svmOptions, ok := options.Options.(LinearSVMOptions)
+		if !ok {
+			log.Fatal("线性SVM训练器的Options必须为LinearSVMOptions")
+		}
+		trainer.options = svmOptions
+	}
+	trainer.progress = options.Optimizer.Progress
+	o := &trainer.options
+	switch o.Loss {
+	case "":
+		o.Loss = "squared_hinge"
+	case "hinge", "squared_hinge":
+	default:
+		log.Fatal("不支持的SVM损失函数", o.Loss)
+	}
+	switch o.Multiclass {
+	case "":
+		o.Multiclass = "ovr"
+	case "ovr":
+	case "crammer_singer":
+		if o.Loss != "hinge" {
+			log.Fatal("Crammer-Singer多分类SVM只支持hinge损失")
+		}
+	default:
+		log.Fatal("不支持的多分类方法", o.Multiclass)
+	}
+	if o.C == 0 {
+		o.C = 1
+	}
+	if o.Epsilon == 0 {
+		o.Epsilon = 0.1
+	}
+	if o.MaxIterations == 0 {
+		o.MaxIterations = 1000
+	}
+	return trainer
+}
+
+func (trainer *LinearSVMTrainer) Train(ctx context.Context, set data.Dataset) Model {
+	if !set.GetOptions().IsSupervisedLearning {
+		log.Fatal("训练数据不是分类问题数据")
+	}
+
+	// 对偶方法需要随机访问样本
+	instances := []*data.Instance{}
+	iterator := set.CreateIterator()
+	iterator.Start()
+	for !iterator.End() {
+		instances = append(instances, iterator.GetInstance())
+		iterator.Next()
+	}
+
+	// 每个分类一行权重
+	featureDimension := set.GetOptions().FeatureDimension
+	numLabels := set.GetOptions().NumLabels
+	var weights *util.Matrix
+	if set.GetOptions().FeatureIsSparse {
+		weights = util.NewSparseMatrix(numLabels)
+	} else {
+		weights = util.NewMatrix(numLabels, featureDimension)
+	}
+
+	result := optimizer.OptimizationResult{}
+	r := rand.New(rand.NewSource(trainer.options.Seed))
+	if numLabels == 2 {
+		// 二分类只训练第1个分类，第0个分类的决策值为其相反数
+		result = trainer.solveBinary(ctx, instances, 1, weights, r, 0)
+		weights.GetValues(0).Increment(weights.GetValues(1), -1)
+	} else if trainer.options.Multiclass == "ovr" {
+		for iLabel := 0; iLabel < numLabels; iLabel++ {
+			labelResult := trainer.solveBinary(ctx, instances, iLabel, weights, r, result.Iterations)
+			result.Iterations += labelResult.Iterations
+			if labelResult.Canceled {
+				result.Canceled = true
+				break
+			}
+		}
+	} else {
+		result = trainer.solveCrammerSinger(ctx, instances, weights, r)
+	}
+
+	model := new(LinearSVMModel)
+	model.Weights = weights
+	model.NumLabels = numLabels
+	model.FeatureDimension = featureDimension
+	model.FeatureDictionary = set.GetFeatureDictionary()
+	model.LabelDictionary = set.GetLabelDictionary()
+	model.Metadata = map[string]string{}
+	for k, v := range result.Metadata() {
+		if k != "loss" {
+			model.Metadata[k] = v
+		}
+	}
+	return model
+}
+
+// 用对偶坐标下降法训练区分positiveLabel和其它分类的二分类SVM，权重写入weights的
+// 第positiveLabel行。iterationOffset为之前训练其它分类时的遍历次数，用于汇报进度
+func (trainer *LinearSVMTrainer) solveBinary(ctx context.Context, instances []*data.Instance,
+	positiveLabel int, weights *util.Matrix, r *rand.Rand, iterationOffset int) optimizer.OptimizationResult {
+	options := trainer.options
+	w := weights.GetValues(positiveLabel)
+
+	// hinge损失的对偶变量有上界C，squared_hinge没有上界，但对角线上多了1/(2C)
+	upperBound := options.C
+	diagonal := float64(0)
+	if options.Loss == "squared_hinge" {
+		upperBound = math.Inf(1)
+		diagonal = 1 / (2 * options.C)
+	}
+
+	n := len(instances)
+	alpha := make([]float64, n)
+	y := make([]float64, n)
+	qd := make([]float64, n)
+	for i, instance := range instances {
+		y[i] = -1
+		if instance.Output.Label == positiveLabel {
+			y[i] = 1
+		}
+		qd[i] = diagonal + util.VecDotProduct(instance.Features, instance.Features)
+	}
+
+	result := optimizer.OptimizationResult{}
+	index := r.Perm(n)
+	for result.Iterations < options.MaxIterations {
+		if ctx.Err() != nil {
+			result.Canceled = true
+			break
+		}
+		result.Iterations++
+
+		r.Shuffle(n, func(i, j int) { index[i], index[j] = index[j], index[i] })
+		maxPG := math.Inf(-1)
+		minPG := math.Inf(1)
+		for _, i := range index {
+			features := instances[i].Features
+			g := y[i]*util.VecDotProduct(features, w) - 1 + diagonal*alpha[i]
+
+			// 投影梯度
+			pg := g
+			if alpha[i] == 0 {
+				pg = math.Min(g, 0)
+			} else if alpha[i] == upperBound {
+				pg = math.Max(g, 0)
+			}
+			maxPG = math.Max(maxPG, pg)
+			minPG = math.Min(minPG, pg)
+
+			if math.Abs(pg) > 1e-12 {
+				oldAlpha := alpha[i]
+				alpha[i] = math.Min(math.Max(alpha[i]-g/qd[i], 0), upperBound)
+				w.Increment(features, (alpha[i]-oldAlpha)*y[i])
+			}
+		}
+		if trainer.progress != nil {
+			// 原问题的目标函数值 w . w / 2 + C * sum_i loss(y_i * w . x_i)
+			loss := util.VecDotProduct(w, w) / 2
+			for i, instance := range instances {
+				l := math.Max(0, 1-y[i]*util.VecDotProduct(instance.Features, w))
+				if options.Loss == "squared_hinge" {
+					l *= l
+				}
+				loss += options.C * l
+			}
+			trainer.progress(optimizer.Progress{
+				Iteration: iterationOffset + result.Iterations,
+				Loss:      loss,
+				Weights:   weights,
+			})
+		}
+		if maxPG-minPG <= options.Epsilon {
+			break
+		}
+	}
+	log.Printf("分类%d的对偶坐标下降完成，%d次迭代", positiveLabel, result.Iterations)
+	return result
+}
+
+// 用逐样本的对偶方法训练Crammer-Singer多分类SVM
+//
+// 样本i的对偶变量alpha_i满足 sum_m alpha_i_m = 0，alpha_i_m <= C_m，其中分类y_i的
+// C_m = C，其它分类为0。每次固定其它样本，求解alpha_i的子问题。
+func (trainer *LinearSVMTrainer) solveCrammerSinger(ctx context.Context, instances []*data.Instance,
+	weights *util.Matrix, r *rand.Rand) optimizer.OptimizationResult {
+	options := trainer.options
+	numLabels := weights.NumLabels()
+	n := len(instances)
+
+	alpha := make([][]float64, n)
+	qd := make([]float64, n)
+	for i, instance := range instances {
+		alpha[i] = make([]float64, numLabels)
+		qd[i] = util.VecDotProduct(instance.Features, instance.Features)
+	}
+	g := make([]float64, numLabels)
+	b := make([]float64, numLabels)
+	d := make([]float64, numLabels)
+	newAlpha := make([]float64, numLabels)
+
+	result := optimizer.OptimizationResult{}
+	index := r.Perm(n)
+	for result.Iterations < options.MaxIterations {
+		if ctx.Err() != nil {
+			result.Canceled = true
+			break
+		}
+		result.Iterations++
+
+		r.Shuffle(n, func(i, j int) { index[i], index[j] = index[j], index[i] })
+		maxViolation := float64(0)
+		for _, i := range index {
+			if qd[i] == 0 {
+				continue
+			}
+			features := instances[i].Features
+			label := instances[i].Output.Label
+			bound := func(m int) float64 {
+				if m == label {
+					return options.C
+				}
+				return 0
+			}
+
+			// 梯度 g_m = w_m . x_i + [m != y_i]
+			minG := math.Inf(1)
+			maxG := math.Inf(-1)
+			for m := 0; m < numLabels; m++ {
+				g[m] = util.VecDotProduct(features, weights.GetValues(m))
+				if m != label {
+					g[m]++
+				}
+				if alpha[i][m] < bound(m) && g[m] < minG {
+					minG = g[m]
+				}
+				maxG = math.Max(maxG, g[m])
+			}
+			if maxG-minG <= 1e-12 {
+				continue
+			}
+			maxViolation = math.Max(maxViolation, maxG-minG)
+
+			// 子问题的解 alpha_i_m = min(C_m, (beta - b_m) / A_i)，beta由排序后的d求出
+			for m := 0; m < numLabels; m++ {
+				b[m] = g[m] - qd[i]*alpha[i][m]
+				d[m] = b[m]
+			}
+			d[label] += qd[i] * options.C
+			sort.Sort(sort.Reverse(sort.Float64Slice(d)))
+			beta := d[0] - qd[i]*options.C
+			rank := 1
+			for ; rank < numLabels && beta < float64(rank)*d[rank]; rank++ {
+				beta += d[rank]
+			}
+			beta /= float64(rank)
+			for m := 0; m < numLabels; m++ {
+				newAlpha[m] = math.Min(bound(m), (beta-b[m])/qd[i])
+			}
+
+			for m := 0; m < numLabels; m++ {
+				if delta := newAlpha[m] - alpha[i][m]; math.Abs(delta) > 1e-12 {
+					weights.GetValues(m).Increment(features, delta)
+					alpha[i][m] = newAlpha[m]
+				}
+			}
+		}
+		if trainer.progress != nil {
+			// 原问题的目标函数值
+			//   sum_m w_m . w_m / 2 + C * sum_i (max_m(w_m . x_i + [m != y_i]) - w_{y_i} . x_i)
+			loss := float64(0)
+			for m := 0; m < numLabels; m++ {
+				loss += util.VecDotProduct(weights.GetValues(m), weights.GetValues(m)) / 2
+			}
+			for _, instance := range instances {
+				label := instance.Output.Label
+				maxG := math.Inf(-1)
+				for m := 0; m < numLabels; m++ {
+					g[m] = util.VecDotProduct(instance.Features, weights.GetValues(m))
+					if m != label {
+						maxG = math.Max(maxG, g[m]+1)
+					} else {
+						maxG = math.Max(maxG, g[m])
+					}
+				}
+				loss += options.C * (maxG - g[label])
+			}
+			trainer.progress(optimizer.Progress{
+				Iteration: result.Iterations,
+				Loss:      loss,
+				Weights:   weights,
+			})
+		}
+		if maxViolation <= options.Epsilon {
+			break
+		}
+	}
+	log.Printf("Crammer-Singer对偶方法完成，%d次迭代", result.Iterations)
+	return result
+}
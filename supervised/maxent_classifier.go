@@ -1,13 +1,10 @@
 package supervised
 
 import (
-	"encoding/json"
 	"github.com/huichen/mlf/data"
 	"github.com/huichen/mlf/dictionary"
 	"github.com/huichen/mlf/util"
-	"log"
 	"math"
-	"os"
 )
 
 // 最大熵分类器模型
@@ -20,41 +17,69 @@ type MaxEntClassifier struct {
 	LabelDictionary   *dictionary.Dictionary
 
 	Weights *util.Matrix
+
+	// 模型的元数据，见MetadataModel
+	Metadata map[string]string
+}
+
+func init() {
+	RegisterModel("maxent_classifier", func(d *util.BinaryDecoder) Model {
+		classifier := new(MaxEntClassifier)
+		classifier.DecodeBinary(d)
+		return classifier
+	})
 }
 
 func (classifier *MaxEntClassifier) GetModelType() string {
 	return "maxent_classifier"
 }
 
+func (classifier *MaxEntClassifier) GetMetadata() map[string]string {
+	return classifier.Metadata
+}
+
+func (classifier *MaxEntClassifier) SetMetadata(metadata map[string]string) {
+	classifier.Metadata = metadata
+}
+
 func (classifier *MaxEntClassifier) Write(path string) {
-	response, errMarshal := json.MarshalIndent(classifier, "", "\t")
-	if errMarshal != nil {
-		log.Fatal("无法转化模型为JSON格式")
+	WriteModelFile(path, classifier, classifier.EncodeBinary)
+}
+
+// 将模型以二进制格式写入编码器
+func (classifier *MaxEntClassifier) EncodeBinary(e *util.BinaryEncoder) {
+	e.WriteInt(classifier.NumLabels)
+	e.WriteInt(classifier.FeatureDimension)
+	e.WriteInt(len(classifier.LabelNames))
+	for _, name := range classifier.LabelNames {
+		e.WriteString(name)
 	}
-	f, err := os.Create(path)
-	defer f.Close()
-	if err != nil {
-		log.Fatal("无法写入", path, "文件")
+	encodeDictionary(e, classifier.FeatureDictionary)
+	encodeDictionary(e, classifier.LabelDictionary)
+	classifier.Weights.EncodeBinary(e)
+}
+
+// 从解码器中读入二进制格式的模型
+func (classifier *MaxEntClassifier) DecodeBinary(d *util.BinaryDecoder) {
+	classifier.NumLabels = d.ReadInt()
+	classifier.FeatureDimension = d.ReadInt()
+	numLabelNames := d.ReadLength()
+	classifier.LabelNames = nil
+	for i := 0; i < numLabelNames && d.Err() == nil; i++ {
+		classifier.LabelNames = append(classifier.LabelNames, d.ReadString())
 	}
-	f.Write(response)
+	classifier.FeatureDictionary = decodeDictionary(d)
+	classifier.LabelDictionary = decodeDictionary(d)
+	classifier.Weights = new(util.Matrix)
+	classifier.Weights.DecodeBinary(d)
 }
 
 func (classifier *MaxEntClassifier) Predict(instance *data.Instance) data.InstanceOutput {
 	output := data.InstanceOutput{}
 
 	// 当使用NamedFeatures时转化为Features
-	if instance.NamedFeatures != nil {
-		if classifier.FeatureDictionary == nil {
-			return output
-		}
-		instance.Features = util.NewSparseVector()
-		// 第0个feature始终是1
-		instance.Features.Set(0, 1.0)
-
-		for k, v := range instance.NamedFeatures {
-			id := classifier.FeatureDictionary.TranslateIdFromName(k)
-			instance.Features.Set(id, v)
-		}
+	if !convertNamedFeatures(instance, classifier.FeatureDictionary) {
+		return output
 	}
 
 	output.LabelDistribution = util.NewVector(classifier.NumLabels)
@@ -1,6 +1,7 @@
 package supervised
 
 import (
+	"context"
 	"github.com/huichen/mlf/data"
 	"github.com/huichen/mlf/optimizer"
 	"github.com/huichen/mlf/util"
@@ -47,6 +48,31 @@ func TestComputeInstanceDerivative(t *testing.T) {
 	util.ExpectNear(t, -0.0085, de.Get(1, 2), 0.0001)
 }
 
+func TestMaxEntGradientCheck(t *testing.T) {
+	for _, sparse := range []bool{false, true} {
+		instance := new(data.Instance)
+		var weights *util.Matrix
+		if sparse {
+			instance.Features = util.NewSparseVector()
+			weights = util.NewSparseMatrix(2)
+		} else {
+			instance.Features = util.NewVector(4)
+			weights = util.NewMatrix(2, 4)
+		}
+		instance.Features.SetValues([]float64{1, 0.5, 0, -2})
+		weights.Set(0, 1, 0.3)
+		weights.Set(1, 3, -0.4)
+
+		for label := 0; label < 3; label++ {
+			instance.Output = &data.InstanceOutput{Label: label}
+			result := optimizer.CheckGradient(MaxEntComputeInstanceDerivative, weights, instance,
+				optimizer.GradientCheckOptions{})
+			util.Expect(t, "true", len(result.Entries) >= 6)
+			util.Expect(t, "true", result.MaxRelativeError < 1e-6)
+		}
+	}
+}
+
 func TestTrain(t *testing.T) {
 	set := data.NewInmemDataset()
 	instance1 := new(data.Instance)
@@ -100,9 +126,9 @@ func TestTrain(t *testing.T) {
 		},
 	}
 	lbfgsTrainer := NewMaxEntClassifierTrainer(lbfgsTrainerOptions)
-	lbfgsTrainer.Train(set)
+	lbfgsTrainer.Train(context.Background(), set)
 
-	gdTrainer.Train(set).Write("test.mlf")
+	gdTrainer.Train(context.Background(), set).Write("test.mlf")
 	model := LoadModel("test.mlf")
 	util.Expect(t, "0", model.Predict(instance1).Label)
 	util.Expect(t, "0", model.Predict(instance2).Label)
@@ -179,12 +205,79 @@ func TestTrainWithNamedFeatures(t *testing.T) {
 		},
 	}
 	lbfgsTrainer := NewMaxEntClassifierTrainer(lbfgsTrainerOptions)
-	lbfgsTrainer.Train(set)
+	lbfgsTrainer.Train(context.Background(), set)
 
-	gdTrainer.Train(set).Write("test.mlf")
+	gdTrainer.Train(context.Background(), set).Write("test.mlf")
 	model := LoadModel("test.mlf")
 	util.Expect(t, "0", model.Predict(instance1).Label)
 	util.Expect(t, "0", model.Predict(instance2).Label)
 	util.Expect(t, "1", model.Predict(instance3).Label)
 	util.Expect(t, "1", model.Predict(instance4).Label)
 }
+
+func TestMaxEntComputeInstanceHessianVector(t *testing.T) {
+	instance := new(data.Instance)
+	instance.Features = util.NewVector(3)
+	instance.Features.SetValues([]float64{1, 0.5, -2})
+	instance.Output = &data.InstanceOutput{Label: 1}
+
+	weights := util.NewMatrix(2, 3)
+	weights.GetValues(0).SetValues([]float64{0.1, -0.3, 0.2})
+	weights.GetValues(1).SetValues([]float64{-0.2, 0.4, 0.1})
+	v := util.NewMatrix(2, 3)
+	v.GetValues(0).SetValues([]float64{0.5, 1, -1})
+	v.GetValues(1).SetValues([]float64{-0.7, 0.2, 0.3})
+
+	hv := util.NewMatrix(2, 3)
+	MaxEntComputeInstanceHessianVector(weights, instance, v, hv)
+
+	// 和偏导数的有限差分 (g(w + eps * v) - g(w - eps * v)) / (2 * eps) 比较
+	eps := 1e-5
+	plus := util.NewMatrix(2, 3)
+	minus := util.NewMatrix(2, 3)
+	plus.WeightedSum(weights, v, 1, eps)
+	minus.WeightedSum(weights, v, 1, -eps)
+	gPlus := util.NewMatrix(2, 3)
+	gMinus := util.NewMatrix(2, 3)
+	MaxEntComputeInstanceDerivative(plus, instance, gPlus)
+	MaxEntComputeInstanceDerivative(minus, instance, gMinus)
+	for iLabel := 0; iLabel < 2; iLabel++ {
+		for k := 0; k < 3; k++ {
+			util.ExpectNear(t, (gPlus.Get(iLabel, k)-gMinus.Get(iLabel, k))/(2*eps),
+				hv.Get(iLabel, k), 1e-6)
+		}
+	}
+}
+
+func TestMaxEntComputeInstanceScoreLoss(t *testing.T) {
+	instance := new(data.Instance)
+	instance.Features = util.NewVector(3)
+	instance.Features.SetValues([]float64{1, 0.5, -2})
+	instance.Output = &data.InstanceOutput{Label: 2}
+
+	weights := util.NewMatrix(2, 3)
+	weights.GetValues(0).SetValues([]float64{0.1, -0.3, 0.2})
+	weights.GetValues(1).SetValues([]float64{-0.2, 0.4, 0.1})
+	scores := []float64{
+		util.VecDotProduct(instance.Features, weights.GetValues(0)),
+		util.VecDotProduct(instance.Features, weights.GetValues(1)),
+	}
+	derivative := make([]float64, 2)
+	curvature := make([]float64, 2)
+	loss := MaxEntComputeInstanceScoreLoss(scores, instance, derivative, curvature)
+
+	// 和按权重计算的损失及偏导数一致
+	instanceDerivative := util.NewMatrix(2, 3)
+	util.ExpectNear(t, MaxEntComputeInstanceDerivative(weights, instance, instanceDerivative), loss, 1e-12)
+	for iLabel := 0; iLabel < 2; iLabel++ {
+		for k := 0; k < 3; k++ {
+			util.ExpectNear(t, instanceDerivative.Get(iLabel, k),
+				derivative[iLabel]*instance.Features.Get(k), 1e-12)
+		}
+		p := derivative[iLabel]
+		if iLabel == 1 {
+			p += 1
+		}
+		util.ExpectNear(t, p*(1-p), curvature[iLabel], 1e-12)
+	}
+}
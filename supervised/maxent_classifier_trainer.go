@@ -1,6 +1,7 @@
 package supervised
 
 import (
+	"context"
 	"github.com/huichen/mlf/data"
 	"github.com/huichen/mlf/optimizer"
 	"github.com/huichen/mlf/util"
@@ -20,14 +21,20 @@ func NewMaxEntClassifierTrainer(options TrainerOptions) Trainer {
 	return classifier
 }
 
-func (trainer *MaxEntClassifierTrainer) Train(set data.Dataset) Model {
+func (trainer *MaxEntClassifierTrainer) Train(ctx context.Context, set data.Dataset) Model {
 	// 检查训练数据是否是分类问题
 	if !set.GetOptions().IsSupervisedLearning {
 		log.Fatal("训练数据不是分类问题数据")
 	}
 
 	// 建立新的优化器
-	optimizer := optimizer.NewOptimizer(trainer.options.Optimizer)
+	opt := optimizer.NewOptimizer(trainer.options.Optimizer)
+	if hvOptimizer, ok := opt.(optimizer.HessianVectorOptimizer); ok {
+		hvOptimizer.SetHessianVectorFunc(MaxEntComputeInstanceHessianVector)
+	}
+	if scoreLossOptimizer, ok := opt.(optimizer.ScoreLossOptimizer); ok {
+		scoreLossOptimizer.SetScoreLossFunc(MaxEntComputeInstanceScoreLoss)
+	}
 
 	// 建立特征权重向量
 	featureDimension := set.GetOptions().FeatureDimension
@@ -40,7 +47,7 @@ func (trainer *MaxEntClassifierTrainer) Train(set data.Dataset) Model {
 	}
 
 	// 得到优化的特征权重向量
-	optimizer.OptimizeWeights(weights, MaxEntComputeInstanceDerivative, set)
+	result := opt.OptimizeWeights(ctx, weights, MaxEntComputeInstanceDerivative, set)
 
 	classifier := new(MaxEntClassifier)
 	classifier.Weights = weights
@@ -48,11 +55,13 @@ func (trainer *MaxEntClassifierTrainer) Train(set data.Dataset) Model {
 	classifier.FeatureDimension = featureDimension
 	classifier.FeatureDictionary = set.GetFeatureDictionary()
 	classifier.LabelDictionary = set.GetLabelDictionary()
+	classifier.Metadata = result.Metadata()
 	return classifier
 }
 
+// 计算最大熵模型单个样本的偏导数，返回样本的负对数似然 -log p(label|x)
 func MaxEntComputeInstanceDerivative(
-	weights *util.Matrix, instance *data.Instance, instanceDerivative *util.Matrix) {
+	weights *util.Matrix, instance *data.Instance, instanceDerivative *util.Matrix) float64 {
 	// 定义偏导和特征向量
 	features := instance.Features
 
@@ -72,6 +81,79 @@ func MaxEntComputeInstanceDerivative(
 			vec.Multiply(inverseZ, -1, features)
 		}
 	}
+
+	// -log p(label|x) = log(z) - sum(w_label_i * x_i)
+	loss := math.Log(z)
+	if label != 0 {
+		loss -= util.VecDotProduct(features, weights.GetValues(label-1))
+	}
+	return loss
+}
+
+// 计算最大熵模型单个样本的负对数似然的海森矩阵和向量v的乘积，用于tron优化器
+//
+// 记 p_j = p(j|x)，a_j = sum(v_j_i * x_i)，乘积的第j行为
+//   (H * v)_j = p_j * (a_j - sum_k(p_k * a_k)) * x
+func MaxEntComputeInstanceHessianVector(
+	weights *util.Matrix, instance *data.Instance, v, instanceHv *util.Matrix) {
+	features := instance.Features
+	numLabels := weights.NumLabels() + 1
+
+	probabilities := make([]float64, numLabels-1)
+	projections := make([]float64, numLabels-1)
+	z := float64(1)
+	for iLabel := 1; iLabel < numLabels; iLabel++ {
+		probabilities[iLabel-1] = math.Exp(util.VecDotProduct(features, weights.GetValues(iLabel-1)))
+		projections[iLabel-1] = util.VecDotProduct(features, v.GetValues(iLabel-1))
+		z += probabilities[iLabel-1]
+	}
+	average := float64(0)
+	for i := range probabilities {
+		probabilities[i] /= z
+		average += probabilities[i] * projections[i]
+	}
+
+	for i := range probabilities {
+		coefficient := probabilities[i] * (projections[i] - average)
+		vec := instanceHv.GetValues(i)
+		if vec.IsSparse() {
+			for _, k := range features.Keys() {
+				vec.Set(k, coefficient)
+			}
+		} else {
+			vec.SetAll(coefficient)
+		}
+		vec.Multiply(1, 0, features)
+	}
+}
+
+// 用各标注的得分计算最大熵模型单个样本的负对数似然，用于cd优化器
+//
+// scores[j]为第j+1个标注的得分（第0个标注的得分固定为0），p_j = exp(scores[j]) / z，
+// 损失对得分的一阶导数为 p_j - y_j，二阶导数为 p_j * (1 - p_j)。
+func MaxEntComputeInstanceScoreLoss(
+	scores []float64, instance *data.Instance, derivative, curvature []float64) float64 {
+	label := instance.Output.Label
+	z := float64(1)
+	for i, score := range scores {
+		derivative[i] = math.Exp(score)
+		z += derivative[i]
+	}
+	for i := range scores {
+		p := derivative[i] / z
+		curvature[i] = p * (1 - p)
+		if label == i+1 {
+			derivative[i] = p - 1
+		} else {
+			derivative[i] = p
+		}
+	}
+
+	loss := math.Log(z)
+	if label != 0 {
+		loss -= scores[label-1]
+	}
+	return loss
 }
 
 // 计算 z = 1 + sum(exp(sum(w_i * x_i)))
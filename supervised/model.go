@@ -2,6 +2,8 @@ package supervised
 
 import (
 	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/dictionary"
+	"github.com/huichen/mlf/util"
 )
 
 // 训练得到的机器学习模型
@@ -15,3 +17,35 @@ type Model interface {
 	// 预测样本的输出
 	Predict(instance *data.Instance) data.InstanceOutput
 }
+
+// 带有元数据的模型
+//
+// 元数据是字符串键值对，记录模型训练的过程，比如提前终止时选中的迭代
+// （见optimizer.OptimizationResult）。元数据保存在模型文件中，LoadModel载入时恢复。
+type MetadataModel interface {
+	Model
+
+	GetMetadata() map[string]string
+	SetMetadata(metadata map[string]string)
+}
+
+// 预测时将样本的NamedFeatures按模型的特征词典转化为Features，词典中没有的特征被忽略
+// 样本使用NamedFeatures而模型没有特征词典时返回false
+func convertNamedFeatures(instance *data.Instance, dict *dictionary.Dictionary) bool {
+	if instance.NamedFeatures == nil {
+		return true
+	}
+	if dict == nil {
+		return false
+	}
+	instance.Features = util.NewSparseVector()
+	// 第0个feature始终是1
+	instance.Features.Set(0, 1.0)
+
+	for k, v := range instance.NamedFeatures {
+		if id := dict.TranslateIdFromName(k); id >= 0 {
+			instance.Features.Set(id, v)
+		}
+	}
+	return true
+}
@@ -1,31 +1,64 @@
 package supervised
 
 import (
-	"encoding/json"
+	"github.com/huichen/mlf/dictionary"
+	"github.com/huichen/mlf/util"
 	"log"
-	"os"
 )
 
+// 从文件中载入模型
+//
+// 二进制模型文件（见util.ModelFileMagic）中记录了模型类型，LoadModel根据类型调用
+// RegisterModel注册的解码函数，因此可以返回任何已注册的模型。请确保模型所在的包已经
+// 被引用（必要时使用 import _ "包名"）。
+//
+// 旧版本输出的JSON格式模型文件没有记录类型，作为最大熵分类模型载入；二进制文件的
+// 模型类型为空或者未注册时退出。
+// 文件可以经过gzip压缩。
 func LoadModel(path string) Model {
-	// 最大文件长度10M
-	data := make([]byte, 1024*1024*10)
-
-	f, err := os.Open(path)
-	defer f.Close()
+	r, err := util.OpenModelFile(path)
 	if err != nil {
-		log.Fatal("无法打开", path, "文件")
+		log.Fatal("无法打开", path, "文件，错误", err)
 	}
+	defer r.Close()
 
-	count, errRead := f.Read(data)
-	if errRead != nil {
-		log.Fatal("无法读入", path, "文件")
+	if r.IsJSON {
+		m := new(MaxEntClassifier)
+		if err := r.DecodeJSON(m); err != nil {
+			log.Fatal("无法解析", path, "文件，错误", err)
+		}
+		return m
 	}
 
-	m := new(MaxEntClassifier)
-	errUnmarshal := json.Unmarshal(data[:count], m)
-	if errUnmarshal != nil {
-		log.Fatal("无法解析", path, "文件，错误", errUnmarshal)
+	decoder := getModelDecoder(r.ModelType)
+	if decoder == nil {
+		log.Fatal("无法载入", path, "文件，未注册的模型类型", r.ModelType)
 	}
 
+	m := decoder(r.BinaryDecoder)
+	if r.Err() != nil {
+		log.Fatal("无法解析", path, "文件，错误", r.Err())
+	}
+	if mm, ok := m.(MetadataModel); ok {
+		mm.SetMetadata(r.Metadata)
+	}
 	return m
 }
+
+// 写入一个可能为nil的词典
+func encodeDictionary(e *util.BinaryEncoder, dict *dictionary.Dictionary) {
+	e.WriteBool(dict != nil)
+	if dict != nil {
+		dict.EncodeBinary(e)
+	}
+}
+
+// 读入encodeDictionary写入的词典
+func decodeDictionary(d *util.BinaryDecoder) *dictionary.Dictionary {
+	if !d.ReadBool() {
+		return nil
+	}
+	dict := new(dictionary.Dictionary)
+	dict.DecodeBinary(d)
+	return dict
+}
@@ -0,0 +1,102 @@
+package supervised
+
+import (
+	"compress/gzip"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/dictionary"
+	"github.com/huichen/mlf/util"
+	"os"
+	"testing"
+)
+
+func newTestMaxEntClassifier() *MaxEntClassifier {
+	classifier := new(MaxEntClassifier)
+	classifier.NumLabels = 2
+	classifier.Weights = util.NewSparseMatrix(1)
+	classifier.Weights.Set(0, 0, -1)
+	classifier.Weights.Set(0, 5, 2)
+	classifier.FeatureDictionary = dictionary.NewDictionary(1)
+	classifier.FeatureDictionary.GetIdFromName("f1")
+	classifier.FeatureDictionary.GetIdFromName("f2")
+	return classifier
+}
+
+func TestLoadBinaryModel(t *testing.T) {
+	for _, path := range []string{"test_binary.mlf", "test_binary.mlf.gz"} {
+		newTestMaxEntClassifier().Write(path)
+		m := LoadModel(path).(*MaxEntClassifier)
+		os.Remove(path)
+
+		util.Expect(t, "2", m.NumLabels)
+		util.Expect(t, "2", m.Weights.Get(0, 5))
+		util.Expect(t, "-1", m.Weights.Get(0, 0))
+		util.Expect(t, "2", m.FeatureDictionary.TranslateIdFromName("f2"))
+		util.Expect(t, "<nil>", m.LabelDictionary)
+	}
+}
+
+func TestLoadModelMetadata(t *testing.T) {
+	classifier := newTestMaxEntClassifier()
+	classifier.Metadata = map[string]string{"best_iteration": "7", "iterations": "10"}
+	classifier.Write("test_metadata.mlf")
+	m := LoadModel("test_metadata.mlf").(*MaxEntClassifier)
+	os.Remove("test_metadata.mlf")
+
+	util.Expect(t, "7", m.Metadata["best_iteration"])
+	util.Expect(t, "10", m.Metadata["iterations"])
+	util.Expect(t, "2", m.Weights.Get(0, 5))
+}
+
+func TestLoadJSONModel(t *testing.T) {
+	// 旧版本的模型文件为JSON格式
+	f, _ := os.Create("test_json.mlf.gz")
+	w := gzip.NewWriter(f)
+	w.Write([]byte(`{"NumLabels":2,"FeatureDimension":0,"LabelNames":null,
+		"FeatureDictionary":{"Words":{"f1":1}},"LabelDictionary":null,
+		"Weights":{"Values":[{"Values":null,"ValueMap":{"1":0.5},"Keys":[1],"IsSparse":true}],
+		"NumValues":0,"IsSparse":true}}`))
+	w.Close()
+	f.Close()
+
+	m := LoadModel("test_json.mlf.gz").(*MaxEntClassifier)
+	os.Remove("test_json.mlf.gz")
+	util.Expect(t, "0.5", m.Weights.Get(0, 1))
+	util.Expect(t, "1", m.FeatureDictionary.TranslateIdFromName("f1"))
+}
+
+// 测试用的模型，只保存一个常数输出
+type constantModel struct {
+	label int
+}
+
+func (m *constantModel) GetModelType() string {
+	return "test_constant"
+}
+
+func (m *constantModel) Write(path string) {
+	WriteModelFile(path, m, func(e *util.BinaryEncoder) {
+		e.WriteInt(m.label)
+	})
+}
+
+func (m *constantModel) Predict(instance *data.Instance) data.InstanceOutput {
+	return data.InstanceOutput{Label: m.label}
+}
+
+func TestModelRegistry(t *testing.T) {
+	RegisterModel("test_constant", func(d *util.BinaryDecoder) Model {
+		return &constantModel{label: d.ReadInt()}
+	})
+
+	(&constantModel{label: 3}).Write("test_registry.mlf")
+	m := LoadModel("test_registry.mlf")
+	os.Remove("test_registry.mlf")
+
+	util.Expect(t, "test_constant", m.GetModelType())
+	util.Expect(t, "3", m.Predict(&data.Instance{}).Label)
+
+	// 模型文件中记录了类型
+	newTestMaxEntClassifier().Write("test_registry.mlf")
+	util.Expect(t, "maxent_classifier", LoadModel("test_registry.mlf").GetModelType())
+	os.Remove("test_registry.mlf")
+}
@@ -0,0 +1,54 @@
+package supervised
+
+import (
+	"github.com/huichen/mlf/util"
+	"log"
+	"sync"
+)
+
+// 模型解码函数，从二进制模型文件中读入模型
+// 解码错误通过d.Err()报告
+type ModelDecodeFunc func(d *util.BinaryDecoder) Model
+
+var modelRegistry = struct {
+	sync.RWMutex
+	decoders map[string]ModelDecodeFunc
+}{decoders: make(map[string]ModelDecodeFunc)}
+
+// 注册一种模型类型，modelType必须和模型GetModelType的返回值相同
+//
+// 通常在模型所在包的init函数中调用，此后LoadModel就可以载入该类型的模型文件。
+// 重复注册同一类型是非法的。
+func RegisterModel(modelType string, decoder ModelDecodeFunc) {
+	modelRegistry.Lock()
+	defer modelRegistry.Unlock()
+
+	if _, ok := modelRegistry.decoders[modelType]; ok {
+		log.Fatal("模型类型", modelType, "已经注册过")
+	}
+	modelRegistry.decoders[modelType] = decoder
+}
+
+// 返回modelType类型模型的解码函数，没有注册时返回nil
+func getModelDecoder(modelType string) ModelDecodeFunc {
+	modelRegistry.RLock()
+	defer modelRegistry.RUnlock()
+	return modelRegistry.decoders[modelType]
+}
+
+// 将模型写入二进制模型文件，encode负责写入模型自己的内容
+// 如果模型实现了MetadataModel接口，其元数据也被写入文件
+func WriteModelFile(path string, m Model, encode func(e *util.BinaryEncoder)) {
+	var metadata map[string]string
+	if mm, ok := m.(MetadataModel); ok {
+		metadata = mm.GetMetadata()
+	}
+	w, err := util.CreateModelFile(path, m.GetModelType(), metadata)
+	if err != nil {
+		log.Fatal("无法写入", path, "文件")
+	}
+	encode(w.BinaryEncoder)
+	if err := w.Close(); err != nil {
+		log.Fatal("无法写入", path, "文件，错误", err)
+	}
+}
@@ -0,0 +1,161 @@
+package supervised
+
+import (
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/dictionary"
+	"github.com/huichen/mlf/util"
+	"strconv"
+)
+
+// 随机森林模型
+//
+// NumLabels为0时为回归森林，Predict输出各树预测值的平均值；否则为分类森林，
+// 将各树叶子节点上分类比例的平均值写入LabelDistribution，返回其中最高的分类。
+type RandomForestModel struct {
+	NumLabels        int
+	FeatureDimension int
+
+	FeatureDictionary *dictionary.Dictionary
+	LabelDictionary   *dictionary.Dictionary
+
+	// 每棵树的根节点
+	Trees []*DecisionTreeNode
+
+	// 模型的元数据，见MetadataModel
+	Metadata map[string]string
+}
+
+func init() {
+	RegisterModel("random_forest", func(d *util.BinaryDecoder) Model {
+		model := new(RandomForestModel)
+		model.DecodeBinary(d)
+		return model
+	})
+}
+
+func (model *RandomForestModel) GetModelType() string {
+	return "random_forest"
+}
+
+func (model *RandomForestModel) GetMetadata() map[string]string {
+	return model.Metadata
+}
+
+func (model *RandomForestModel) SetMetadata(metadata map[string]string) {
+	model.Metadata = metadata
+}
+
+func (model *RandomForestModel) Write(path string) {
+	WriteModelFile(path, model, model.EncodeBinary)
+}
+
+// 将模型以二进制格式写入编码器
+func (model *RandomForestModel) EncodeBinary(e *util.BinaryEncoder) {
+	e.WriteInt(model.NumLabels)
+	e.WriteInt(model.FeatureDimension)
+	encodeDictionary(e, model.FeatureDictionary)
+	encodeDictionary(e, model.LabelDictionary)
+	e.WriteInt(len(model.Trees))
+	for _, tree := range model.Trees {
+		tree.encodeBinary(e)
+	}
+}
+
+// 从解码器中读入二进制格式的模型
+func (model *RandomForestModel) DecodeBinary(d *util.BinaryDecoder) {
+	model.NumLabels = d.ReadInt()
+	model.FeatureDimension = d.ReadInt()
+	model.FeatureDictionary = decodeDictionary(d)
+	model.LabelDictionary = decodeDictionary(d)
+	numTrees := d.ReadLength()
+	model.Trees = nil
+	for i := 0; i < numTrees && d.Err() == nil; i++ {
+		model.Trees = append(model.Trees, decodeDecisionTreeNode(d))
+	}
+}
+
+func (model *RandomForestModel) Predict(instance *data.Instance) data.InstanceOutput {
+	output := data.InstanceOutput{}
+
+	// 当使用NamedFeatures时转化为Features
+	if !convertNamedFeatures(instance, model.FeatureDictionary) {
+		return output
+	}
+	if len(model.Trees) == 0 {
+		return output
+	}
+
+	if model.NumLabels == 0 {
+		for _, tree := range model.Trees {
+			output.Value += tree.Leaf(instance.Features).Value
+		}
+		output.Value /= float64(len(model.Trees))
+		return output
+	}
+	distribution := make([]float64, model.NumLabels)
+	for _, tree := range model.Trees {
+		for iLabel, p := range tree.Leaf(instance.Features).Distribution {
+			distribution[iLabel] += p / float64(len(model.Trees))
+		}
+	}
+	output.LabelDistribution = util.NewVector(model.NumLabels)
+	output.LabelDistribution.SetValues(distribution)
+	for iLabel, p := range distribution {
+		if p > distribution[output.Label] {
+			output.Label = iLabel
+		}
+	}
+	if model.LabelDictionary != nil {
+		output.LabelString = model.LabelDictionary.GetNameFromId(output.Label)
+	}
+	return output
+}
+
+// 基于不纯度的特征重要性（mean decrease in impurity）
+//
+// 每棵树中一个特征的重要性为以该特征分裂的节点上样本数加权的不纯度减少之和，归一化
+// 使各特征之和为1，森林中特征的重要性为各树的平均值。返回值的键为特征词典中的特征名，
+// 没有特征词典时为特征的整数ID。
+func (model *RandomForestModel) FeatureImportances() map[string]float64 {
+	importances := map[int]float64{}
+	for _, tree := range model.Trees {
+		treeImportances := map[int]float64{}
+		addImpurityDecrease(tree, treeImportances)
+		total := 0.0
+		for _, decrease := range treeImportances {
+			total += decrease
+		}
+		if total == 0 {
+			continue
+		}
+		for feature, decrease := range treeImportances {
+			importances[feature] += decrease / total / float64(len(model.Trees))
+		}
+	}
+	return namedFeatureImportances(importances, model.FeatureDictionary)
+}
+
+// 将特征ID换成特征词典中的特征名，词典为nil时为特征ID
+func namedFeatureImportances(importances map[int]float64, dict *dictionary.Dictionary) map[string]float64 {
+	named := map[string]float64{}
+	for feature, importance := range importances {
+		name := strconv.Itoa(feature)
+		if dict != nil {
+			name = dict.GetNameFromId(feature)
+		}
+		named[name] = importance
+	}
+	return named
+}
+
+// 将子树中每个内部节点的不纯度减少加到分裂特征上
+func addImpurityDecrease(node *DecisionTreeNode, importances map[int]float64) {
+	if node.IsLeaf() {
+		return
+	}
+	importances[node.Feature] += node.Impurity*float64(node.NumInstances) -
+		node.Left.Impurity*float64(node.Left.NumInstances) -
+		node.Right.Impurity*float64(node.Right.NumInstances)
+	addImpurityDecrease(node.Left, importances)
+	addImpurityDecrease(node.Right, importances)
+}
@@ -0,0 +1,121 @@
+package supervised
+
+import (
+	"context"
+	"github.com/huichen/mlf/contrib"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/optimizer"
+	"github.com/huichen/mlf/util"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestRandomForestClassifier(t *testing.T) {
+	set := contrib.LoadLibSVMDataset("../testdata/a1a", true)
+	testSet := contrib.LoadLibSVMDataset("../testdata/a1a.t", true)
+	trainer := NewRandomForestTrainer(TrainerOptions{
+		Options: RandomForestOptions{NumTrees: 50, Tree: DecisionTreeOptions{MinSamplesLeaf: 2}},
+	})
+	model := trainer.Train(context.Background(), set).(*RandomForestModel)
+	util.Expect(t, "50", len(model.Trees))
+
+	accuracy := svmAccuracy(model, testSet)
+	oobError, _ := strconv.ParseFloat(model.Metadata["oob_error"], 64)
+	t.Logf("测试集准确度 = %.2f %%，袋外错误率 = %.2f %%", accuracy*100, oobError*100)
+	util.Expect(t, "true", accuracy > 0.82)
+	util.Expect(t, "true", math.Abs(oobError-(1-accuracy)) < 0.03)
+
+	// 特征重要性之和为1，键为特征词典中的特征名
+	total := 0.0
+	for name, importance := range model.FeatureImportances() {
+		util.Expect(t, "true", set.GetFeatureDictionary().TranslateIdFromName(name) > 0)
+		total += importance
+	}
+	util.ExpectNear(t, 1, total, 1e-9)
+}
+
+func TestRandomForestThreads(t *testing.T) {
+	// 结果和线程数无关
+	set := newXORTestDataset(false)
+	models := []*RandomForestModel{}
+	for _, numThreads := range []int{1, 4} {
+		trainer := NewRandomForestTrainer(TrainerOptions{
+			Options: RandomForestOptions{NumTrees: 10, NumThreads: numThreads, Seed: 3},
+		})
+		models = append(models, trainer.Train(context.Background(), set).(*RandomForestModel))
+	}
+	util.Expect(t, models[0].Metadata["oob_error"], models[1].Metadata["oob_error"])
+	for i := range models[0].Trees {
+		util.Expect(t, strconv.Itoa(models[0].Trees[i].NumLeaves()), models[1].Trees[i].NumLeaves())
+	}
+}
+
+func TestRandomForestRegressor(t *testing.T) {
+	// y = sin(2 * pi * x1)加上噪声，x2是噪声
+	r := rand.New(rand.NewSource(1))
+	set := data.NewInmemDataset()
+	for i := 0; i < 500; i++ {
+		x1, x2 := r.Float64(), r.Float64()
+		instance := &data.Instance{
+			Features: util.NewVector(3),
+			Output:   &data.InstanceOutput{Value: math.Sin(2*math.Pi*x1) + 0.1*r.NormFloat64()},
+		}
+		instance.Features.SetValues([]float64{1, x1, x2})
+		set.AddInstance(instance)
+	}
+	set.Finalize()
+
+	trainer := NewRandomForestTrainer(TrainerOptions{
+		Options: RandomForestOptions{NumTrees: 30, Tree: DecisionTreeOptions{Criterion: "mse", MinSamplesLeaf: 5}},
+	})
+	model := trainer.Train(context.Background(), set).(*RandomForestModel)
+	oobError, _ := strconv.ParseFloat(model.Metadata["oob_error"], 64)
+	t.Logf("袋外均方误差 = %.4f", oobError)
+	util.Expect(t, "true", oobError < 0.03)
+
+	instance := &data.Instance{Features: util.NewVector(3)}
+	instance.Features.SetValues([]float64{1, 0.25, 0.5})
+	util.ExpectNear(t, 1, model.Predict(instance).Value, 0.15)
+
+	// x1比x2重要得多
+	importances := model.FeatureImportances()
+	util.Expect(t, "true", importances["1"] > 10*importances["2"])
+}
+
+func TestRandomForestModel(t *testing.T) {
+	set := newXORTestDataset(true)
+	trainer := NewRandomForestTrainer(TrainerOptions{Options: RandomForestOptions{NumTrees: 5}})
+	model := trainer.Train(context.Background(), set).(*RandomForestModel)
+	model.Write("test_forest.mlf")
+	loaded := LoadModel("test_forest.mlf").(*RandomForestModel)
+	os.Remove("test_forest.mlf")
+
+	util.Expect(t, "5", len(loaded.Trees))
+	util.Expect(t, "5", loaded.Metadata["num_trees"])
+	iterator := set.CreateIterator()
+	for iterator.Start(); !iterator.End(); iterator.Next() {
+		instance := iterator.GetInstance()
+		expected := model.Predict(instance).LabelDistribution
+		actual := loaded.Predict(instance).LabelDistribution
+		util.ExpectNear(t, expected.Get(1), actual.Get(1), 1e-12)
+	}
+}
+
+func TestRandomForestProgress(t *testing.T) {
+	// 每训练完一棵树汇报一次
+	set := newXORTestDataset(false)
+	iterations := []int{}
+	trainer := NewRandomForestTrainer(TrainerOptions{
+		Optimizer: optimizer.OptimizerOptions{
+			Progress: func(progress optimizer.Progress) {
+				iterations = append(iterations, progress.Iteration)
+			},
+		},
+		Options: RandomForestOptions{NumTrees: 8, NumThreads: 4},
+	})
+	trainer.Train(context.Background(), set)
+	util.Expect(t, "[1 2 3 4 5 6 7 8]", iterations)
+}
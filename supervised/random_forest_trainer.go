@@ -0,0 +1,200 @@
+package supervised
+
+import (
+	"context"
+	"github.com/huichen/mlf/data"
+	"github.com/huichen/mlf/optimizer"
+	"log"
+	"math"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// 随机森林的选项，通过TrainerOptions.Options传入，为nil时使用默认值
+type RandomForestOptions struct {
+	// 每棵决策树的选项，见DecisionTreeOptions。Tree.MaxFeatures为0时分类森林为特征数
+	// 的平方根，回归森林为特征数的1/3；Tree.Seed不起作用
+	Tree DecisionTreeOptions
+
+	// 树的数目，值为0时为100
+	NumTrees int
+
+	// 并行训练的线程数，值为0时为CPU数
+	NumThreads int
+
+	// 有放回抽样和选择候选特征使用的随机数种子，结果和NumThreads无关
+	Seed int64
+}
+
+// 随机森林训练器
+//
+// 每棵树在对训练集有放回抽样（bootstrap）得到的同样大小的样本上训练，每次分裂只考虑
+// 随机选择的Tree.MaxFeatures个特征，见
+//   Breiman, L. (2001). "Random Forests". Machine Learning 45(1): 5-32.
+// 训练完成后用袋外样本（out-of-bag，没有被某棵树抽到的样本只用这些树预测）估计误差，
+// 分类森林为错误率，回归森林为均方误差，记录在模型元数据的"oob_error"中。
+// TrainerOptions.Optimizer中只有Progress起作用，每训练完一棵树汇报一次，Iteration为已经
+// 完成的树的数目。
+type RandomForestTrainer struct {
+	options  RandomForestOptions
+	progress optimizer.ProgressFunc
+}
+
+// 创建一个随机森林训练器
+func NewRandomForestTrainer(options TrainerOptions) Trainer {
+	trainer := new(RandomForestTrainer)
+	if options.Options != nil {
+		forestOptions, ok := options.Options.(RandomForestOptions)
+		if !ok {
+			log.Fatal("随机森林训练器的Options必须为RandomForestOptions")
+		}
+		trainer.options = forestOptions
+	}
+	trainer.progress = options.Optimizer.Progress
+	trainer.options.Tree.setDefaults()
+	if trainer.options.NumTrees == 0 {
+		trainer.options.NumTrees = 100
+	}
+	if trainer.options.NumThreads == 0 {
+		trainer.options.NumThreads = runtime.NumCPU()
+	}
+	return trainer
+}
+
+func (trainer *RandomForestTrainer) Train(ctx context.Context, set data.Dataset) Model {
+	if !set.GetOptions().IsSupervisedLearning {
+		log.Fatal("训练数据不是监督式学习数据")
+	}
+
+	builder := newDecisionTreeBuilder(trainer.options.Tree, set)
+	if options := &builder.options; options.MaxFeatures == 0 {
+		if options.Criterion == "mse" {
+			options.MaxFeatures = builder.featureDimension / 3
+		} else {
+			options.MaxFeatures = int(math.Sqrt(float64(builder.featureDimension)))
+		}
+		if options.MaxFeatures == 0 {
+			options.MaxFeatures = 1
+		}
+	}
+	numInstances := len(builder.instances)
+
+	// 每棵树使用独立的随机数种子，树i的抽样次数存在counts[i]中
+	numTrees := trainer.options.NumTrees
+	trees := make([]*DecisionTreeNode, numTrees)
+	counts := make([][]int, numTrees)
+	treeIndices := make(chan int, numTrees)
+	for i := 0; i < numTrees; i++ {
+		treeIndices <- i
+	}
+	close(treeIndices)
+	var wg sync.WaitGroup
+
+	// 进度回调函数在锁中调用，同一时间只有一个协程调用
+	var progressLock sync.Mutex
+	numFinished := 0
+	for iThread := 0; iThread < trainer.options.NumThreads; iThread++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range treeIndices {
+				if ctx.Err() != nil {
+					return
+				}
+				treeBuilder := *builder
+				treeBuilder.rand = rand.New(rand.NewSource(trainer.options.Seed + int64(i)))
+				treeBuilder.counts = make([]int, numInstances)
+				for j := 0; j < numInstances; j++ {
+					treeBuilder.counts[treeBuilder.rand.Intn(numInstances)]++
+				}
+				tree := treeBuilder.build(ctx)
+				if ctx.Err() != nil {
+					// 训练被取消，树不完整
+					return
+				}
+				trees[i], counts[i] = tree, treeBuilder.counts
+				if trainer.progress != nil {
+					progressLock.Lock()
+					numFinished++
+					trainer.progress(optimizer.Progress{Iteration: numFinished})
+					progressLock.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	model := new(RandomForestModel)
+	model.NumLabels = builder.numLabels
+	if model.NumLabels > 0 {
+		model.LabelDictionary = set.GetLabelDictionary()
+	}
+	model.FeatureDimension = set.GetOptions().FeatureDimension
+	model.FeatureDictionary = set.GetFeatureDictionary()
+	model.Metadata = map[string]string{}
+	if ctx.Err() != nil {
+		model.Metadata["canceled"] = "true"
+	}
+
+	// 只保留完整训练的树
+	treeCounts := [][]int{}
+	for i, tree := range trees {
+		if tree != nil {
+			model.Trees = append(model.Trees, tree)
+			treeCounts = append(treeCounts, counts[i])
+		}
+	}
+	model.Metadata["num_trees"] = strconv.Itoa(len(model.Trees))
+	if oobError, ok := outOfBagError(model, builder, treeCounts); ok {
+		model.Metadata["oob_error"] = strconv.FormatFloat(oobError, 'g', -1, 64)
+	}
+	return model
+}
+
+// 袋外误差：每个样本只用没有抽到它的树预测，分类为错误率，回归为均方误差。
+// 没有样本在袋外时返回false
+func outOfBagError(model *RandomForestModel, builder *decisionTreeBuilder, counts [][]int) (float64, bool) {
+	numPredicted := 0
+	totalError := 0.0
+	for i, instance := range builder.instances {
+		numTrees := 0
+		value := 0.0
+		distribution := make([]float64, model.NumLabels)
+		for iTree, tree := range model.Trees {
+			if counts[iTree][i] > 0 {
+				continue
+			}
+			numTrees++
+			leaf := tree.Leaf(instance.Features)
+			value += leaf.Value
+			for iLabel, p := range leaf.Distribution {
+				distribution[iLabel] += p
+			}
+		}
+		if numTrees == 0 {
+			continue
+		}
+		numPredicted++
+
+		if model.NumLabels == 0 {
+			residual := value/float64(numTrees) - builder.targets[i]
+			totalError += residual * residual
+			continue
+		}
+		label := 0
+		for iLabel, p := range distribution {
+			if p > distribution[label] {
+				label = iLabel
+			}
+		}
+		if label != builder.labels[i] {
+			totalError++
+		}
+	}
+	if numPredicted == 0 {
+		return 0, false
+	}
+	return totalError / float64(numPredicted), true
+}
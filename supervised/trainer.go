@@ -1,10 +1,16 @@
 package supervised
 
 import (
+	"context"
 	"github.com/huichen/mlf/data"
 )
 
 type Trainer interface {
 	// 在数据集上进行训练，得到模型
-	Train(set data.Dataset) Model
+	//
+	// ctx被取消时训练提前结束，返回目前为止最好的模型（见optimizer.Optimizer）。
+	// 训练进度通过TrainerOptions.Optimizer.Progress回调函数汇报：使用优化器的训练器每次
+	// 迭代汇报一次，GBDT每轮、随机森林每训练完一棵树、线性SVM每遍历一次数据集汇报一次，
+	// 决策树训练器不汇报进度。
+	Train(ctx context.Context, set data.Dataset) Model
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"github.com/huichen/mlf/contrib"
 	"github.com/huichen/mlf/eval"
@@ -24,15 +25,33 @@ var (
 	opt                 = flag.String("optimizer", "lbfgs", "优化器")
 	reg                 = flag.Int("regularization", 2, "正则化方法")
 	reg_factor          = flag.Float64("reg_factor", float64(1), "正则化因子")
+	elastic_net_ratio   = flag.Float64("elastic_net_ratio", 0.5, "弹性网络正则化（regularization=3）中L1正则化所占的比例")
+	exclude_bias        = flag.Bool("exclude_bias", false, "是否不对偏置特征做正则化")
+	proximal            = flag.Bool("proximal", false, "梯度递降法是否使用近端更新处理正则化项")
 	learning_rate       = flag.Float64("learning_rate", float64(1), "学习率")
 	characteristic_time = flag.Float64("characteristic_time", float64(0), "学习率特征时间")
+	lr_schedule         = flag.String("lr_schedule", "", "学习率调度：step、exponential、cosine或plateau，为空时使用characteristic_time")
+	lr_step_size        = flag.Int("lr_step_size", 0, "学习率调度的步长（cosine为第一个周期的长度）")
+	lr_decay            = flag.Float64("lr_decay", 0, "学习率调度的衰减系数，值为零时为0.1")
+	lr_warmup           = flag.Int("lr_warmup", 0, "学习率线性预热的步数")
+	converging_steps    = flag.Int("converging_steps", 3, "连续满足收敛条件超过多少次时判定为收敛")
+	lbfgs_history_size  = flag.Int("lbfgs_history_size", 5, "L-BFGS中存储的历史步长")
+	threads             = flag.Int("threads", 0, "并行计算使用的协程数，值为0时使用所有CPU")
 	batch_size          = flag.Int("batch_size", 10,
 		"梯度递降法的batch尺寸: 0为full batch, 1为stochastic, 其它值为mini batch")
 	delta = flag.Float64("delta", 1e-4,
 		"权重变化量和权重的比值(|dw|/|w|)小于此值时判定为收敛")
+	loss_delta = flag.Float64("loss_delta", 0,
+		"目标函数的相对变化小于此值时判定为收敛，值为零时不使用")
+	grad_norm = flag.Float64("grad_norm", 0,
+		"目标函数偏导数的长度小于此值时判定为收敛，值为零时不使用")
 	max_iter = flag.Int("max_iter", 0, "优化器最多迭代多少次")
 	folds    = flag.Int("folds", 0, "N-交叉评价，值为零时不交叉评价")
 
+	// 检查点
+	checkpoint = flag.String("checkpoint", "", "检查点文件，为空时不写入检查点")
+	resume     = flag.Bool("resume", false, "是否从检查点继续训练")
+
 	// 性能测试输出
 	cpuprofile = flag.String("cpuprofile", "", "处理器profile文件")
 )
@@ -47,15 +66,30 @@ func main() {
 	// 设置训练器参数
 	trainerOptions := supervised.TrainerOptions{
 		Optimizer: optimizer.OptimizerOptions{
-			OptimizerName:         *opt,
-			RegularizationScheme:  *reg,
-			RegularizationFactor:  *reg_factor,
-			LearningRate:          *learning_rate,
-			CharacteristicTime:    *characteristic_time,
-			ConvergingDeltaWeight: *delta,
-			ConvergingSteps:       3,
-			MaxIterations:         *max_iter,
-			GDBatchSize:           *batch_size,
+			OptimizerName:                 *opt,
+			RegularizationScheme:          *reg,
+			RegularizationFactor:          *reg_factor,
+			ElasticNetRatio:               *elastic_net_ratio,
+			ExcludeBiasFromRegularization: *exclude_bias,
+			ProximalRegularization:        *proximal,
+			LearningRate:                  *learning_rate,
+			CharacteristicTime:            *characteristic_time,
+			ConvergingDeltaWeight:         *delta,
+			ConvergingRelativeLoss:        *loss_delta,
+			ConvergingGradientNorm:        *grad_norm,
+			ConvergingSteps:               *converging_steps,
+			MaxIterations:                 *max_iter,
+			GDBatchSize:                   *batch_size,
+			LbfgsHistorySize:              *lbfgs_history_size,
+			NumThreads:                    *threads,
+			CheckpointPath:                *checkpoint,
+			ResumeFromCheckpoint:          *resume,
+			LearningRateSchedule: optimizer.LearningRateSchedule{
+				Name:        *lr_schedule,
+				StepSize:    *lr_step_size,
+				Decay:       *lr_decay,
+				WarmupSteps: *lr_warmup,
+			},
 		}}
 
 	// 创建训练器
@@ -75,7 +109,7 @@ func main() {
 	// evaluators := eval.NewEvaluators([]eval.Evaluator{&eval.PREvaluator{}, &eval.AccuracyEvaluator{}})
 	evaluators := eval.NewEvaluators([]eval.Evaluator{&eval.AccuracyEvaluator{}})
 	if *folds != 0 {
-		result := eval.CrossValidate(trainer, set, evaluators, *folds)
+		result := eval.CrossValidate(context.Background(), trainer, set, evaluators, *folds)
 		log.Print(*folds, "-folds 交叉评价：")
 		// log.Printf("精度   =  %.2f %%", result.Metrics["precision"]*100)
 		// log.Printf("召回率 =  %.2f %%", result.Metrics["recall"]*100)
@@ -85,7 +119,7 @@ func main() {
 	}
 
 	// 在全部数据上训练模型
-	model := trainer.Train(set)
+	model := trainer.Train(context.Background(), set)
 	model.Write(*model_file)
 
 	// 测试模型
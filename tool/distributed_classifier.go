@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/huichen/mlf/contrib"
+	"github.com/huichen/mlf/distributed"
+	"github.com/huichen/mlf/optimizer"
+	"github.com/huichen/mlf/supervised"
+	"github.com/huichen/mlf/util"
+	"log"
+	"runtime"
+	"strings"
+)
+
+// 分布式训练最大熵分类器
+//
+// 在每台机器上用数据分片启动工作进程
+//   go run distributed_classifier.go --mode worker --input shard0 --address :8000
+// 然后启动协调进程
+//   go run distributed_classifier.go --mode coordinator --workers host0:8000,host1:8000
+// 所有进程的labels参数必须相同，这样各分片的标注ID一致。特征使用libsvm文件中的整数ID，
+// 不依赖于各分片中特征出现的顺序。
+var (
+	mode = flag.String("mode", "coordinator", "coordinator或者worker")
+
+	// 工作进程参数
+	libsvm_file = flag.String("input", "", "libsvm格式的数据文件，本工作进程的数据分片")
+	address     = flag.String("address", ":8000", "工作进程监听的地址")
+
+	// 两种进程共用的参数
+	labels  = flag.String("labels", "-1,+1", "以逗号分隔的所有标注，第i个标注的ID为i")
+	threads = flag.Int("threads", 0, "并行计算使用的协程数，值为0时使用所有CPU")
+
+	// 协调进程参数
+	workers            = flag.String("workers", "", "以逗号分隔的工作进程地址")
+	model_file         = flag.String("output", "model.mlf", "模型输出")
+	opt                = flag.String("optimizer", "lbfgs", "优化器：lbfgs或owlqn")
+	reg                = flag.Int("regularization", 2, "正则化方法")
+	reg_factor         = flag.Float64("reg_factor", float64(1), "正则化因子")
+	converging_steps   = flag.Int("converging_steps", 3, "连续满足收敛条件超过多少次时判定为收敛")
+	lbfgs_history_size = flag.Int("lbfgs_history_size", 5, "L-BFGS中存储的历史步长")
+	delta              = flag.Float64("delta", 1e-4,
+		"权重变化量和权重的比值(|dw|/|w|)小于此值时判定为收敛")
+	max_iter = flag.Int("max_iter", 0, "优化器最多迭代多少次")
+)
+
+func main() {
+	flag.Parse()
+	runtime.GOMAXPROCS(runtime.NumCPU())
+
+	labelList := strings.Split(*labels, ",")
+	if len(labelList) < 2 {
+		log.Fatal("labels中至少要有两个标注")
+	}
+
+	if *mode == "worker" {
+		// 使用稀疏的整数特征，这样各分片的特征维度不需要相同，并且特征ID在各分片中一致
+		set := contrib.LoadLibSVMDatasetWithLabels(*libsvm_file, labelList)
+		worker := distributed.NewWorker(set, supervised.MaxEntComputeInstanceDerivative, *threads)
+		log.Printf("工作进程在%s上提供服务，%d个样本", *address, set.NumInstances())
+		if err := worker.ListenAndServe(*address); err != nil {
+			log.Fatal(err)
+		}
+		return
+	} else if *mode != "coordinator" {
+		log.Fatal("mode必须为coordinator或者worker")
+	}
+
+	coordinator, err := distributed.NewCoordinator(strings.Split(*workers, ","))
+	if err != nil {
+		log.Fatal("无法连接工作进程，错误", err)
+	}
+	defer coordinator.Close()
+	datasetOptions := coordinator.DatasetOptions()
+	log.Printf("连接了%d个工作进程，共%d个样本", len(strings.Split(*workers, ",")),
+		coordinator.NumInstances())
+
+	optimizerOptions := optimizer.OptimizerOptions{
+		OptimizerName:         *opt,
+		RegularizationScheme:  *reg,
+		RegularizationFactor:  *reg_factor,
+		ConvergingDeltaWeight: *delta,
+		ConvergingSteps:       *converging_steps,
+		MaxIterations:         *max_iter,
+		LbfgsHistorySize:      *lbfgs_history_size,
+		NumThreads:            *threads,
+	}
+	o := optimizer.NewOptimizer(optimizerOptions)
+	datasetLossOptimizer, ok := o.(optimizer.DatasetLossOptimizer)
+	if !ok {
+		log.Fatal("优化器", *opt, "不支持分布式训练")
+	}
+	datasetLossOptimizer.SetDatasetLoss(coordinator)
+
+	weights := util.NewSparseMatrix(len(labelList) - 1)
+	result := o.OptimizeWeights(context.Background(), weights, nil, nil)
+
+	// 词典按libsvm的整数特征ID和labels建立，模型也可以用于使用NamedFeatures的样本
+	maxFeature := 0
+	for iLabel := 0; iLabel < weights.NumLabels(); iLabel++ {
+		for _, k := range weights.GetValues(iLabel).Keys() {
+			if k > maxFeature {
+				maxFeature = k
+			}
+		}
+	}
+	classifier := new(supervised.MaxEntClassifier)
+	classifier.Weights = weights
+	classifier.NumLabels = len(labelList)
+	classifier.FeatureDimension = datasetOptions.FeatureDimension
+	classifier.FeatureDictionary, classifier.LabelDictionary =
+		contrib.NewLibSVMDictionaries(maxFeature, labelList)
+	classifier.Metadata = result.Metadata()
+	classifier.Write(*model_file)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"github.com/huichen/mlf/contrib"
 	"github.com/huichen/mlf/rbm"
@@ -22,6 +23,10 @@ var (
 	hidden    = flag.Int("hidden", 10, "多少个隐藏单元")
 	numCD     = flag.Int("cd", 1, "CD次数")
 	useBinary = flag.Bool("binary_hidden", true, "是否使用抽样隐藏单元")
+
+	// 检查点
+	checkpoint = flag.String("checkpoint", "", "检查点文件，为空时不写入检查点")
+	resume     = flag.Bool("resume", false, "是否从检查点继续训练")
 )
 
 func main() {
@@ -40,12 +45,14 @@ func main() {
 		BatchSize:            *batch_size,
 		Delta:                *delta,
 		UseBinaryHiddenUnits: *useBinary,
+		CheckpointPath:       *checkpoint,
+		ResumeFromCheckpoint: *resume,
 	}
 
 	// 创建训练器
 	machine := rbm.NewRBM(options)
 
-	machine.Train(set)
+	machine.Train(context.Background(), set)
 
 	machine.Write(*model)
 }
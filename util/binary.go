@@ -0,0 +1,200 @@
+package util
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// 二进制编码器，用于模型等结构体的紧凑串行化
+//
+// 整数使用变长编码（varint），浮点数使用8字节小端编码。编码器记录第一个写入错误，
+// 之后的写入操作不再执行，调用者只需在编码结束时检查一次Err()。
+type BinaryEncoder struct {
+	w   io.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+// 从io.Writer创建编码器
+func NewBinaryEncoder(w io.Writer) *BinaryEncoder {
+	return &BinaryEncoder{w: w}
+}
+
+// 返回编码过程中遇到的第一个错误
+func (e *BinaryEncoder) Err() error {
+	return e.err
+}
+
+// 写入原始字节
+func (e *BinaryEncoder) WriteBytes(b []byte) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(b)
+}
+
+// 写入布尔值
+func (e *BinaryEncoder) WriteBool(value bool) {
+	if value {
+		e.buf[0] = 1
+	} else {
+		e.buf[0] = 0
+	}
+	e.WriteBytes(e.buf[:1])
+}
+
+// 写入整数
+func (e *BinaryEncoder) WriteInt(value int) {
+	n := binary.PutVarint(e.buf[:], int64(value))
+	e.WriteBytes(e.buf[:n])
+}
+
+// 写入浮点数
+func (e *BinaryEncoder) WriteFloat64(value float64) {
+	binary.LittleEndian.PutUint64(e.buf[:8], math.Float64bits(value))
+	e.WriteBytes(e.buf[:8])
+}
+
+// 写入字符串
+func (e *BinaryEncoder) WriteString(value string) {
+	e.WriteInt(len(value))
+	e.WriteBytes([]byte(value))
+}
+
+// 输入的长度未知时ReadLength允许的最大长度
+const maxBinaryLength = 1 << 28
+
+// 二进制解码器，和BinaryEncoder对应
+//
+// 和编码器一样，解码器记录第一个读取错误，之后的读取操作返回零值。
+// 为了在数据损坏时报错而不是分配过多的内存，ReadLength读到的长度不能超过输入中
+// 剩余的字节数（每个元素至少占一个字节），输入的长度未知时不能超过maxBinaryLength。
+type BinaryDecoder struct {
+	r   *bufio.Reader
+	buf [8]byte
+	err error
+
+	// 输入中剩余的字节数，小于0时未知
+	remaining int64
+}
+
+// 从io.Reader创建解码器
+// r有Len方法时（比如bytes.Reader和bytes.Buffer）以它的返回值作为输入的长度
+func NewBinaryDecoder(r io.Reader) *BinaryDecoder {
+	d := &BinaryDecoder{remaining: -1}
+	if lr, ok := r.(interface {
+		Len() int
+	}); ok {
+		d.remaining = int64(lr.Len())
+	}
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	d.r = br
+	return d
+}
+
+// 设置输入中剩余的字节数，比如文件的大小
+func (d *BinaryDecoder) SetRemaining(n int64) {
+	d.remaining = n
+}
+
+// 记录从输入中读取了n个字节
+func (d *BinaryDecoder) consume(n int) {
+	if d.remaining >= 0 {
+		d.remaining -= int64(n)
+	}
+}
+
+// 读取字节时计数，用于binary.ReadVarint
+type countingByteReader struct {
+	d *BinaryDecoder
+}
+
+func (c countingByteReader) ReadByte() (byte, error) {
+	b, err := c.d.r.ReadByte()
+	if err == nil {
+		c.d.consume(1)
+	}
+	return b, err
+}
+
+// 返回解码过程中遇到的第一个错误
+func (d *BinaryDecoder) Err() error {
+	return d.err
+}
+
+// 设置解码错误，用于在解码自定义结构体时报告数据不合法
+func (d *BinaryDecoder) SetErr(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+// 读取len(b)个原始字节
+func (d *BinaryDecoder) ReadBytes(b []byte) {
+	if d.err != nil {
+		return
+	}
+	_, d.err = io.ReadFull(d.r, b)
+	d.consume(len(b))
+}
+
+// 读取布尔值
+func (d *BinaryDecoder) ReadBool() bool {
+	d.ReadBytes(d.buf[:1])
+	return d.err == nil && d.buf[0] != 0
+}
+
+// 读取整数
+func (d *BinaryDecoder) ReadInt() int {
+	if d.err != nil {
+		return 0
+	}
+	value, err := binary.ReadVarint(countingByteReader{d})
+	if err != nil {
+		d.err = err
+		return 0
+	}
+	return int(value)
+}
+
+// 读取一个非负整数，通常是长度或者数目
+// 长度不合法时设置错误并返回0，调用者应该在按长度分配内存之前检查Err()
+func (d *BinaryDecoder) ReadLength() int {
+	n := d.ReadInt()
+	if n < 0 {
+		d.SetErr(errors.New("二进制数据中的长度为负数"))
+		return 0
+	}
+	if n > maxBinaryLength || (d.remaining >= 0 && int64(n) > d.remaining) {
+		d.SetErr(fmt.Errorf("二进制数据中的长度%d超过剩余的输入", n))
+		return 0
+	}
+	return n
+}
+
+// 读取浮点数
+func (d *BinaryDecoder) ReadFloat64() float64 {
+	d.ReadBytes(d.buf[:8])
+	if d.err != nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(d.buf[:8]))
+}
+
+// 读取字符串
+func (d *BinaryDecoder) ReadString() string {
+	n := d.ReadLength()
+	if d.err != nil {
+		return ""
+	}
+	b := make([]byte, n)
+	d.ReadBytes(b)
+	return string(b)
+}
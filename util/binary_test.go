@@ -0,0 +1,106 @@
+package util
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestVectorBinary(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewBinaryEncoder(&buf)
+
+	dense := NewVector(3)
+	dense.SetValues([]float64{1, -2.5, 3})
+	dense.EncodeBinary(e)
+
+	sparse := NewSparseVector()
+	sparse.Set(7, 1.5)
+	sparse.Set(100000, -3)
+	sparse.EncodeBinary(e)
+	Expect(t, "<nil>", e.Err())
+
+	d := NewBinaryDecoder(&buf)
+	v1 := new(Vector)
+	v1.DecodeBinary(d)
+	v2 := new(Vector)
+	v2.DecodeBinary(d)
+	Expect(t, "<nil>", d.Err())
+
+	Expect(t, "false", v1.IsSparse())
+	Expect(t, "[0 1 2]", v1.Keys())
+	Expect(t, "-2.5", v1.Get(1))
+	Expect(t, "true", v2.IsSparse())
+	Expect(t, "[7 100000]", v2.Keys())
+	Expect(t, "1.5", v2.Get(7))
+	Expect(t, "-3", v2.Get(100000))
+}
+
+func TestMatrixBinary(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewBinaryEncoder(&buf)
+
+	m := NewMatrix(2, 3)
+	m.GetValues(0).SetValues([]float64{1, 2, 3})
+	m.GetValues(1).SetValues([]float64{4, 5, 6})
+	m.EncodeBinary(e)
+	encoded := buf.Bytes()
+
+	d := NewBinaryDecoder(bytes.NewReader(encoded))
+	m1 := new(Matrix)
+	m1.DecodeBinary(d)
+	Expect(t, "<nil>", d.Err())
+	Expect(t, "2", m1.NumLabels())
+	Expect(t, "3", m1.NumValues())
+	Expect(t, "6", m1.Get(1, 2))
+
+	// 截断的数据应该报错
+	d = NewBinaryDecoder(bytes.NewReader(encoded[:5]))
+	m1.DecodeBinary(d)
+	Expect(t, "true", d.Err() != nil)
+}
+
+func TestBinaryCorruptLength(t *testing.T) {
+	// 长度超过剩余的输入时报错，不分配内存
+	var buf bytes.Buffer
+	e := NewBinaryEncoder(&buf)
+	e.WriteInt(1 << 40)
+	encoded := buf.Bytes()
+
+	d := NewBinaryDecoder(bytes.NewReader(encoded))
+	Expect(t, "", d.ReadString())
+	Expect(t, "true", d.Err() != nil)
+
+	d = NewBinaryDecoder(bytes.NewReader(append([]byte{0}, encoded...)))
+	new(Vector).DecodeBinary(d)
+	Expect(t, "true", d.Err() != nil)
+
+	// 输入的长度未知时使用上限
+	d = NewBinaryDecoder(struct{ io.Reader }{bytes.NewReader(encoded)})
+	Expect(t, "0", d.ReadLength())
+	Expect(t, "true", d.Err() != nil)
+
+	// 合法的长度不受影响
+	buf.Reset()
+	e.WriteString("abc")
+	d = NewBinaryDecoder(&buf)
+	Expect(t, "abc", d.ReadString())
+	Expect(t, "<nil>", d.Err())
+}
+
+func TestModelFileCorruptLength(t *testing.T) {
+	// 模型文件中损坏的长度不能超过文件的大小
+	w, err := CreateModelFile("test_corrupt.mlf", "test", nil)
+	Expect(t, "<nil>", err)
+	w.WriteBool(false)
+	w.WriteInt(1 << 40)
+	Expect(t, "<nil>", w.Close())
+	defer os.Remove("test_corrupt.mlf")
+
+	r, err := OpenModelFile("test_corrupt.mlf")
+	Expect(t, "<nil>", err)
+	defer r.Close()
+	new(Vector).DecodeBinary(r.BinaryDecoder)
+	Expect(t, "true", r.Err() != nil)
+}
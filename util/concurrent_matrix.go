@@ -0,0 +1,177 @@
+package util
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// 协程安全的矩阵，用于多个协程无锁地异步更新共享的权重（见Hogwild优化器）
+//
+// 元素的值以math.Float64bits的形式保存，读写都是原子操作，Add使用比较并交换（CAS）
+// 循环实现，因此更新元素不需要加锁。稀疏矩阵的元素分散保存在numShards个分片中，
+// 只有在添加新的元素时才需要对所在分片加写锁，读取和更新已有元素只需要读锁。
+//
+// 和Hogwild算法一样，不同元素的更新之间没有同步：一个协程读到的一组元素可能来自
+// 其它协程的不同时刻的更新。
+type ConcurrentMatrix struct {
+	numLabels int
+	isSparse  bool
+
+	// 稠密矩阵的元素，dense[label][index]
+	dense [][]uint64
+
+	// 稀疏矩阵的分片，index对分片数取模决定所在分片
+	shards []*concurrentShard
+}
+
+type concurrentShard struct {
+	sync.RWMutex
+
+	// values[label][index]
+	values []map[int]*uint64
+}
+
+// 用m的值创建协程安全的矩阵，numShards为稀疏矩阵的分片数，稠密矩阵忽略此参数
+func NewConcurrentMatrix(m *Matrix, numShards int) *ConcurrentMatrix {
+	c := new(ConcurrentMatrix)
+	c.numLabels = m.NumLabels()
+	c.isSparse = m.IsSparse()
+	if !c.isSparse {
+		c.dense = make([][]uint64, c.numLabels)
+		for iLabel := 0; iLabel < c.numLabels; iLabel++ {
+			c.dense[iLabel] = make([]uint64, m.NumValues())
+		}
+	} else {
+		if numShards < 1 {
+			numShards = 1
+		}
+		c.shards = make([]*concurrentShard, numShards)
+		for i := range c.shards {
+			shard := new(concurrentShard)
+			shard.values = make([]map[int]*uint64, c.numLabels)
+			for iLabel := 0; iLabel < c.numLabels; iLabel++ {
+				shard.values[iLabel] = make(map[int]*uint64)
+			}
+			c.shards[i] = shard
+		}
+	}
+
+	for iLabel := 0; iLabel < c.numLabels; iLabel++ {
+		vec := m.GetValues(iLabel)
+		for _, k := range vec.Keys() {
+			if value := vec.Get(k); value != 0 {
+				c.Add(iLabel, k, value)
+			}
+		}
+	}
+	return c
+}
+
+func (c *ConcurrentMatrix) NumLabels() int {
+	return c.numLabels
+}
+
+func (c *ConcurrentMatrix) IsSparse() bool {
+	return c.isSparse
+}
+
+func (c *ConcurrentMatrix) shard(index int) *concurrentShard {
+	return c.shards[Mod(index, len(c.shards))]
+}
+
+// 返回元素的地址，稀疏矩阵中不存在的元素当create为true时添加，否则返回nil
+func (c *ConcurrentMatrix) address(label, index int, create bool) *uint64 {
+	if !c.isSparse {
+		return &c.dense[label][index]
+	}
+
+	shard := c.shard(index)
+	shard.RLock()
+	address := shard.values[label][index]
+	shard.RUnlock()
+	if address != nil || !create {
+		return address
+	}
+
+	shard.Lock()
+	defer shard.Unlock()
+	if address = shard.values[label][index]; address == nil {
+		address = new(uint64)
+		shard.values[label][index] = address
+	}
+	return address
+}
+
+// 读取第label行第index个元素
+func (c *ConcurrentMatrix) Get(label, index int) float64 {
+	address := c.address(label, index, false)
+	if address == nil {
+		return 0
+	}
+	return math.Float64frombits(atomic.LoadUint64(address))
+}
+
+// 原子地将第label行第index个元素加上delta
+func (c *ConcurrentMatrix) Add(label, index int, delta float64) {
+	address := c.address(label, index, true)
+	for {
+		old := atomic.LoadUint64(address)
+		value := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(address, old, value) {
+			return
+		}
+	}
+}
+
+// 将features中出现的特征的元素复制到m的各行中
+//
+// 用于在计算单个样本的偏导数前得到权重的局部副本。m为稀疏矩阵时先被清空。
+func (c *ConcurrentMatrix) CopyFeaturesTo(m *Matrix, features *Vector) {
+	if m.IsSparse() {
+		m.Clear()
+	}
+	for iLabel := 0; iLabel < c.numLabels; iLabel++ {
+		vec := m.GetValues(iLabel)
+		for _, k := range features.Keys() {
+			vec.Set(k, c.Get(iLabel, k))
+		}
+	}
+}
+
+// 将所有元素复制到m中，m的类型和维度必须和创建时的矩阵相同
+//
+// 复制时不应有其它协程在更新矩阵，否则得到的不是某一时刻的值。稀疏矩阵中值为零的元素
+// 不被复制。
+func (c *ConcurrentMatrix) CopyTo(m *Matrix) {
+	m.Clear()
+	if !c.isSparse {
+		for iLabel := 0; iLabel < c.numLabels; iLabel++ {
+			vec := m.GetValues(iLabel)
+			for k := range c.dense[iLabel] {
+				vec.Set(k, math.Float64frombits(atomic.LoadUint64(&c.dense[iLabel][k])))
+			}
+		}
+		return
+	}
+
+	// 按索引从小到大写入，使m中keys的顺序不依赖于map的遍历顺序
+	for iLabel := 0; iLabel < c.numLabels; iLabel++ {
+		indexes := []int{}
+		for _, shard := range c.shards {
+			shard.RLock()
+			for k := range shard.values[iLabel] {
+				indexes = append(indexes, k)
+			}
+			shard.RUnlock()
+		}
+		sort.Ints(indexes)
+		vec := m.GetValues(iLabel)
+		for _, k := range indexes {
+			if value := c.Get(iLabel, k); value != 0 {
+				vec.Set(k, value)
+			}
+		}
+	}
+}
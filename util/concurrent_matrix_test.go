@@ -0,0 +1,67 @@
+package util
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestConcurrentMatrixAdd(t *testing.T) {
+	for _, sparse := range []bool{false, true} {
+		var m *Matrix
+		if sparse {
+			m = NewSparseMatrix(2)
+		} else {
+			m = NewMatrix(2, 4)
+		}
+		m.Set(0, 1, 1)
+		m.Set(1, 3, -2)
+
+		c := NewConcurrentMatrix(m, 3)
+		Expect(t, "2", c.NumLabels())
+		Expect(t, "1", c.Get(0, 1))
+		Expect(t, "0", c.Get(0, 2))
+
+		// 多个协程同时更新同一个元素
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 1000; j++ {
+					c.Add(0, 2, 0.5)
+					c.Add(1, 3, 1)
+				}
+			}()
+		}
+		wg.Wait()
+		Expect(t, "4000", c.Get(0, 2))
+		Expect(t, "7998", c.Get(1, 3))
+
+		c.CopyTo(m)
+		Expect(t, "1", m.Get(0, 1))
+		Expect(t, "4000", m.Get(0, 2))
+		Expect(t, "7998", m.Get(1, 3))
+		if sparse {
+			Expect(t, "[1 2]", m.GetValues(0).Keys())
+		}
+	}
+}
+
+func TestConcurrentMatrixCopyFeaturesTo(t *testing.T) {
+	m := NewSparseMatrix(2)
+	m.Set(0, 1, 1)
+	m.Set(0, 5, 2)
+	m.Set(1, 5, 3)
+	c := NewConcurrentMatrix(m, 4)
+
+	features := NewSparseVector()
+	features.Set(5, 1)
+	features.Set(7, 1)
+	local := NewSparseMatrix(2)
+	local.Set(0, 1, 10)
+	c.CopyFeaturesTo(local, features)
+	Expect(t, "0", local.Get(0, 1))
+	Expect(t, "2", local.Get(0, 5))
+	Expect(t, "3", local.Get(1, 5))
+	Expect(t, "0", local.Get(1, 7))
+}
@@ -147,6 +147,13 @@ func (m *Matrix) Populate() *Matrix {
 	return ma
 }
 
+// 删除稀疏矩阵中值为零的元素，对稠密矩阵不做任何操作
+func (m *Matrix) RemoveZeros() {
+	for _, v := range m.values {
+		v.RemoveZeros()
+	}
+}
+
 // m = s * m
 func (m *Matrix) Scale(s float64) {
 	for i := 0; i < m.NumLabels(); i++ {
@@ -0,0 +1,27 @@
+package util
+
+// 对Matrix结构体进行二进制串行化
+func (m *Matrix) EncodeBinary(e *BinaryEncoder) {
+	e.WriteBool(m.isSparse)
+	e.WriteInt(m.numValues)
+	e.WriteInt(len(m.values))
+	for _, v := range m.values {
+		v.EncodeBinary(e)
+	}
+}
+
+// 对Matrix结构体进行二进制反串行化，m原有的内容会被覆盖
+func (m *Matrix) DecodeBinary(d *BinaryDecoder) {
+	m.isSparse = d.ReadBool()
+	m.numValues = d.ReadInt()
+	numLabels := d.ReadLength()
+	if d.Err() != nil {
+		return
+	}
+
+	m.values = make([]*Vector, numLabels)
+	for i := 0; i < numLabels && d.Err() == nil; i++ {
+		m.values[i] = new(Vector)
+		m.values[i].DecodeBinary(d)
+	}
+}
@@ -0,0 +1,179 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// 二进制模型文件格式
+//
+// 文件以4字节的魔数ModelFileMagic开头，之后依次是变长编码的格式版本号、模型类型
+// 字符串（见supervised.Model的GetModelType）、元数据（字符串键值对的数目，之后是按键
+// 排序的键值对），再之后是模型自己定义的二进制内容（见BinaryEncoder）。
+//
+// 当文件名以".gz"结尾时整个文件使用gzip压缩。
+//
+// 为了兼容旧的模型，OpenModelFile也能识别（可能经过gzip压缩的）JSON格式的模型文件。
+const (
+	ModelFileMagic   = "MLFB"
+	ModelFileVersion = 1
+)
+
+// 模型文件写入器
+// 请使用CreateModelFile创建，写入完毕后必须调用Close
+type ModelFileWriter struct {
+	// 模型内容通过此编码器写入
+	*BinaryEncoder
+
+	file   *os.File
+	gz     *gzip.Writer
+	buffer *bufio.Writer
+}
+
+// 创建模型文件并写入文件头，modelType为模型类型，metadata为模型的元数据（可以为nil）
+func CreateModelFile(path string, modelType string, metadata map[string]string) (*ModelFileWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &ModelFileWriter{file: f}
+	var out io.Writer = f
+	if strings.HasSuffix(path, ".gz") {
+		w.gz = gzip.NewWriter(f)
+		out = w.gz
+	}
+	w.buffer = bufio.NewWriter(out)
+	w.BinaryEncoder = NewBinaryEncoder(w.buffer)
+
+	w.WriteBytes([]byte(ModelFileMagic))
+	w.WriteInt(ModelFileVersion)
+	w.WriteString(modelType)
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	w.WriteInt(len(keys))
+	for _, k := range keys {
+		w.WriteString(k)
+		w.WriteString(metadata[k])
+	}
+	return w, nil
+}
+
+// 将缓存写入磁盘并关闭文件，返回写入过程中遇到的第一个错误
+func (w *ModelFileWriter) Close() error {
+	err := w.Err()
+	if flushErr := w.buffer.Flush(); err == nil {
+		err = flushErr
+	}
+	if w.gz != nil {
+		if gzErr := w.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// 模型文件读取器
+// 请使用OpenModelFile创建，读取完毕后必须调用Close
+type ModelFileReader struct {
+	// 二进制模型的内容通过此解码器读取
+	*BinaryDecoder
+
+	// 是否为旧的JSON格式模型文件，此时请调用DecodeJSON
+	IsJSON bool
+
+	// 二进制模型文件的格式版本号
+	Version int
+
+	// 二进制模型文件中记录的模型类型
+	ModelType string
+
+	// 二进制模型文件中记录的元数据，没有元数据时为nil
+	Metadata map[string]string
+
+	file   *os.File
+	gz     *gzip.Reader
+	reader *bufio.Reader
+}
+
+// 打开模型文件，自动识别gzip压缩以及二进制/JSON格式
+// 文件内容以流的方式读入，文件大小不受限制
+func OpenModelFile(path string) (*ModelFileReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ModelFileReader{file: f}
+	r.reader = bufio.NewReader(f)
+
+	// gzip文件以0x1f 0x8b开头
+	head, _ := r.reader.Peek(2)
+	if len(head) == 2 && head[0] == 0x1f && head[1] == 0x8b {
+		r.gz, err = gzip.NewReader(r.reader)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		r.reader = bufio.NewReader(r.gz)
+	}
+
+	magic, _ := r.reader.Peek(len(ModelFileMagic))
+	if !bytes.Equal(magic, []byte(ModelFileMagic)) {
+		r.IsJSON = true
+		return r, nil
+	}
+
+	r.BinaryDecoder = NewBinaryDecoder(r.reader)
+	if r.gz == nil {
+		if info, err := f.Stat(); err == nil {
+			r.SetRemaining(info.Size())
+		}
+	}
+	r.ReadBytes(make([]byte, len(ModelFileMagic)))
+	r.Version = r.ReadInt()
+	if r.Err() == nil && r.Version != ModelFileVersion {
+		r.SetErr(fmt.Errorf("不支持的模型文件版本%d", r.Version))
+	}
+	r.ModelType = r.ReadString()
+	numEntries := r.ReadLength()
+	for i := 0; i < numEntries && r.Err() == nil; i++ {
+		if r.Metadata == nil {
+			r.Metadata = make(map[string]string)
+		}
+		k := r.ReadString()
+		r.Metadata[k] = r.ReadString()
+	}
+	if r.Err() != nil {
+		err = r.Err()
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// 从JSON格式的模型文件中解析模型到v
+func (r *ModelFileReader) DecodeJSON(v interface{}) error {
+	return json.NewDecoder(r.reader).Decode(v)
+}
+
+// 关闭文件
+func (r *ModelFileReader) Close() error {
+	if r.gz != nil {
+		r.gz.Close()
+	}
+	return r.file.Close()
+}
@@ -15,6 +15,6 @@ func Expect(t *testing.T, expect string, actual interface{}) {
 
 func ExpectNear(t *testing.T, expect float64, actual float64, acc float64) {
 	if math.Abs(expect-actual) > acc {
-		t.Errorf("期待值=\"%s\", 实际=\"%s\"", expect, actual)
+		t.Errorf("期待值=\"%v\", 实际=\"%v\"", expect, actual)
 	}
 }
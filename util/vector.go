@@ -113,9 +113,10 @@ func (v *Vector) Increment(that *Vector, alpha float64) {
 		log.Fatal("无法对两个不同质的向量做Increment操作")
 	}
 	if v.isSparse {
-		for i, k := range that.valueMap {
+		// 按keys的顺序遍历，保证结果（包括新元素在keys中的顺序）是确定的
+		for _, i := range that.keys {
 			_, ok := v.valueMap[i]
-			v.valueMap[i] += k * alpha
+			v.valueMap[i] += that.valueMap[i] * alpha
 			if !ok {
 				v.keys = append(v.keys, i)
 			}
@@ -222,6 +223,23 @@ func (v *Vector) Populate() *Vector {
 	return r
 }
 
+// 删除稀疏向量中值为零的元素，以节省存储空间
+// 对稠密向量不做任何操作
+func (v *Vector) RemoveZeros() {
+	if !v.isSparse {
+		return
+	}
+	keys := v.keys[:0]
+	for _, k := range v.keys {
+		if v.valueMap[k] == 0 {
+			delete(v.valueMap, k)
+		} else {
+			keys = append(keys, k)
+		}
+	}
+	v.keys = keys
+}
+
 // 设置向量中单个元素的值
 func (v *Vector) Set(index int, value float64) {
 	if v.isSparse {
@@ -294,15 +312,15 @@ func (v *Vector) WeightedSum(Vector1, Vector2 *Vector, a, b float64) {
 		v.keys = make([]int, len(Vector1.keys))
 		for i, k := range Vector1.Keys() {
 			v.keys[i] = k
-			v.valueMap[k] = a * Vector1.Get(i)
+			v.valueMap[k] = a * Vector1.Get(k)
 		}
-		for i, k := range Vector2.Keys() {
+		for _, k := range Vector2.Keys() {
 			va, ok := v.valueMap[k]
 			if ok {
-				v.valueMap[k] = va + b*Vector2.Get(i)
+				v.valueMap[k] = va + b*Vector2.Get(k)
 			} else {
 				v.keys = append(v.keys, k)
-				v.valueMap[k] = b * Vector2.Get(i)
+				v.valueMap[k] = b * Vector2.Get(k)
 			}
 		}
 	} else {
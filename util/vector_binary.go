@@ -0,0 +1,43 @@
+package util
+
+// 对Vector结构体进行二进制串行化
+//
+// 稠密向量依次写入长度和所有元素的值；稀疏向量只写入非零元素的索引和值，
+// 索引的顺序和Keys()一致。
+func (v *Vector) EncodeBinary(e *BinaryEncoder) {
+	e.WriteBool(v.isSparse)
+	if v.isSparse {
+		e.WriteInt(len(v.keys))
+		for _, k := range v.keys {
+			e.WriteInt(k)
+			e.WriteFloat64(v.valueMap[k])
+		}
+	} else {
+		e.WriteInt(len(v.values))
+		for _, value := range v.values {
+			e.WriteFloat64(value)
+		}
+	}
+}
+
+// 对Vector结构体进行二进制反串行化，v原有的内容会被覆盖
+func (v *Vector) DecodeBinary(d *BinaryDecoder) {
+	isSparse := d.ReadBool()
+	length := d.ReadLength()
+	if d.Err() != nil {
+		return
+	}
+
+	if isSparse {
+		*v = *NewSparseVector()
+		for i := 0; i < length && d.Err() == nil; i++ {
+			k := d.ReadInt()
+			v.Set(k, d.ReadFloat64())
+		}
+	} else {
+		*v = *NewVector(length)
+		for i := 0; i < length && d.Err() == nil; i++ {
+			v.values[i] = d.ReadFloat64()
+		}
+	}
+}
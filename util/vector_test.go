@@ -238,4 +238,24 @@ func TestSparseWeightedSum(t *testing.T) {
 	Expect(t, "22", vec3.Get(1))
 	Expect(t, "29", vec3.Get(2))
 	Expect(t, "3", len(vec3.Keys()))
+
+	// 不连续的索引
+	vec4 := NewSparseVector()
+	vec4.Set(10, 1)
+	vec5 := NewSparseVector()
+	vec5.Set(10, 2)
+	vec5.Set(20, 3)
+	vec3.WeightedSum(vec4, vec5, 3, 4)
+	Expect(t, "11", vec3.Get(10))
+	Expect(t, "12", vec3.Get(20))
+	Expect(t, "2", len(vec3.Keys()))
+}
+
+func TestSparseRemoveZeros(t *testing.T) {
+	vec := NewSparseVector()
+	vec.SetValues([]float64{1, 0, 3, 0})
+	vec.RemoveZeros()
+	Expect(t, "[0 2]", vec.Keys())
+	Expect(t, "3", vec.Get(2))
+	Expect(t, "0", vec.Get(1))
 }
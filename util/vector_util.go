@@ -13,8 +13,9 @@ func VecDotProduct(Vector1, Vector2 *Vector) float64 {
 	var result float64
 	result = 0
 	if Vector1.IsSparse() {
-		for i, k := range Vector1.valueMap {
-			result += k * Vector2.valueMap[i]
+		// 按keys的顺序累加，保证浮点运算的结果是确定的
+		for _, i := range Vector1.keys {
+			result += Vector1.valueMap[i] * Vector2.valueMap[i]
 		}
 	} else {
 		values1 := Vector1.values